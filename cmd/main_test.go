@@ -18,6 +18,10 @@ import (
 
 	"github.com/ldebruijn/graphql-protect/internal/app/config"
 	"github.com/ldebruijn/graphql-protect/internal/business/protect"
+	"github.com/ldebruijn/graphql-protect/internal/business/trusteddocuments"
+	"github.com/ldebruijn/graphql-protect/internal/http/cors"
+	"github.com/ldebruijn/graphql-protect/internal/http/proxy"
+	"github.com/ldebruijn/graphql-protect/internal/http/readiness"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -170,7 +174,7 @@ type Product {
 }
 `,
 				cfgOverrides: func(cfg *config.Config) *config.Config {
-					cfg.ObfuscateUpstreamErrors = false
+					cfg.ObfuscateUpstreamErrors.Enabled = false
 					cfg.PersistedOperations.Enabled = true
 					cfg.PersistedOperations.Loader.Type = "local"
 					cfg.PersistedOperations.Loader.Location = "./"
@@ -375,7 +379,7 @@ type Product {
 					cfg.PersistedOperations.Loader.Type = "local"
 					cfg.PersistedOperations.Loader.Location = "./"
 					cfg.PersistedOperations.RejectOnFailure = false
-					cfg.ObfuscateUpstreamErrors = false
+					cfg.ObfuscateUpstreamErrors.Enabled = false
 					return cfg
 				},
 				mockResponse: map[string]interface{}{
@@ -676,7 +680,7 @@ type Product {
 				assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
 				actual, err := io.ReadAll(response.Body)
 				assert.NoError(t, err)
-				assert.Contains(t, string(actual), "405 - method not allowed")
+				assert.JSONEq(t, `{"errors":[{"message":"operations must be sent using a POST request"}]}`, string(actual))
 			},
 		},
 	}
@@ -863,6 +867,22 @@ func TestHttpServerReturnsInitializationErrors(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "returns error when a request timeout exceeds the write timeout",
+			cfgOverride: func(cfg *config.Config) {
+				cfg.Target.RequestTimeout.Operations = map[string]time.Duration{"Report": time.Minute}
+			},
+			wantErr: true,
+		},
+		{
+			name: "returns error when CORS allows credentials for any origin",
+			cfgOverride: func(cfg *config.Config) {
+				cfg.CORS.Enabled = true
+				cfg.CORS.AllowedOrigins = []string{"*"}
+				cfg.CORS.AllowCredentials = true
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -985,3 +1005,154 @@ func Test_parseFlags(t *testing.T) {
 		})
 	}
 }
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name          string
+		adminHost     string
+		routePaths    []string
+		wantPublic    map[string]int
+		wantAdmin     map[string]int
+		wantSameMuxes bool
+		wantErr       error
+	}{
+		{
+			name:      "operational endpoints are served by the public listener without an admin listener",
+			adminHost: "",
+			wantPublic: map[string]int{
+				"/graphql":                    http.StatusOK,
+				"/metrics":                    http.StatusOK,
+				"/internal/healthz/readiness": http.StatusOK,
+				"/internal/healthz/liveness":  http.StatusOK,
+			},
+			wantSameMuxes: true,
+		},
+		{
+			name:      "operational endpoints are only served by the admin listener",
+			adminHost: "localhost:9090",
+			wantPublic: map[string]int{
+				"/graphql":                    http.StatusOK,
+				"/metrics":                    http.StatusNotFound,
+				"/internal/healthz/readiness": http.StatusNotFound,
+				"/internal/healthz/liveness":  http.StatusNotFound,
+			},
+			wantAdmin: map[string]int{
+				"/graphql":                    http.StatusNotFound,
+				"/metrics":                    http.StatusOK,
+				"/internal/healthz/readiness": http.StatusOK,
+				"/internal/healthz/liveness":  http.StatusOK,
+			},
+		},
+		{
+			name:       "paths of routes receive GraphQL traffic",
+			adminHost:  "",
+			routePaths: []string{"/v2/graphql", "/graphql", "/v2/graphql"},
+			wantPublic: map[string]int{
+				"/graphql":    http.StatusOK,
+				"/v2/graphql": http.StatusOK,
+				"/v3/graphql": http.StatusNotFound,
+			},
+			wantSameMuxes: true,
+		},
+		{
+			name:       "paths of routes can't overlap with operational endpoints on the same listener",
+			adminHost:  "",
+			routePaths: []string{"/metrics"},
+			wantErr:    ErrPathConflict,
+		},
+		{
+			name:       "paths of routes can overlap with operational endpoints on the admin listener",
+			adminHost:  "localhost:9090",
+			routePaths: []string{"/metrics"},
+			wantPublic: map[string]int{
+				"/metrics": http.StatusOK,
+			},
+			wantAdmin: map[string]int{
+				"/metrics": http.StatusOK,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := config.NewConfig("")
+			cfg.Web.AdminHost = tt.adminHost
+			for _, path := range tt.routePaths {
+				cfg.Target.Routes = append(cfg.Target.Routes, proxy.RouteConfig{Upstream: "default", Match: proxy.MatchConfig{Path: path}})
+			}
+
+			loader, err := trusteddocuments.NewNoOpLoader()
+			assert.NoError(t, err)
+			po, err := trusteddocuments.NewPersistedOperations(slog.Default(), cfg.PersistedOperations, loader)
+			assert.NoError(t, err)
+
+			corsHandler, err := cors.NewCORS(cfg.CORS)
+			assert.NoError(t, err)
+
+			public, admin, err := routes(slog.Default(), cfg, po, map[string]readiness.Check{}, corsHandler, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+
+			assert.Equal(t, tt.wantSameMuxes, public == admin)
+			for path, status := range tt.wantPublic {
+				w := httptest.NewRecorder()
+				public.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				assert.Equal(t, status, w.Code, path)
+			}
+			for path, status := range tt.wantAdmin {
+				w := httptest.NewRecorder()
+				admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				assert.Equal(t, status, w.Code, path)
+			}
+		})
+	}
+}
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name       string
+		period     time.Duration
+		signal     bool
+		wantAtMost time.Duration
+	}{
+		{
+			name:       "reports not ready without waiting when no drain period is configured",
+			period:     0,
+			wantAtMost: 100 * time.Millisecond,
+		},
+		{
+			name:       "waits for the drain period",
+			period:     200 * time.Millisecond,
+			wantAtMost: time.Second,
+		},
+		{
+			name:       "a second signal skips the drain period",
+			period:     time.Minute,
+			signal:     true,
+			wantAtMost: time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			draining := readiness.NewDraining()
+			shutdown := make(chan os.Signal, 1)
+			if tt.signal {
+				shutdown <- syscall.SIGTERM
+			}
+
+			start := time.Now()
+			drain(slog.Default(), tt.period, draining, shutdown)
+			elapsed := time.Since(start)
+
+			assert.Equal(t, readiness.StatusDown, draining.Check().Status)
+			assert.LessOrEqual(t, elapsed, tt.wantAtMost)
+			if !tt.signal {
+				assert.GreaterOrEqual(t, elapsed, tt.period)
+			}
+		})
+	}
+}
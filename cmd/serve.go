@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ldebruijn/graphql-protect/internal/app/config"
+	protecthttp "github.com/ldebruijn/graphql-protect/internal/app/http"
 	_ "github.com/ldebruijn/graphql-protect/internal/app/metrics"
 	"github.com/ldebruijn/graphql-protect/internal/app/otel"
 	"github.com/ldebruijn/graphql-protect/internal/business/protect"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/block_field_suggestions"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/field_masking"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/max_response"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/obfuscate_upstream_errors"
 	"github.com/ldebruijn/graphql-protect/internal/business/schema"
 	"github.com/ldebruijn/graphql-protect/internal/business/trusteddocuments"
+	"github.com/ldebruijn/graphql-protect/internal/http/cache"
+	"github.com/ldebruijn/graphql-protect/internal/http/coalesce"
+	"github.com/ldebruijn/graphql-protect/internal/http/cors"
 	"github.com/ldebruijn/graphql-protect/internal/http/debug"
 	"github.com/ldebruijn/graphql-protect/internal/http/middleware"
 	"github.com/ldebruijn/graphql-protect/internal/http/proxy"
@@ -21,8 +28,12 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"slices"
+	"time"
 )
 
+var ErrPathConflict = errors.New("path is already used by an operational endpoint, configure `web.admin_host` to serve these separately")
+
 func httpServer(log *slog.Logger, cfg *config.Config, shutdown chan os.Signal) error { // nolint:funlen,cyclop
 	log.Info("startup", "GOMAXPROCS", runtime.GOMAXPROCS(0))
 
@@ -33,14 +44,13 @@ func httpServer(log *slog.Logger, cfg *config.Config, shutdown chan os.Signal) e
 
 	log.Info("Starting proxy", "target", cfg.Target.Host)
 
-	blockFieldSuggestions := block_field_suggestions.NewBlockFieldSuggestionsHandler(cfg.BlockFieldSuggestions)
-	obfuscateUpstreamErrors := obfuscate_upstream_errors.NewObfuscateUpstreamErrors(cfg.ObfuscateUpstreamErrors)
-
-	pxy, err := proxy.NewProxy(cfg.Target, blockFieldSuggestions, obfuscateUpstreamErrors, cfg.LogGraphqlErrors, log)
+	blockFieldSuggestions, err := block_field_suggestions.NewBlockFieldSuggestionsHandler(cfg.BlockFieldSuggestions)
 	if err != nil {
-		log.Error("ErrorPayload creating proxy", "err", err)
+		log.Error("Error initializing block field suggestions", "err", err)
 		return err
 	}
+	obfuscateUpstreamErrors := obfuscate_upstream_errors.NewObfuscateUpstreamErrors(cfg.ObfuscateUpstreamErrors, log)
+	maxResponse := max_response.NewMaxResponseRule(cfg.MaxResponse)
 
 	loader, err := trusteddocuments.NewLoaderFromConfig(cfg.PersistedOperations, log)
 	if err != nil {
@@ -60,20 +70,49 @@ func httpServer(log *slog.Logger, cfg *config.Config, shutdown chan os.Signal) e
 		return err
 	}
 
-	protectHandler, err := protect.NewGraphQLProtect(log, cfg, po, schemaProvider, pxy)
+	fieldMasking, err := field_masking.NewFieldMasking(cfg.FieldMasking, schemaProvider)
 	if err != nil {
-		log.Error("Error initializing GraphQL Protect", "err", err)
+		log.Error("Error initializing field masking", "err", err)
 		return err
 	}
 
-	mux := http.NewServeMux()
+	if err := cfg.Target.RequestTimeout.ValidateWriteTimeout(cfg.Web.WriteTimeout); err != nil {
+		log.Error("Error validating request timeouts", "err", err)
+		return err
+	}
+
+	pxy, err := proxy.NewProxy(cfg.Target, blockFieldSuggestions, obfuscateUpstreamErrors, maxResponse, fieldMasking, cfg.LogGraphqlErrors, log)
+	if err != nil {
+		log.Error("ErrorPayload creating proxy", "err", err)
+		return err
+	}
+
+	responseCache, err := cache.NewResponseCache(cfg.ResponseCache, schemaProvider, fieldMasking.VaryHeaders(cfg.Authentication)...)
+	if err != nil {
+		log.Error("Error initializing response cache", "err", err)
+		return err
+	}
+
+	coalescer := coalesce.NewCoalescer(cfg.RequestCoalescing, fieldMasking.VaryHeaders(cfg.Authentication)...)
+
+	protectHandler, err := protect.NewGraphQLProtect(log, cfg, po, schemaProvider, responseCache.Handle(coalescer.Handle(pxy)))
+	if err != nil {
+		log.Error("Error initializing GraphQL Protect", "err", err)
+		return err
+	}
 
-	mid := protectMiddlewareChain(log)
+	corsHandler, err := cors.NewCORS(cfg.CORS)
+	if err != nil {
+		log.Error("Error initializing CORS", "err", err)
+		return err
+	}
 
-	mux.Handle("/metrics", promhttp.Handler())
-	mux.Handle("/internal/healthz/readiness", readiness.NewReadinessHandler())
-	mux.Handle("/internal/debug_trusted_documents", debug.NewTrustedDocumentsDebugger(po, cfg.PersistedOperations.EnableDebugEndpoint))
-	mux.Handle(cfg.Web.Path, mid(protectHandler))
+	draining := readiness.NewDraining()
+	mux, adminMux, err := routes(log, cfg, po, readinessChecks(cfg, schemaProvider, po, pxy, draining), corsHandler, protectHandler)
+	if err != nil {
+		log.Error("Error registering routes", "err", err)
+		return err
+	}
 
 	api := http.Server{
 		Addr:         cfg.Web.Host,
@@ -83,11 +122,47 @@ func httpServer(log *slog.Logger, cfg *config.Config, shutdown chan os.Signal) e
 		IdleTimeout:  cfg.Web.IdleTimeout,
 	}
 
-	serverErrors := make(chan error, 1)
+	var admin *http.Server
+	if cfg.Web.AdminHost != "" {
+		admin = &http.Server{
+			Addr:         cfg.Web.AdminHost,
+			Handler:      adminMux,
+			ReadTimeout:  cfg.Web.ReadTimeout,
+			WriteTimeout: cfg.Web.WriteTimeout,
+			IdleTimeout:  cfg.Web.IdleTimeout,
+		}
+	}
+
+	var serverTLS *protecthttp.ServerTLS
+	if cfg.Web.TLS.Enabled {
+		serverTLS, err = protecthttp.NewServerTLS(cfg.Web.TLS, log)
+		if err != nil {
+			log.Error("Error initializing TLS", "err", err)
+			return err
+		}
+		serverTLS.Start()
+		defer serverTLS.Shutdown()
+		api.TLSConfig = serverTLS.Config()
+	}
+
+	serverErrors := make(chan error, 2)
+
+	if admin != nil {
+		go func() {
+			log.Info("startup", "status", "admin listener started", "host", admin.Addr)
+
+			serverErrors <- admin.ListenAndServe()
+		}()
+	}
 
 	go func() {
-		log.Info("startup", "status", "graphql-protect started", "host", api.Addr)
+		log.Info("startup", "status", "graphql-protect started", "host", api.Addr, "tls", serverTLS != nil)
 
+		if serverTLS != nil {
+			// the certificates are provided by the TLS config
+			serverErrors <- api.ListenAndServeTLS("", "")
+			return
+		}
 		serverErrors <- api.ListenAndServe()
 	}()
 
@@ -99,18 +174,31 @@ func httpServer(log *slog.Logger, cfg *config.Config, shutdown chan os.Signal) e
 		log.Info("shutdown", "status", "shutdown started", "signal", sig)
 		defer log.Info("shutdown", "status", "shutdown complete", "signal", sig)
 
+		drain(log, cfg.Web.DrainPeriod, draining, shutdown)
+
+		// the shutdown timeout starts after draining, so draining doesn't take time away from in-flight requests
 		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
 		defer cancel()
 
-		po.Shutdown()
+		if err := api.Shutdown(ctx); err != nil {
+			_ = api.Close()
+			return fmt.Errorf("could not stop server gracefully: %w", err)
+		}
 
+		// stopped after the listener, so in-flight requests are still logged and served with up to date trusted documents
 		if err := protectHandler.ShutdownAccessLogging(ctx); err != nil {
 			log.Error("Error shutting down access logging", "err", err)
 		}
+		protectHandler.ShutdownAuthentication()
+		po.Shutdown()
+		pxy.Shutdown()
 
-		if err := api.Shutdown(ctx); err != nil {
-			_ = api.Close()
-			return fmt.Errorf("could not stop server gracefully: %w", err)
+		// the admin listener is stopped last, so operational endpoints remain available while draining
+		if admin != nil {
+			if err := admin.Shutdown(ctx); err != nil {
+				_ = admin.Close()
+				return fmt.Errorf("could not stop admin listener gracefully: %w", err)
+			}
 		}
 		if err := shutDownTracer(ctx); err != nil {
 			log.Error("Could not shutdown tracing gracefully", "err", err)
@@ -120,13 +208,90 @@ func httpServer(log *slog.Logger, cfg *config.Config, shutdown chan os.Signal) e
 	return nil
 }
 
-func protectMiddlewareChain(log *slog.Logger) func(next http.Handler) http.Handler {
+// drain reports not ready while continuing to serve for the drain period, so load balancers stop sending traffic before the listener is closed.
+// A second signal skips the remainder of the drain period.
+func drain(log *slog.Logger, period time.Duration, draining *readiness.Draining, shutdown chan os.Signal) {
+	draining.Start()
+	if period <= 0 {
+		return
+	}
+
+	log.Info("shutdown", "status", "draining", "period", period)
+	select {
+	case <-time.After(period):
+	case sig := <-shutdown:
+		log.Info("shutdown", "status", "draining interrupted", "signal", sig)
+	}
+}
+
+// routes returns the mux of the public listener, serving only the GraphQL path, and the mux serving the operational endpoints.
+// The operational endpoints are served by the public listener as well when no admin listener is configured.
+// routes serves GraphQL traffic on `web.path` and on the paths routes match on
+func routes(log *slog.Logger, cfg *config.Config, po *trusteddocuments.Handler, checks map[string]readiness.Check, corsHandler *cors.CORS, protectHandler http.Handler) (*http.ServeMux, *http.ServeMux, error) {
+	mux := http.NewServeMux()
+	adminMux := mux
+	if cfg.Web.AdminHost != "" {
+		adminMux = http.NewServeMux()
+	}
+
+	mid := protectMiddlewareChain(log, corsHandler)
+
+	operational := map[string]http.Handler{
+		"/metrics":                          promhttp.Handler(),
+		"/internal/healthz/readiness":       readiness.NewReadinessHandler(checks),
+		"/internal/healthz/liveness":        readiness.NewLivenessHandler(),
+		"/internal/debug_trusted_documents": debug.NewTrustedDocumentsDebugger(po, cfg.PersistedOperations.EnableDebugEndpoint),
+	}
+	for path, handler := range operational {
+		adminMux.Handle(path, handler)
+	}
+
+	graphql := mid(protectHandler)
+	paths := append([]string{cfg.Web.Path}, cfg.Target.RoutePaths()...)
+	for i, path := range paths {
+		if slices.Contains(paths[:i], path) {
+			continue
+		}
+		if _, ok := operational[path]; ok && adminMux == mux {
+			return nil, nil, fmt.Errorf("%w: %s", ErrPathConflict, path)
+		}
+		mux.Handle(path, graphql)
+	}
+
+	return mux, adminMux, nil
+}
+
+// readinessChecks returns the components that have to be available before protect is ready to receive traffic
+func readinessChecks(cfg *config.Config, schemaProvider *schema.Provider, po *trusteddocuments.Handler, pxy *proxy.Proxy, draining *readiness.Draining) map[string]readiness.Check {
+	checks := map[string]readiness.Check{
+		"server": draining.Check,
+		"schema": func() readiness.Component {
+			return readiness.Loaded(schemaProvider.LastReload())
+		},
+	}
+	if cfg.PersistedOperations.Enabled {
+		checks["trusted_documents"] = func() readiness.Component {
+			return readiness.Loaded(po.LastReload())
+		}
+	}
+	if cfg.Readiness.Upstreams {
+		for name := range pxy.UpstreamHealth() {
+			checks["upstream/"+name] = func() readiness.Component {
+				health := pxy.UpstreamHealth()[name]
+				return readiness.Healthy(health.Healthy, health.LastCheck)
+			}
+		}
+	}
+	return checks
+}
+
+func protectMiddlewareChain(log *slog.Logger, corsHandler *cors.CORS) func(next http.Handler) http.Handler {
 	rec := middleware.Recover(log)
 	httpInstrumentation := middleware.RequestMetricMiddleware()
 	otelHandler := otelhttp.NewMiddleware("GraphQL Protect")
 
 	fn := func(next http.Handler) http.Handler {
-		return rec(otelHandler(httpInstrumentation(next)))
+		return rec(otelHandler(corsHandler.Handle(httpInstrumentation(next))))
 	}
 
 	return fn
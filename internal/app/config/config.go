@@ -6,14 +6,24 @@ import (
 	"github.com/ldebruijn/graphql-protect/internal/app/log"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/accesslogging"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/aliases"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/authentication"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/batch"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/block_field_suggestions"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/csrf_prevention"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/enforce_post"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/field_masking"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/max_depth"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/max_response"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/obfuscate_upstream_errors"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/tokens"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/uploads"
 	"github.com/ldebruijn/graphql-protect/internal/business/schema"
 	"github.com/ldebruijn/graphql-protect/internal/business/trusteddocuments"
+	"github.com/ldebruijn/graphql-protect/internal/http/cache"
+	"github.com/ldebruijn/graphql-protect/internal/http/coalesce"
+	"github.com/ldebruijn/graphql-protect/internal/http/cors"
 	"github.com/ldebruijn/graphql-protect/internal/http/proxy"
+	"github.com/ldebruijn/graphql-protect/internal/http/readiness"
 	y "gopkg.in/yaml.v3"
 	"os"
 )
@@ -21,21 +31,30 @@ import (
 var ErrConfigFileNotFound = errors.New("config file could not be found, defaults applied")
 
 type Config struct {
-	Web                       http.Config                    `yaml:"web"`
-	Schema                    schema.Config                  `yaml:"schema"`
-	Target                    proxy.Config                   `yaml:"target"`
-	PersistedOperations       trusteddocuments.Config        `yaml:"persisted_operations"`
-	ObfuscateValidationErrors bool                           `yaml:"obfuscate_validation_errors"`
-	ObfuscateUpstreamErrors   bool                           `yaml:"obfuscate_upstream_errors"`
-	BlockFieldSuggestions     block_field_suggestions.Config `yaml:"block_field_suggestions"`
-	MaxTokens                 tokens.Config                  `yaml:"max_tokens"`
-	MaxAliases                aliases.Config                 `yaml:"max_aliases"`
-	EnforcePost               enforce_post.Config            `yaml:"enforce_post"`
-	MaxDepth                  max_depth.Config               `yaml:"max_depth"`
-	MaxBatch                  batch.Config                   `yaml:"max_batch"`
-	AccessLogging             accesslogging.Config           `yaml:"access_logging"`
-	Log                       log.Config                     `yaml:"log"`
-	LogGraphqlErrors          bool                           `yaml:"log_graphql_errors"`
+	Web                       http.Config                      `yaml:"web"`
+	CORS                      cors.Config                      `yaml:"cors"`
+	Readiness                 readiness.Config                 `yaml:"readiness"`
+	Schema                    schema.Config                    `yaml:"schema"`
+	Target                    proxy.Config                     `yaml:"target"`
+	ResponseCache             cache.Config                     `yaml:"response_cache"`
+	RequestCoalescing         coalesce.Config                  `yaml:"request_coalescing"`
+	PersistedOperations       trusteddocuments.Config          `yaml:"persisted_operations"`
+	ObfuscateValidationErrors bool                             `yaml:"obfuscate_validation_errors"`
+	ObfuscateUpstreamErrors   obfuscate_upstream_errors.Config `yaml:"obfuscate_upstream_errors"`
+	BlockFieldSuggestions     block_field_suggestions.Config   `yaml:"block_field_suggestions"`
+	FieldMasking              field_masking.Config             `yaml:"field_masking"`
+	MaxTokens                 tokens.Config                    `yaml:"max_tokens"`
+	MaxAliases                aliases.Config                   `yaml:"max_aliases"`
+	EnforcePost               enforce_post.Config              `yaml:"enforce_post"`
+	CSRFPrevention            csrf_prevention.Config           `yaml:"csrf_prevention"`
+	Authentication            authentication.Config            `yaml:"authentication"`
+	MaxDepth                  max_depth.Config                 `yaml:"max_depth"`
+	MaxBatch                  batch.Config                     `yaml:"max_batch"`
+	MaxResponse               max_response.Config              `yaml:"max_response"`
+	Uploads                   uploads.Config                   `yaml:"uploads"`
+	AccessLogging             accesslogging.Config             `yaml:"access_logging"`
+	Log                       log.Config                       `yaml:"log"`
+	LogGraphqlErrors          bool                             `yaml:"log_graphql_errors"`
 }
 
 func (c Config) String() string {
@@ -70,17 +89,26 @@ func NewConfig(configPath string) (*Config, error) {
 func defaults() Config {
 	return Config{
 		Web:                       http.DefaultConfig(),
+		CORS:                      cors.DefaultConfig(),
+		Readiness:                 readiness.DefaultConfig(),
 		Schema:                    schema.DefaultConfig(),
 		Target:                    proxy.DefaultConfig(),
+		ResponseCache:             cache.DefaultConfig(),
+		RequestCoalescing:         coalesce.DefaultConfig(),
 		PersistedOperations:       trusteddocuments.DefaultConfig(),
 		ObfuscateValidationErrors: false,
-		ObfuscateUpstreamErrors:   true,
+		ObfuscateUpstreamErrors:   obfuscate_upstream_errors.DefaultConfig(),
 		BlockFieldSuggestions:     block_field_suggestions.DefaultConfig(),
+		FieldMasking:              field_masking.DefaultConfig(),
 		MaxTokens:                 tokens.DefaultConfig(),
 		MaxAliases:                aliases.DefaultConfig(),
 		EnforcePost:               enforce_post.DefaultConfig(),
+		CSRFPrevention:            csrf_prevention.DefaultConfig(),
+		Authentication:            authentication.DefaultConfig(),
 		MaxDepth:                  max_depth.DefaultConfig(),
 		MaxBatch:                  batch.DefaultConfig(),
+		MaxResponse:               max_response.DefaultConfig(),
+		Uploads:                   uploads.DefaultConfig(),
 		AccessLogging:             accesslogging.DefaultConfig(),
 		Log:                       log.DefaultConfig(),
 		LogGraphqlErrors:          false,
@@ -9,14 +9,24 @@ import (
 	"github.com/ldebruijn/graphql-protect/internal/app/log"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/accesslogging"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/aliases"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/authentication"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/batch"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/block_field_suggestions"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/csrf_prevention"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/enforce_post"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/field_masking"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/max_depth"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/max_response"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/obfuscate_upstream_errors"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/tokens"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/uploads"
 	"github.com/ldebruijn/graphql-protect/internal/business/schema"
 	"github.com/ldebruijn/graphql-protect/internal/business/trusteddocuments"
+	"github.com/ldebruijn/graphql-protect/internal/http/cache"
+	"github.com/ldebruijn/graphql-protect/internal/http/coalesce"
+	"github.com/ldebruijn/graphql-protect/internal/http/cors"
 	"github.com/ldebruijn/graphql-protect/internal/http/proxy"
+	"github.com/ldebruijn/graphql-protect/internal/http/readiness"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
 )
@@ -48,14 +58,132 @@ web:
   write_timeout: 1s
   idle_timeout: 1s
   shutdown_timeout: 1s
+  drain_period: 1s
   host: host
+  admin_host: admin
   path: path
   request_body_max_bytes: 2048
+  content_types:
+    - application/json
+  tls:
+    enabled: true
+    cert_file: server.crt
+    key_file: server.key
+    min_version: "1.3"
+    cipher_suites:
+      - TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+    client_auth: require_and_verify
+    client_ca_file: client-ca.crt
+    auto_reload:
+      enabled: true
+      interval: 1s
+
+cors:
+  enabled: true
+  allowed_origins:
+    - https://*.example.com
+  allowed_methods:
+    - POST
+  allowed_headers:
+    - Content-Type
+  allow_credentials: true
+  max_age: 1s
+
+readiness:
+  upstreams: true
 
 target:
   host: host
   timeout: 1s
   keep_alive: 1s
+  max_idle_conns: 1
+  max_idle_conns_per_host: 1
+  idle_conn_timeout: 1s
+  response_header_timeout: 1s
+  tls_handshake_timeout: 1s
+  http2:
+    enabled: false
+    h2c: true
+    read_idle_timeout: 1s
+    ping_timeout: 1s
+  retry:
+    enabled: true
+    max_attempts: 2
+    backoff: 1s
+  health_check:
+    enabled: true
+    interval: 1s
+    timeout: 1s
+    path: /health
+    unhealthy_threshold: 1
+  circuit_breaker:
+    enabled: true
+    failure_threshold: 1
+    open_duration: 1s
+  tls:
+    cert_file: client.crt
+    key_file: client.key
+    ca_file: ca.crt
+    server_name: graphql.internal
+    min_version: "1.3"
+    auto_reload:
+      enabled: true
+      interval: 1s
+  headers:
+    request:
+      allow: []
+      deny:
+        - X-Internal-User
+      rename:
+        X-Client-Id: X-Consumer-Id
+      inject:
+        - name: X-Api-Key
+          from_env: API_KEY
+    response:
+      deny:
+        - Server
+      allow: []
+      rename: {}
+      inject:
+        - name: X-Served-By
+          value: graphql-protect
+  mirror:
+    enabled: true
+    host: http://shadow
+    percentage: 5
+    include_mutations: true
+    request_timeout: 1s
+    max_concurrent: 1
+  request_timeout:
+    default: 1s
+    operation_types:
+      mutation: 2s
+    operations:
+      Report: 3s
+    deadline_header: X-Request-Timeout-Ms
+  metrics:
+    operations:
+      - Checkout
+    include_trusted_documents: true
+
+response_cache:
+  enabled: true
+  default_max_age: 1s
+  use_upstream_headers: false
+  use_schema_hints: true
+  vary_headers:
+    - Authorization
+  max_entry_bytes: 1
+  store:
+    type: memory
+    max_entries: 1
+
+request_coalescing:
+  enabled: true
+  operations:
+    - Products
+  vary_headers:
+    - Authorization
 
 schema:
   path: "path"
@@ -64,7 +192,13 @@ schema:
     interval: 1s
     
 obfuscate_validation_errors: true    
-obfuscate_upstream_errors: false
+obfuscate_upstream_errors:
+  enabled: false
+  message: "Something went wrong"
+  allowed_codes:
+    - UNAUTHENTICATED
+  include_path: false
+  include_error_id: false
     
 persisted_operations:
   enabled: true
@@ -86,7 +220,19 @@ max_aliases:
 block_field_suggestions:
   enabled: false
   mask: mask
+  patterns:
+    - "Perhaps you meant"
+  partial_mask: true
   
+field_masking:
+  enabled: true
+  roles_header: X-Scopes
+  roles_claim: scope
+  rules:
+    - field: User.email
+      roles: [ "admin" ]
+      action: remove
+
 max_depth:
   enabled: false
   max: 1
@@ -110,9 +256,43 @@ max_batch:
   max: 1
   reject_on_failure: false
 
+max_response:
+  enabled: true
+  max_bytes: 2048
+  max_depth: 20
+  metrics_include_operation_name: true
+
+uploads:
+  enabled: true
+  max_files: 3
+  max_file_size: 1024
+  max_total_size: 2048
+  allowed_content_types:
+    - image/png
+
 enforce_post:
   enabled: false
 
+csrf_prevention:
+  enabled: true
+  required_headers:
+    - X-Requested-With
+
+authentication:
+  enabled: true
+  required: false
+  header: X-Token
+  algorithms:
+    - ES256
+  jwks:
+    url: https://issuer.example.com/.well-known/jwks.json
+    refresh_interval: 1m
+    timeout: 1s
+  issuer: https://issuer.example.com
+  audiences:
+    - graphql
+  leeway: 1s
+
 access_logging:
   enabled: false
   include_headers:
@@ -132,12 +312,46 @@ log:
 					WriteTimeout:        1 * time.Second,
 					IdleTimeout:         1 * time.Second,
 					ShutdownTimeout:     1 * time.Second,
+					DrainPeriod:         1 * time.Second,
 					Host:                "host",
+					AdminHost:           "admin",
 					Path:                "path",
 					RequestBodyMaxBytes: 2048,
+					ContentTypes:        []string{"application/json"},
+					TLS: http.TLSConfig{
+						Enabled:      true,
+						CertFile:     "server.crt",
+						KeyFile:      "server.key",
+						MinVersion:   "1.3",
+						CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+						ClientAuth:   "require_and_verify",
+						ClientCAFile: "client-ca.crt",
+						AutoReload: http.TLSReloadConfig{
+							Enabled:  true,
+							Interval: 1 * time.Second,
+						},
+					},
+				},
+				CORS: cors.Config{
+					Enabled:          true,
+					AllowedOrigins:   []string{"https://*.example.com"},
+					AllowedMethods:   []string{"POST"},
+					AllowedHeaders:   []string{"Content-Type"},
+					AllowCredentials: true,
+					MaxAge:           1 * time.Second,
+				},
+				Readiness: readiness.Config{
+					Upstreams: true,
 				},
 				ObfuscateValidationErrors: true,
-				ObfuscateUpstreamErrors:   false,
+				ObfuscateUpstreamErrors: obfuscate_upstream_errors.Config{
+					Enabled:           false,
+					Message:           "Something went wrong",
+					AllowedCodes:      []string{"UNAUTHENTICATED"},
+					IncludePath:       false,
+					IncludeErrorID:    false,
+					LogOriginalErrors: true,
+				},
 				Schema: schema.Config{
 					Path: "path",
 					AutoReload: struct {
@@ -149,9 +363,139 @@ log:
 					}{Enabled: false, Interval: 1 * time.Second}),
 				},
 				Target: proxy.Config{
-					Timeout:   1 * time.Second,
-					KeepAlive: 1 * time.Second,
-					Host:      "host",
+					UpstreamConfig: proxy.UpstreamConfig{
+						Timeout:               1 * time.Second,
+						KeepAlive:             1 * time.Second,
+						MaxIdleConns:          1,
+						MaxIdleConnsPerHost:   1,
+						IdleConnTimeout:       1 * time.Second,
+						ResponseHeaderTimeout: 1 * time.Second,
+						TLSHandshakeTimeout:   1 * time.Second,
+						HTTP2: proxy.HTTP2Config{
+							Enabled:         false,
+							H2C:             true,
+							ReadIdleTimeout: 1 * time.Second,
+							PingTimeout:     1 * time.Second,
+						},
+						Host: "host",
+						Retry: proxy.RetryConfig{
+							Enabled:     true,
+							MaxAttempts: 2,
+							Backoff:     1 * time.Second,
+						},
+						HealthCheck: proxy.HealthCheckConfig{
+							Enabled:            true,
+							Interval:           1 * time.Second,
+							Timeout:            1 * time.Second,
+							Path:               "/health",
+							UnhealthyThreshold: 1,
+						},
+						CircuitBreaker: proxy.CircuitBreakerConfig{
+							Enabled:          true,
+							FailureThreshold: 1,
+							OpenDuration:     1 * time.Second,
+						},
+						TLS: proxy.TLSConfig{
+							CertFile:   "client.crt",
+							KeyFile:    "client.key",
+							CAFile:     "ca.crt",
+							ServerName: "graphql.internal",
+							MinVersion: "1.3",
+							AutoReload: proxy.TLSReloadConfig{
+								Enabled:  true,
+								Interval: 1 * time.Second,
+							},
+						},
+					},
+					Upstreams: map[string]proxy.UpstreamConfig{},
+					Routes:    []proxy.RouteConfig{},
+					Headers: proxy.HeadersConfig{
+						Request: proxy.HeaderPolicyConfig{
+							Allow:  []string{},
+							Deny:   []string{"X-Internal-User"},
+							Rename: map[string]string{"X-Client-Id": "X-Consumer-Id"},
+							Inject: []proxy.HeaderInjectionConfig{{Name: "X-Api-Key", FromEnv: "API_KEY"}},
+						},
+						Response: proxy.HeaderPolicyConfig{
+							Allow:  []string{},
+							Deny:   []string{"Server"},
+							Rename: map[string]string{},
+							Inject: []proxy.HeaderInjectionConfig{{Name: "X-Served-By", Value: "graphql-protect"}},
+						},
+					},
+					Mirror: proxy.MirrorConfig{
+						Enabled: true,
+						// settings that aren't configured for the mirror are inherited from the default upstream
+						UpstreamConfig: proxy.UpstreamConfig{
+							Timeout:               1 * time.Second,
+							KeepAlive:             1 * time.Second,
+							MaxIdleConns:          1,
+							MaxIdleConnsPerHost:   1,
+							IdleConnTimeout:       1 * time.Second,
+							ResponseHeaderTimeout: 1 * time.Second,
+							TLSHandshakeTimeout:   1 * time.Second,
+							HTTP2: proxy.HTTP2Config{
+								Enabled:         false,
+								H2C:             true,
+								ReadIdleTimeout: 1 * time.Second,
+								PingTimeout:     1 * time.Second,
+							},
+							Host: "http://shadow",
+							Retry: proxy.RetryConfig{
+								MaxAttempts: 2,
+								Backoff:     1 * time.Second,
+							},
+							HealthCheck: proxy.HealthCheckConfig{
+								Interval:           1 * time.Second,
+								Timeout:            1 * time.Second,
+								UnhealthyThreshold: 1,
+							},
+							CircuitBreaker: proxy.CircuitBreakerConfig{
+								FailureThreshold: 1,
+								OpenDuration:     1 * time.Second,
+							},
+							TLS: proxy.TLSConfig{
+								CertFile:   "client.crt",
+								KeyFile:    "client.key",
+								CAFile:     "ca.crt",
+								MinVersion: "1.3",
+								AutoReload: proxy.TLSReloadConfig{
+									Interval: 1 * time.Second,
+								},
+							},
+						},
+						Percentage:       5,
+						IncludeMutations: true,
+						RequestTimeout:   1 * time.Second,
+						MaxConcurrent:    1,
+					},
+					RequestTimeout: proxy.RequestTimeoutConfig{
+						Default:        1 * time.Second,
+						OperationTypes: map[string]time.Duration{"mutation": 2 * time.Second},
+						Operations:     map[string]time.Duration{"Report": 3 * time.Second},
+						DeadlineHeader: "X-Request-Timeout-Ms",
+					},
+					Metrics: proxy.MetricsConfig{
+						Operations:              []string{"Checkout"},
+						IncludeTrustedDocuments: true,
+					},
+				},
+				ResponseCache: cache.Config{
+					Enabled:            true,
+					DefaultMaxAge:      1 * time.Second,
+					UseUpstreamHeaders: false,
+					UseSchemaHints:     true,
+					VaryHeaders:        []string{"Authorization"},
+					MaxEntryBytes:      1,
+					Store: cache.StoreConfig{
+						Type:       "memory",
+						MaxEntries: 1,
+					},
+				},
+				RequestCoalescing: coalesce.Config{
+					Enabled:     true,
+					Operations:  []string{"Products"},
+					VaryHeaders: []string{"Authorization"},
 				},
 				PersistedOperations: trusteddocuments.Config{
 					Enabled:             true,
@@ -172,8 +516,18 @@ log:
 					RejectOnFailure: false,
 				},
 				BlockFieldSuggestions: block_field_suggestions.Config{
-					Enabled: false,
-					Mask:    "mask",
+					Enabled:     false,
+					Mask:        "mask",
+					Patterns:    []string{"Perhaps you meant"},
+					PartialMask: true,
+				},
+				FieldMasking: field_masking.Config{
+					Enabled:     true,
+					RolesHeader: "X-Scopes",
+					RolesClaim:  "scope",
+					Rules: []field_masking.RuleConfig{
+						{Field: "User.email", Roles: []string{"admin"}, Action: "remove"},
+					},
 				},
 				MaxTokens: tokens.Config{
 					Enabled:         false,
@@ -190,6 +544,24 @@ log:
 				EnforcePost: enforce_post.Config{
 					Enabled: false,
 				},
+				CSRFPrevention: csrf_prevention.Config{
+					Enabled:         true,
+					RequiredHeaders: []string{"X-Requested-With"},
+				},
+				Authentication: authentication.Config{
+					Enabled:    true,
+					Required:   false,
+					Header:     "X-Token",
+					Algorithms: []string{"ES256"},
+					JWKS: authentication.JWKSConfig{
+						URL:             "https://issuer.example.com/.well-known/jwks.json",
+						RefreshInterval: 1 * time.Minute,
+						Timeout:         1 * time.Second,
+					},
+					Issuer:    "https://issuer.example.com",
+					Audiences: []string{"graphql"},
+					Leeway:    1 * time.Second,
+				},
 				MaxDepth: max_depth.Config{
 					Field: max_depth.MaxRule{
 						Enabled:         false,
@@ -207,6 +579,19 @@ log:
 					Max:             1,
 					RejectOnFailure: false,
 				},
+				MaxResponse: max_response.Config{
+					Enabled:                     true,
+					MaxBytes:                    2048,
+					MaxDepth:                    20,
+					MetricsIncludeOperationName: true,
+				},
+				Uploads: uploads.Config{
+					Enabled:             true,
+					MaxFiles:            3,
+					MaxFileSize:         1024,
+					MaxTotalSize:        2048,
+					AllowedContentTypes: []string{"image/png"},
+				},
 				AccessLogging: accesslogging.Config{
 					Enabled:              false,
 					IncludedHeaders:      []string{"Authorization"},
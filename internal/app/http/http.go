@@ -9,11 +9,20 @@ type Config struct {
 	WriteTimeout    time.Duration `yaml:"write_timeout"`
 	IdleTimeout     time.Duration `yaml:"idle_timeout"`
 	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
-	Host            string        `yaml:"host"`
+	// Time to keep serving after receiving a shutdown signal while readiness reports not ready,
+	// so load balancers stop sending traffic before the listener is closed
+	DrainPeriod time.Duration `yaml:"drain_period"`
+	Host        string        `yaml:"host"`
+	// Host and port of a separate listener for the metrics, health and debug endpoints.
+	// These are served by the public listener when empty
+	AdminHost string `yaml:"admin_host"`
 	// or maybe we just want to listen on everything and forward
-	Path string `yaml:"path"`
-	// DebugHost       string        `yaml:"debug_host"`
-	RequestBodyMaxBytes int `yaml:"request_body_max_bytes"`
+	Path                string `yaml:"path"`
+	RequestBodyMaxBytes int    `yaml:"request_body_max_bytes"`
+	// Media types accepted as request body, other media types are rejected
+	ContentTypes []string `yaml:"content_types"`
+	// Terminate TLS on the listener
+	TLS TLSConfig `yaml:"tls"`
 }
 
 func DefaultConfig() Config {
@@ -22,8 +31,21 @@ func DefaultConfig() Config {
 		WriteTimeout:        10 * time.Second,
 		IdleTimeout:         2 * time.Minute,
 		ShutdownTimeout:     20 * time.Second,
+		DrainPeriod:         0,
 		Host:                "0.0.0.0:8080",
+		AdminHost:           "",
 		Path:                "/graphql",
 		RequestBodyMaxBytes: kilobyte100,
+		// only media types that browsers can't send cross-origin without a preflight request
+		ContentTypes: []string{"application/json", "application/graphql"},
+		TLS: TLSConfig{
+			Enabled:    false,
+			MinVersion: "1.2",
+			ClientAuth: "none",
+			AutoReload: TLSReloadConfig{
+				Enabled:  false,
+				Interval: 30 * time.Second,
+			},
+		},
 	}
 }
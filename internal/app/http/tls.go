@@ -0,0 +1,230 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"github.com/ldebruijn/graphql-protect/internal/http/tlsreload"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	tlsReloadCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "http",
+		Name:      "tls_reload_count",
+		Help:      "Amount of reloads of rotated listener TLS certificates",
+	},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(tlsReloadCounter)
+}
+
+var (
+	ErrInvalidCipherSuite = errors.New("invalid or insecure cipher suite")
+	ErrInvalidClientAuth  = errors.New("invalid client auth")
+	ErrInvalidCABundle    = errors.New("no certificates found in client CA bundle")
+	ErrMissingKeyPair     = errors.New("both cert_file and key_file are required for TLS")
+	ErrMissingClientCA    = errors.New("client_ca_file is required to verify client certificates")
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+type TLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Certificate and key presented to clients
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Minimum TLS version, one of 1.0, 1.1, 1.2 or 1.3
+	MinVersion string `yaml:"min_version"`
+	// Cipher suites for TLS 1.2 and below, by their IANA name. Go's secure defaults are used when empty
+	CipherSuites []string `yaml:"cipher_suites"`
+	// Client certificate policy, one of none, request, require, verify_if_given or require_and_verify
+	ClientAuth string `yaml:"client_auth"`
+	// CA bundle used to verify client certificates
+	ClientCAFile string `yaml:"client_ca_file"`
+	// Reload the certificate, key and client CA bundle when they change on disk
+	AutoReload TLSReloadConfig `yaml:"auto_reload"`
+}
+
+type TLSReloadConfig = tlsreload.Config
+
+// ServerTLS holds the certificates of the listener, and swaps them when the files on disk are rotated
+type ServerTLS struct {
+	cfg TLSConfig
+	log *slog.Logger
+
+	minVersion   uint16
+	cipherSuites []uint16
+	clientAuth   tls.ClientAuthType
+	certificate  atomic.Pointer[tls.Certificate]
+	clientCAs    atomic.Pointer[x509.CertPool]
+	reloader     *tlsreload.Reloader
+}
+
+func NewServerTLS(cfg TLSConfig, log *slog.Logger) (*ServerTLS, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, ErrMissingKeyPair
+	}
+
+	minVersion, err := tlsreload.MinVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherSuites, err := parseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth := tls.NoClientCert
+	if cfg.ClientAuth != "" {
+		auth, ok := clientAuthTypes[cfg.ClientAuth]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidClientAuth, cfg.ClientAuth)
+		}
+		clientAuth = auth
+	}
+	if (clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert) && cfg.ClientCAFile == "" {
+		return nil, ErrMissingClientCA
+	}
+
+	s := &ServerTLS{
+		cfg:          cfg,
+		log:          log,
+		minVersion:   minVersion,
+		cipherSuites: cipherSuites,
+		clientAuth:   clientAuth,
+	}
+
+	s.reloader, err = tlsreload.New(cfg.AutoReload, []string{cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile}, s.load, s.reloaded)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Config returns the TLS configuration of the listener.
+// The certificate is looked up on every handshake, so a rotated certificate is used for new connections.
+// A single configuration is used for all connections, so clients can resume their sessions.
+func (s *ServerTLS) Config() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:   s.minVersion,
+		CipherSuites: s.cipherSuites,
+		ClientAuth:   s.clientAuth,
+		NextProtos:   []string{"h2", "http/1.1"},
+		GetCertificate: func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.certificate.Load(), nil
+		},
+	}
+
+	// client certificates are verified against the current CA bundle in verifyConnection instead,
+	// as the client CAs of a tls.Config cannot be swapped once in use
+	switch s.clientAuth {
+	case tls.VerifyClientCertIfGiven:
+		cfg.ClientAuth = tls.RequestClientCert
+		cfg.VerifyConnection = s.verifyConnection
+	case tls.RequireAndVerifyClientCert:
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = s.verifyConnection
+	}
+	return cfg
+}
+
+// verifyConnection verifies the client certificate, if any, against the client CA bundle. It's called for resumed sessions as well.
+func (s *ServerTLS) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		// a missing certificate is already rejected by the client auth policy when it's required
+		return nil
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         s.clientCAs.Load(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	supported := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := supported[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCipherSuite, name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *ServerTLS) load() error {
+	certificate, err := tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if s.cfg.ClientCAFile != "" {
+		bundle, err := os.ReadFile(s.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("unable to load client CA bundle: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("%w: %s", ErrInvalidCABundle, s.cfg.ClientCAFile)
+		}
+	}
+
+	// only swap once all files are loaded, so a partial rotation doesn't leave mismatching certificates in use
+	s.certificate.Store(&certificate)
+	s.clientCAs.Store(clientCAs)
+	return nil
+}
+
+func (s *ServerTLS) reloaded(err error) {
+	if err != nil {
+		s.log.Warn("Error reloading TLS certificates, continuing with the previous certificates", "err", err)
+		tlsReloadCounter.WithLabelValues("failed").Inc()
+		return
+	}
+	s.log.Info("Reloaded TLS certificates")
+	tlsReloadCounter.WithLabelValues("success").Inc()
+}
+
+// Start checks the certificate files for changes periodically, when auto reload is enabled
+func (s *ServerTLS) Start() {
+	s.reloader.Start()
+}
+
+func (s *ServerTLS) Shutdown() {
+	s.reloader.Shutdown()
+}
@@ -0,0 +1,278 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/http/tlsreload"
+	"github.com/ldebruijn/graphql-protect/internal/http/tlsreload/tlstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTLSServer(t *testing.T, serverTLS *ServerTLS) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	// StartTLS would add a certificate of its own, so the listener is wrapped the way the server does with ListenAndServeTLS
+	server.Listener = tls.NewListener(server.Listener, serverTLS.Config())
+	server.Start()
+	server.URL = "https://" + server.Listener.Addr().String()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newClient(ca *tlstest.CA, certificates ...tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				RootCAs:      roots,
+				Certificates: certificates,
+			},
+		},
+	}
+}
+
+func get(client *http.Client, url string) error {
+	res, err := client.Get(url) // nolint:noctx
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func TestServerTLS(t *testing.T) {
+	serverCA := tlstest.NewCA(t)
+	clientCA := tlstest.NewCA(t)
+
+	dir := t.TempDir()
+	certPEM, keyPEM := serverCA.Issue(t, x509.ExtKeyUsageServerAuth)
+	tlstest.WriteFile(t, filepath.Join(dir, "server.crt"), certPEM)
+	tlstest.WriteFile(t, filepath.Join(dir, "server.key"), keyPEM)
+	tlstest.WriteFile(t, filepath.Join(dir, "client-ca.crt"), clientCA.PEM)
+
+	clientCertPEM, clientKeyPEM := clientCA.Issue(t, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+	otherCertPEM, otherKeyPEM := tlstest.NewCA(t).Issue(t, x509.ExtKeyUsageClientAuth)
+	otherCert, err := tls.X509KeyPair(otherCertPEM, otherKeyPEM)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		cfg     TLSConfig
+		client  *http.Client
+		wantErr bool
+	}{
+		{
+			name: "presents the certificate",
+			cfg: TLSConfig{
+				CertFile: filepath.Join(dir, "server.crt"),
+				KeyFile:  filepath.Join(dir, "server.key"),
+			},
+			client:  newClient(serverCA),
+			wantErr: false,
+		},
+		{
+			name: "rejects clients without a certificate when required",
+			cfg: TLSConfig{
+				CertFile:     filepath.Join(dir, "server.crt"),
+				KeyFile:      filepath.Join(dir, "server.key"),
+				ClientAuth:   "require_and_verify",
+				ClientCAFile: filepath.Join(dir, "client-ca.crt"),
+			},
+			client:  newClient(serverCA),
+			wantErr: true,
+		},
+		{
+			name: "accepts clients with a certificate signed by the client CA",
+			cfg: TLSConfig{
+				CertFile:     filepath.Join(dir, "server.crt"),
+				KeyFile:      filepath.Join(dir, "server.key"),
+				ClientAuth:   "require_and_verify",
+				ClientCAFile: filepath.Join(dir, "client-ca.crt"),
+			},
+			client:  newClient(serverCA, clientCert),
+			wantErr: false,
+		},
+		{
+			name: "rejects clients with a certificate signed by another CA",
+			cfg: TLSConfig{
+				CertFile:     filepath.Join(dir, "server.crt"),
+				KeyFile:      filepath.Join(dir, "server.key"),
+				ClientAuth:   "verify_if_given",
+				ClientCAFile: filepath.Join(dir, "client-ca.crt"),
+			},
+			client:  newClient(serverCA, otherCert),
+			wantErr: true,
+		},
+		{
+			name: "rejects clients below the minimum version",
+			cfg: TLSConfig{
+				CertFile:   filepath.Join(dir, "server.crt"),
+				KeyFile:    filepath.Join(dir, "server.key"),
+				MinVersion: "1.3",
+			},
+			client: func() *http.Client {
+				client := newClient(serverCA)
+				client.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+				return client
+			}(),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverTLS, err := NewServerTLS(tt.cfg, slog.Default())
+			require.NoError(t, err)
+			server := newTLSServer(t, serverTLS)
+
+			err = get(tt.client, server.URL)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestServerTLS_Reload(t *testing.T) {
+	oldCA := tlstest.NewCA(t)
+	newCA := tlstest.NewCA(t)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	certPEM, keyPEM := oldCA.Issue(t, x509.ExtKeyUsageServerAuth)
+	tlstest.WriteFile(t, certFile, certPEM)
+	tlstest.WriteFile(t, keyFile, keyPEM)
+
+	serverTLS, err := NewServerTLS(TLSConfig{CertFile: certFile, KeyFile: keyFile}, slog.Default())
+	require.NoError(t, err)
+	server := newTLSServer(t, serverTLS)
+
+	assert.NoError(t, get(newClient(oldCA), server.URL))
+	assert.Error(t, get(newClient(newCA), server.URL))
+
+	// a rotation that fails to load keeps the previous certificate in use
+	tlstest.WriteFile(t, keyFile, []byte("not a key"))
+	require.NoError(t, os.Chtimes(keyFile, time.Now(), time.Now().Add(time.Minute)))
+	serverTLS.reloader.Reload()
+	assert.NoError(t, get(newClient(oldCA), server.URL))
+
+	certPEM, keyPEM = newCA.Issue(t, x509.ExtKeyUsageServerAuth)
+	tlstest.WriteFile(t, certFile, certPEM)
+	tlstest.WriteFile(t, keyFile, keyPEM)
+	require.NoError(t, os.Chtimes(certFile, time.Now(), time.Now().Add(2*time.Minute)))
+	require.NoError(t, os.Chtimes(keyFile, time.Now(), time.Now().Add(2*time.Minute)))
+	serverTLS.reloader.Reload()
+
+	assert.NoError(t, get(newClient(newCA), server.URL))
+	assert.Error(t, get(newClient(oldCA), server.URL))
+}
+
+func TestServerTLS_SessionResumption(t *testing.T) {
+	serverCA := tlstest.NewCA(t)
+	clientCA := tlstest.NewCA(t)
+
+	dir := t.TempDir()
+	certPEM, keyPEM := serverCA.Issue(t, x509.ExtKeyUsageServerAuth)
+	tlstest.WriteFile(t, filepath.Join(dir, "server.crt"), certPEM)
+	tlstest.WriteFile(t, filepath.Join(dir, "server.key"), keyPEM)
+	tlstest.WriteFile(t, filepath.Join(dir, "client-ca.crt"), clientCA.PEM)
+
+	clientCertPEM, clientKeyPEM := clientCA.Issue(t, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+
+	serverTLS, err := NewServerTLS(TLSConfig{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientAuth:   "require_and_verify",
+		ClientCAFile: filepath.Join(dir, "client-ca.crt"),
+	}, slog.Default())
+	require.NoError(t, err)
+	server := newTLSServer(t, serverTLS)
+	address, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.Cert)
+	clientCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		RootCAs:            roots,
+		Certificates:       []tls.Certificate{clientCert},
+		ServerName:         "localhost",
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+
+	connect := func() tls.ConnectionState {
+		conn, err := tls.Dial("tcp", address.Host, clientCfg)
+		require.NoError(t, err)
+		defer conn.Close()
+		// TLS 1.3 session tickets are sent after the handshake, so some data is exchanged first
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+		_, _ = io.ReadAll(conn)
+		return conn.ConnectionState()
+	}
+
+	assert.False(t, connect().DidResume)
+	assert.True(t, connect().DidResume)
+}
+
+func TestNewServerTLS_Errors(t *testing.T) {
+	dir := t.TempDir()
+	tlstest.WriteFile(t, filepath.Join(dir, "empty.crt"), []byte("not a certificate"))
+
+	tests := []struct {
+		name string
+		cfg  TLSConfig
+		want error
+	}{
+		{
+			name: "certificate without key",
+			cfg:  TLSConfig{CertFile: "server.crt"},
+			want: ErrMissingKeyPair,
+		},
+		{
+			name: "invalid minimum version",
+			cfg:  TLSConfig{CertFile: "server.crt", KeyFile: "server.key", MinVersion: "1.4"},
+			want: tlsreload.ErrInvalidTLSVersion,
+		},
+		{
+			name: "insecure cipher suite",
+			cfg:  TLSConfig{CertFile: "server.crt", KeyFile: "server.key", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			want: ErrInvalidCipherSuite,
+		},
+		{
+			name: "invalid client auth",
+			cfg:  TLSConfig{CertFile: "server.crt", KeyFile: "server.key", ClientAuth: "always"},
+			want: ErrInvalidClientAuth,
+		},
+		{
+			name: "verifying client certificates without a client CA bundle",
+			cfg:  TLSConfig{CertFile: "server.crt", KeyFile: "server.key", ClientAuth: "require_and_verify"},
+			want: ErrMissingClientCA,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewServerTLS(tt.cfg, slog.Default())
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
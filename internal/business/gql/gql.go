@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -40,22 +42,96 @@ type PersistedQuery struct {
 	Sha256Hash string `json:"sha256Hash"`
 }
 
+var ErrInvalidQueryParameter = errors.New("invalid query parameter")
+
 func ParseRequestPayload(r *http.Request) ([]RequestData, error) {
+	if r.Method == http.MethodGet {
+		return parseQueryParameters(r.URL.Query())
+	}
+
 	// ContentLength == 0 means empty body; -1 means unknown (e.g. chunked encoding) so we must read it.
 	if r.ContentLength == 0 {
 		return []RequestData{}, nil
 	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
-			requestMaxBodyBytesExceededCounter.WithLabelValues().Inc()
+	if boundary, ok := multipartBoundary(r); ok {
+		// only the operations are read, the files may be large
+		data, _, err := readMultipartHead(r, boundary)
+		if err != nil {
+			return []RequestData{}, err
 		}
+		return data, nil
+	}
+
+	body, err := readBody(r)
+	if err != nil {
 		return []RequestData{}, err
 	}
+
+	switch requestMediaType(r) {
+	case MediaTypeGraphQL:
+		// the body consists of the query only
+		query := string(bytes.TrimSpace(body))
+		if query == "" {
+			return []RequestData{}, nil
+		}
+		return []RequestData{{Query: query}}, nil
+	case MediaTypeFormURLEncoded:
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return []RequestData{}, fmt.Errorf("%w: %w", ErrInvalidQueryParameter, err)
+		}
+		return parseQueryParameters(values)
+	default:
+		return unmarshalPayload(body)
+	}
+}
+
+// NormalizeBody replaces application/graphql and form encoded bodies with their application/json equivalent,
+// so the upstream only has to support application/json
+func NormalizeBody(r *http.Request, data []RequestData) error {
+	mediaType := requestMediaType(r)
+	if (mediaType != MediaTypeGraphQL && mediaType != MediaTypeFormURLEncoded) || len(data) == 0 {
+		return nil
+	}
+
+	var bts []byte
+	var err error
+	if len(data) == 1 {
+		bts, err = json.Marshal(data[0])
+	} else {
+		bts, err = json.Marshal(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.Body = io.NopCloser(bytes.NewBuffer(bts))
+	r.ContentLength = int64(len(bts))
+	r.Header.Set("Content-Type", MediaTypeJSON)
+	return nil
+}
+
+// readBody reads the entire body of the request, and replaces it so it can be read again
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		countMaxBytesExceeded(err)
+		return nil, err
+	}
 	// Replace the body with a new reader after reading from the original
 	r.Body = io.NopCloser(bytes.NewBuffer(body))
+	return body, nil
+}
 
+func countMaxBytesExceeded(err error) {
+	if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
+		requestMaxBodyBytesExceededCounter.WithLabelValues().Inc()
+	}
+}
+
+// unmarshalPayload decodes a single operation or a batch of operations
+func unmarshalPayload(body []byte) ([]RequestData, error) {
 	body = bytes.TrimSpace(body)
 	if len(body) == 0 {
 		return []RequestData{}, nil
@@ -63,16 +139,68 @@ func ParseRequestPayload(r *http.Request) ([]RequestData, error) {
 	// assume it's a batch request
 	if body[0] == '[' {
 		var data []RequestData
-		err = json.Unmarshal(body, &data)
+		err := json.Unmarshal(body, &data)
 		if err != nil {
 			return []RequestData{}, err
 		}
 		return data, nil
 	}
 	var data RequestData
-	err = json.Unmarshal(body, &data)
+	err := json.Unmarshal(body, &data)
 	if err != nil {
 		return []RequestData{}, err
 	}
 	return []RequestData{data}, nil
 }
+
+// parseQueryParameters reads the operation of a GET request from its query parameters
+func parseQueryParameters(values url.Values) ([]RequestData, error) {
+	if !values.Has("query") && !values.Has("extensions") {
+		return []RequestData{}, nil
+	}
+
+	data := RequestData{
+		Query:         values.Get("query"),
+		OperationName: values.Get("operationName"),
+	}
+	if variables := values.Get("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &data.Variables); err != nil {
+			return []RequestData{}, fmt.Errorf("%w variables: %w", ErrInvalidQueryParameter, err)
+		}
+	}
+	if extensions := values.Get("extensions"); extensions != "" {
+		if err := json.Unmarshal([]byte(extensions), &data.Extensions); err != nil {
+			return []RequestData{}, fmt.Errorf("%w extensions: %w", ErrInvalidQueryParameter, err)
+		}
+	}
+	return []RequestData{data}, nil
+}
+
+// QueryParameters encodes the operation as query parameters of a GET request, replacing the operation in the given values
+func (d RequestData) QueryParameters(values url.Values) (url.Values, error) {
+	values.Set("query", d.Query)
+
+	values.Del("operationName")
+	if d.OperationName != "" {
+		values.Set("operationName", d.OperationName)
+	}
+
+	values.Del("variables")
+	if len(d.Variables) > 0 {
+		variables, err := json.Marshal(d.Variables)
+		if err != nil {
+			return nil, err
+		}
+		values.Set("variables", string(variables))
+	}
+
+	values.Del("extensions")
+	if d.Extensions.PersistedQuery != nil {
+		extensions, err := json.Marshal(d.Extensions)
+		if err != nil {
+			return nil, err
+		}
+		values.Set("extensions", string(extensions))
+	}
+	return values, nil
+}
@@ -3,8 +3,10 @@ package gql
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"testing"
 )
@@ -133,6 +135,89 @@ func TestParseRequestPayload(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "parses GET operations from the query parameters",
+			args: args{
+				r: httptest.NewRequest("GET", "/graphql?query=query+Foo+%7B+foo+%7D&operationName=Foo&variables=%7B%22baz%22%3A%22foobar%22%7D&extensions=%7B%22persistedQuery%22%3A%7B%22sha256Hash%22%3A%22abc%22%7D%7D", nil),
+			},
+			want: []RequestData{
+				{
+					OperationName: "Foo",
+					Variables: map[string]interface{}{
+						"baz": "foobar",
+					},
+					Query: "query Foo { foo }",
+					Extensions: Extensions{
+						PersistedQuery: &PersistedQuery{Sha256Hash: "abc"},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "parses application/graphql bodies as the query",
+			args: args{
+				r: func() *http.Request {
+					r := httptest.NewRequest("POST", "/graphql", bytes.NewBufferString("query Foo { foo }\n"))
+					r.Header.Set("Content-Type", "application/graphql; charset=utf-8")
+					return r
+				}(),
+			},
+			want: []RequestData{
+				{
+					Query: "query Foo { foo }",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "parses form encoded bodies",
+			args: args{
+				r: func() *http.Request {
+					r := httptest.NewRequest("POST", "/graphql", bytes.NewBufferString("query=query+Foo+%7B+foo+%7D&operationName=Foo&variables=%7B%22baz%22%3A%22foobar%22%7D"))
+					r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+					return r
+				}(),
+			},
+			want: []RequestData{
+				{
+					OperationName: "Foo",
+					Variables: map[string]interface{}{
+						"baz": "foobar",
+					},
+					Query: "query Foo { foo }",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "form encoded body with invalid variables",
+			args: args{
+				r: func() *http.Request {
+					r := httptest.NewRequest("POST", "/graphql", bytes.NewBufferString("query=%7B+foo+%7D&variables=%7Bbaz"))
+					r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+					return r
+				}(),
+			},
+			want:    []RequestData{},
+			wantErr: true,
+		},
+		{
+			name: "GET without operation",
+			args: args{
+				r: httptest.NewRequest("GET", "/graphql?foo=bar", nil),
+			},
+			want:    []RequestData{},
+			wantErr: false,
+		},
+		{
+			name: "GET with invalid variables",
+			args: args{
+				r: httptest.NewRequest("GET", "/graphql?query=%7B+foo+%7D&variables=%7Bbaz", nil),
+			},
+			want:    []RequestData{},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -148,6 +233,86 @@ func TestParseRequestPayload(t *testing.T) {
 	}
 }
 
+func TestRequestData_QueryParameters(t *testing.T) {
+	data := RequestData{
+		OperationName: "Foo",
+		Query:         "query Foo { foo }",
+		Variables:     map[string]interface{}{"baz": "foobar"},
+	}
+
+	values, err := data.QueryParameters(url.Values{
+		"extensions": {`{"persistedQuery":{"sha256Hash":"abc"}}`},
+		"locale":     {"nl"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := url.Values{
+		"query":         {"query Foo { foo }"},
+		"operationName": {"Foo"},
+		"variables":     {`{"baz":"foobar"}`},
+		"locale":        {"nl"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("QueryParameters() got = %v, want %v", values, want)
+	}
+}
+
+func TestNormalizeBody(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentType     string
+		body            string
+		data            []RequestData
+		wantBody        string
+		wantContentType string
+	}{
+		{
+			name:            "application/graphql is forwarded as json",
+			contentType:     "application/graphql",
+			body:            "{ foo }",
+			data:            []RequestData{{Query: "{ foo }"}},
+			wantBody:        `{"query":"{ foo }","extensions":{}}`,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "form encoded bodies are forwarded as json",
+			contentType:     "application/x-www-form-urlencoded",
+			body:            "query=%7B+foo+%7D&operationName=Foo",
+			data:            []RequestData{{Query: "{ foo }", OperationName: "Foo"}},
+			wantBody:        `{"operationName":"Foo","query":"{ foo }","extensions":{}}`,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "json bodies are left as is",
+			contentType:     "application/json",
+			body:            `{"query": "{ foo }"}`,
+			data:            []RequestData{{Query: "{ foo }"}},
+			wantBody:        `{"query": "{ foo }"}`,
+			wantContentType: "application/json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/graphql", bytes.NewBufferString(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+
+			if err := NormalizeBody(r, tt.data); err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != tt.wantBody {
+				t.Errorf("NormalizeBody() body = %s, want %s", body, tt.wantBody)
+			}
+			if r.Header.Get("Content-Type") != tt.wantContentType {
+				t.Errorf("NormalizeBody() content type = %s, want %s", r.Header.Get("Content-Type"), tt.wantContentType)
+			}
+		})
+	}
+}
+
 func BenchmarkCheckJSONType(b *testing.B) {
 	// Create a sample JSON object
 	jsonObject := []byte(`{
@@ -0,0 +1,124 @@
+package gql
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	MediaTypeJSON            = "application/json"
+	MediaTypeGraphQLResponse = "application/graphql-response+json"
+	MediaTypeMultipart       = "multipart/form-data"
+	MediaTypeGraphQL         = "application/graphql"
+	MediaTypeFormURLEncoded  = "application/x-www-form-urlencoded"
+)
+
+var (
+	ErrNotAcceptable        = errors.New("none of the accepted media types are supported, use application/graphql-response+json or application/json")
+	ErrUnsupportedMediaType = errors.New("unsupported content type")
+)
+
+// NegotiateResponseMediaType selects the media type of the response from the Accept header, as described by the GraphQL-over-HTTP spec.
+// Requests without an Accept header, or accepting any media type, receive application/json.
+func NegotiateResponseMediaType(r *http.Request) (string, error) {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return MediaTypeJSON, nil
+	}
+
+	selected := ""
+	selectedQuality := 0.0
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+
+			quality := 1.0
+			if q, ok := params["q"]; ok {
+				quality, err = strconv.ParseFloat(q, 64)
+				if err != nil {
+					continue
+				}
+			}
+
+			var candidate string
+			switch mediaType {
+			case MediaTypeGraphQLResponse:
+				candidate = MediaTypeGraphQLResponse
+			case MediaTypeJSON, "application/*", "*/*":
+				candidate = MediaTypeJSON
+			default:
+				continue
+			}
+
+			// in case of a tie, the media type listed first wins
+			if quality > selectedQuality {
+				selected = candidate
+				selectedQuality = quality
+			}
+		}
+	}
+
+	if selected == "" {
+		return "", ErrNotAcceptable
+	}
+	return selected, nil
+}
+
+// ValidateContentType checks whether the body of the request is of one of the supported media types.
+// Requests without a Content-Type header are accepted for compatibility with existing clients.
+func ValidateContentType(r *http.Request, supported []string) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !slices.Contains(supported, mediaType) {
+		return fmt.Errorf("%w, use %s", ErrUnsupportedMediaType, strings.Join(supported, " or "))
+	}
+	return nil
+}
+
+// simpleMediaTypes can be sent cross-origin by browsers without a preflight request, see https://fetch.spec.whatwg.org/#cors-safelisted-request-header
+var simpleMediaTypes = []string{MediaTypeFormURLEncoded, MediaTypeMultipart, "text/plain"}
+
+// IsSimpleMediaType returns whether browsers send requests of the media type cross-origin without a preflight request,
+// which makes them susceptible to cross-site request forgery
+func IsSimpleMediaType(mediaType string) bool {
+	return slices.Contains(simpleMediaTypes, mediaType)
+}
+
+// requestMediaType returns the media type of the body of the request, or an empty string if it has none
+func requestMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// ResponseMediaType returns the media type negotiated for the response to the request
+func ResponseMediaType(r *http.Request) string {
+	info := RequestInfoFromContext(r.Context())
+	if info == nil || info.ResponseMediaType == "" {
+		return MediaTypeJSON
+	}
+	return info.ResponseMediaType
+}
+
+// RequestErrorStatus returns the status code of a response to a request that failed before it could be executed.
+// Clients negotiating application/graphql-response+json receive a 400, while clients of application/json expect a 200.
+func RequestErrorStatus(r *http.Request) int {
+	if ResponseMediaType(r) == MediaTypeGraphQLResponse {
+		return http.StatusBadRequest
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,138 @@
+package gql
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateResponseMediaType(t *testing.T) {
+	tests := []struct {
+		name    string
+		accept  []string
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "application/json without an Accept header",
+			accept: nil,
+			want:   MediaTypeJSON,
+		},
+		{
+			name:   "graphql response media type",
+			accept: []string{"application/graphql-response+json"},
+			want:   MediaTypeGraphQLResponse,
+		},
+		{
+			name:   "prefers the media type listed first",
+			accept: []string{"application/graphql-response+json, application/json"},
+			want:   MediaTypeGraphQLResponse,
+		},
+		{
+			name:   "respects quality values",
+			accept: []string{"application/graphql-response+json;q=0.5, application/json;charset=utf-8"},
+			want:   MediaTypeJSON,
+		},
+		{
+			name:   "wildcards receive application/json",
+			accept: []string{"text/html, */*;q=0.8"},
+			want:   MediaTypeJSON,
+		},
+		{
+			name:   "combines multiple Accept headers",
+			accept: []string{"text/html", "application/graphql-response+json"},
+			want:   MediaTypeGraphQLResponse,
+		},
+		{
+			name:    "not acceptable when no supported media type is accepted",
+			accept:  []string{"text/html, application/xml"},
+			wantErr: ErrNotAcceptable,
+		},
+		{
+			name:    "not acceptable when the supported media types are refused",
+			accept:  []string{"application/json;q=0"},
+			wantErr: ErrNotAcceptable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+			for _, accept := range tt.accept {
+				r.Header.Add("Accept", accept)
+			}
+
+			got, err := NegotiateResponseMediaType(r)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		supported   []string
+		wantErr     error
+	}{
+		{
+			name:        "accepts requests without a content type",
+			contentType: "",
+		},
+		{
+			name:        "accepts application/json",
+			contentType: "application/json; charset=utf-8",
+		},
+		{
+			name:        "accepts multipart requests when supported",
+			contentType: "multipart/form-data; boundary=foo",
+			supported:   []string{MediaTypeJSON, MediaTypeMultipart},
+		},
+		{
+			name:        "rejects multipart requests when not supported",
+			contentType: "multipart/form-data; boundary=foo",
+			wantErr:     ErrUnsupportedMediaType,
+		},
+		{
+			name:        "rejects other content types",
+			contentType: "text/plain",
+			wantErr:     ErrUnsupportedMediaType,
+		},
+		{
+			name:        "rejects malformed content types",
+			contentType: "application/json;;",
+			wantErr:     ErrUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			supported := tt.supported
+			if supported == nil {
+				supported = []string{MediaTypeJSON}
+			}
+			assert.ErrorIs(t, ValidateContentType(r, supported), tt.wantErr)
+		})
+	}
+}
+
+func TestRequestErrorStatus(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	assert.Equal(t, http.StatusOK, RequestErrorStatus(r))
+
+	r = r.WithContext(WithRequestInfo(r.Context(), &RequestInfo{ResponseMediaType: MediaTypeGraphQLResponse}))
+	assert.Equal(t, http.StatusBadRequest, RequestErrorStatus(r))
+	assert.Equal(t, MediaTypeGraphQLResponse, ResponseMediaType(r))
+}
+
+func TestIsSimpleMediaType(t *testing.T) {
+	assert.True(t, IsSimpleMediaType(MediaTypeFormURLEncoded))
+	assert.True(t, IsSimpleMediaType("text/plain"))
+	assert.False(t, IsSimpleMediaType(MediaTypeJSON))
+	assert.False(t, IsSimpleMediaType(MediaTypeGraphQL))
+}
@@ -0,0 +1,362 @@
+package gql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+var (
+	ErrInvalidMultipartRequest = errors.New("invalid multipart request")
+	ErrUploadRejected          = errors.New("upload rejected")
+)
+
+// UploadError is the error of reading the body of a multipart request whose files were rejected
+type UploadError struct {
+	Err error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUploadRejected, e.Err)
+}
+
+func (e *UploadError) Unwrap() []error {
+	return []error{ErrUploadRejected, e.Err}
+}
+
+// Upload describes a file sent along with the operations of a multipart request
+type Upload struct {
+	// Name of the form field of the file
+	Name        string
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+// IsMultipart returns whether the request follows the GraphQL multipart request spec
+func IsMultipart(r *http.Request) bool {
+	_, ok := multipartBoundary(r)
+	return ok
+}
+
+// MultipartFiles returns the names of the files of a multipart request mapped to their variables.
+// Only the `operations` and `map` fields are read, the files are left untouched.
+func MultipartFiles(r *http.Request) (map[string][]string, error) {
+	boundary, ok := multipartBoundary(r)
+	if !ok {
+		return nil, nil
+	}
+
+	_, files, err := readMultipartHead(r, boundary)
+	return files, err
+}
+
+// StreamUploads checks the files of a multipart request while the body is read, without buffering them.
+// The check is called with the files found so far every time more of a file is read, and once more when the request is complete.
+// Reading the body fails with an UploadError as soon as the check fails, or when the files don't match the map.
+func StreamUploads(r *http.Request, check func(uploads []Upload, complete bool) error) error {
+	boundary, ok := multipartBoundary(r)
+	if !ok {
+		return nil
+	}
+
+	_, files, err := readMultipartHead(r, boundary)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	r.Body = &uploadStream{
+		body:   r.Body,
+		tee:    io.TeeReader(r.Body, writer),
+		writer: writer,
+		done:   make(chan struct{}),
+		start: func(stream *uploadStream) {
+			go stream.parse(reader, boundary, files, check)
+		},
+	}
+	return nil
+}
+
+// uploadStream passes the body through unchanged, while it's parsed on the side to check the files
+type uploadStream struct {
+	body   io.ReadCloser
+	tee    io.Reader
+	writer *io.PipeWriter
+	done   chan struct{}
+	// the parser is only started once the body is read, as rejected requests are never forwarded
+	start   func(stream *uploadStream)
+	started sync.Once
+	// err is set by parse before done is closed
+	err error
+}
+
+func (s *uploadStream) Read(p []byte) (int, error) {
+	s.started.Do(func() {
+		s.start(s)
+	})
+	n, err := s.tee.Read(p)
+	if errors.Is(err, io.EOF) {
+		// the files are only complete once the parser has seen the end of the body
+		_ = s.writer.Close()
+		<-s.done
+		if s.err != nil {
+			return n, s.err
+		}
+	}
+	return n, err
+}
+
+func (s *uploadStream) Close() error {
+	_ = s.writer.Close()
+	return s.body.Close()
+}
+
+func (s *uploadStream) parse(reader *io.PipeReader, boundary string, files map[string][]string, check func(uploads []Upload, complete bool) error) {
+	defer close(s.done)
+
+	if err := parseUploads(reader, boundary, files, check); err != nil {
+		s.err = &UploadError{Err: err}
+		// fails the pending and any further reads of the body
+		_ = reader.CloseWithError(s.err)
+		return
+	}
+	// whatever follows the last part is passed through as is
+	_, _ = io.Copy(io.Discard, reader)
+}
+
+// parseUploads reads the files of a multipart request following its operations and map
+func parseUploads(body io.Reader, boundary string, files map[string][]string, check func(uploads []Upload, complete bool) error) error {
+	reader := multipart.NewReader(body, boundary)
+	if _, err := nextField(reader, "operations"); err != nil {
+		return err
+	}
+	if _, err := nextField(reader, "map"); err != nil {
+		return err
+	}
+
+	var uploads []Upload
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidMultipartRequest, err)
+		}
+
+		name := part.FormName()
+		if _, ok := files[name]; !ok {
+			return fmt.Errorf("%w: file [%s] is not mapped to a variable", ErrInvalidMultipartRequest, name)
+		}
+		uploads = append(uploads, Upload{
+			Name:        name,
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+		})
+		if err := check(uploads, false); err != nil {
+			return err
+		}
+		if _, err := io.Copy(&uploadSize{uploads: uploads, check: check}, part); err != nil {
+			return err
+		}
+	}
+
+	for name := range files {
+		if !slices.ContainsFunc(uploads, func(upload Upload) bool { return upload.Name == name }) {
+			return fmt.Errorf("%w: file [%s] is missing", ErrInvalidMultipartRequest, name)
+		}
+	}
+	return check(uploads, true)
+}
+
+// uploadSize counts the size of the last file, checking it as it grows
+type uploadSize struct {
+	uploads []Upload
+	check   func(uploads []Upload, complete bool) error
+}
+
+func (u *uploadSize) Write(p []byte) (int, error) {
+	u.uploads[len(u.uploads)-1].Size += int64(len(p))
+	if err := u.check(u.uploads, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReplaceMultipartOperations replaces the operations of a multipart request, the files are forwarded unchanged
+func ReplaceMultipartOperations(r *http.Request, data []RequestData) error {
+	boundary, ok := multipartBoundary(r)
+	if !ok {
+		return ErrInvalidMultipartRequest
+	}
+
+	original := r.Body
+	head, err := readHead(original, boundary)
+	r.Body = head.restore(original)
+	if err != nil {
+		return err
+	}
+
+	operations, err := marshalOperations(head.operations, data)
+	if err != nil {
+		return err
+	}
+	offset, err := head.filesOffset(boundary)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMultipartRequest, err)
+	}
+	for _, field := range []struct {
+		name    string
+		content []byte
+	}{{"operations", operations}, {"map", head.files}} {
+		w, err := writer.CreateFormField(field.name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(field.content); err != nil {
+			return err
+		}
+	}
+
+	// the writer isn't closed, as the remainder of the original body starts with the delimiter of the next part
+	rest := head.consumed[offset:]
+	r.Body = &multipartBody{
+		Reader: io.MultiReader(&buf, bytes.NewReader(rest), original),
+		Closer: original,
+	}
+	if r.ContentLength > 0 {
+		r.ContentLength += int64(buf.Len() - offset)
+	}
+	return nil
+}
+
+// marshalOperations encodes the operations in the same shape as the original, as the paths of the files depend on it
+func marshalOperations(original []byte, data []RequestData) ([]byte, error) {
+	original = bytes.TrimSpace(original)
+	if len(original) > 0 && original[0] == '[' {
+		return json.Marshal(data)
+	}
+	if len(data) != 1 {
+		return nil, fmt.Errorf("%w: expected a single operation, found [%d]", ErrInvalidMultipartRequest, len(data))
+	}
+	return json.Marshal(data[0])
+}
+
+func multipartBoundary(r *http.Request) (string, bool) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != MediaTypeMultipart || params["boundary"] == "" {
+		return "", false
+	}
+	return params["boundary"], true
+}
+
+// readMultipartHead reads the operations and the map of files of a multipart request, and puts back what was read so the body can be read again.
+// See https://github.com/jaydenseric/graphql-multipart-request-spec
+func readMultipartHead(r *http.Request, boundary string) ([]RequestData, map[string][]string, error) {
+	original := r.Body
+	head, err := readHead(original, boundary)
+	r.Body = head.restore(original)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := unmarshalPayload(head.operations)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidMultipartRequest, err)
+	}
+	var files map[string][]string
+	if err := json.Unmarshal(head.files, &files); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidMultipartRequest, err)
+	}
+	return data, files, nil
+}
+
+// multipartHead holds the `operations` and `map` fields, which precede the files of a multipart request
+type multipartHead struct {
+	operations []byte
+	files      []byte
+	// consumed holds every byte read from the body, which may include the start of the first file
+	consumed []byte
+}
+
+func readHead(body io.Reader, boundary string) (*multipartHead, error) {
+	var consumed bytes.Buffer
+	reader := multipart.NewReader(io.TeeReader(body, &consumed), boundary)
+
+	head := &multipartHead{}
+	var err error
+	head.operations, err = nextField(reader, "operations")
+	if err == nil {
+		head.files, err = nextField(reader, "map")
+	}
+	head.consumed = consumed.Bytes()
+	return head, err
+}
+
+// restore returns the body as it was before the head was read
+func (h *multipartHead) restore(body io.ReadCloser) io.ReadCloser {
+	return &multipartBody{
+		Reader: io.MultiReader(bytes.NewReader(h.consumed), body),
+		Closer: body,
+	}
+}
+
+// filesOffset returns the offset of the line break before the delimiter following the `map` field
+func (h *multipartHead) filesOffset(boundary string) (int, error) {
+	delimiter := []byte("--" + boundary)
+	offset := 0
+	// the delimiters of the operations and map fields precede it
+	for range 3 {
+		i := bytes.Index(h.consumed[offset:], delimiter)
+		if i < 0 {
+			return 0, fmt.Errorf("%w: missing delimiter", ErrInvalidMultipartRequest)
+		}
+		offset += i + len(delimiter)
+	}
+	offset -= len(delimiter)
+
+	if bytes.HasSuffix(h.consumed[:offset], []byte("\r\n")) {
+		return offset - 2, nil
+	}
+	if bytes.HasSuffix(h.consumed[:offset], []byte("\n")) {
+		return offset - 1, nil
+	}
+	return 0, fmt.Errorf("%w: missing line break before delimiter", ErrInvalidMultipartRequest)
+}
+
+type multipartBody struct {
+	io.Reader
+	io.Closer
+}
+
+// nextField reads the next part of the request, which is expected to be the named field
+func nextField(reader *multipart.Reader, name string) ([]byte, error) {
+	part, err := reader.NextPart()
+	if err != nil {
+		countMaxBytesExceeded(err)
+		return nil, fmt.Errorf("%w: missing [%s] field: %w", ErrInvalidMultipartRequest, name, err)
+	}
+	if part.FormName() != name {
+		return nil, fmt.Errorf("%w: expected the [%s] field, found [%s]", ErrInvalidMultipartRequest, name, part.FormName())
+	}
+	content, err := io.ReadAll(part)
+	if err != nil {
+		countMaxBytesExceeded(err)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMultipartRequest, err)
+	}
+	return content, nil
+}
@@ -0,0 +1,266 @@
+package gql
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type multipartField struct {
+	name        string
+	filename    string
+	contentType string
+	content     string
+}
+
+func newMultipartRequest(t *testing.T, fields ...multipartField) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, field := range fields {
+		var w io.Writer
+		var err error
+		if field.filename != "" {
+			header := make(map[string][]string)
+			header["Content-Disposition"] = []string{`form-data; name="` + field.name + `"; filename="` + field.filename + `"`}
+			header["Content-Type"] = []string{field.contentType}
+			w, err = writer.CreatePart(header)
+		} else {
+			w, err = writer.CreateFormField(field.name)
+		}
+		require.NoError(t, err)
+		_, err = w.Write([]byte(field.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+// streamUploads reads the body of the request, returning the files found while streaming it
+func streamUploads(t *testing.T, r *http.Request) ([]Upload, error) {
+	t.Helper()
+
+	var uploads []Upload
+	err := StreamUploads(r, func(files []Upload, _ bool) error {
+		uploads = slices.Clone(files)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadAll(r.Body)
+	return uploads, err
+}
+
+func TestParseRequestPayload_Multipart(t *testing.T) {
+	tests := []struct {
+		name        string
+		fields      []multipartField
+		want        []RequestData
+		wantUploads []Upload
+		wantErr     bool
+	}{
+		{
+			name: "single operation with a file",
+			fields: []multipartField{
+				{name: "operations", content: `{"query":"mutation ($file: Upload!) { upload(file: $file) }","variables":{"file":null}}`},
+				{name: "map", content: `{"0":["variables.file"]}`},
+				{name: "0", filename: "a.png", contentType: "image/png", content: "png"},
+			},
+			want: []RequestData{
+				{
+					Query:     "mutation ($file: Upload!) { upload(file: $file) }",
+					Variables: map[string]interface{}{"file": nil},
+				},
+			},
+			wantUploads: []Upload{
+				{Name: "0", Filename: "a.png", ContentType: "image/png", Size: 3},
+			},
+		},
+		{
+			name: "batched operations with multiple files",
+			fields: []multipartField{
+				{name: "operations", content: `[{"query":"mutation ($file: Upload!) { upload(file: $file) }"},{"query":"mutation ($file: Upload!) { upload(file: $file) }"}]`},
+				{name: "map", content: `{"0":["0.variables.file"],"1":["1.variables.file"]}`},
+				{name: "0", filename: "a.txt", contentType: "text/plain", content: "a"},
+				{name: "1", filename: "b.txt", contentType: "text/plain", content: "bb"},
+			},
+			want: []RequestData{
+				{Query: "mutation ($file: Upload!) { upload(file: $file) }"},
+				{Query: "mutation ($file: Upload!) { upload(file: $file) }"},
+			},
+			wantUploads: []Upload{
+				{Name: "0", Filename: "a.txt", ContentType: "text/plain", Size: 1},
+				{Name: "1", Filename: "b.txt", ContentType: "text/plain", Size: 2},
+			},
+		},
+		{
+			name: "operations must come first",
+			fields: []multipartField{
+				{name: "map", content: `{}`},
+				{name: "operations", content: `{"query":"{ foo }"}`},
+			},
+			wantErr: true,
+		},
+		{
+			name: "files must be mapped",
+			fields: []multipartField{
+				{name: "operations", content: `{"query":"{ foo }"}`},
+				{name: "map", content: `{}`},
+				{name: "0", filename: "a.txt", contentType: "text/plain", content: "a"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mapped files must be present",
+			fields: []multipartField{
+				{name: "operations", content: `{"query":"{ foo }"}`},
+				{name: "map", content: `{"0":["variables.file"]}`},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid operations",
+			fields: []multipartField{
+				{name: "operations", content: `{"query":`},
+				{name: "map", content: `{}`},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newMultipartRequest(t, tt.fields...)
+			original := r.Header.Get("Content-Type")
+
+			got, err := ParseRequestPayload(r)
+			var uploads []Upload
+			if err == nil {
+				// the body can be read again, as only the operations and map are read ahead
+				uploads, err = streamUploads(t, r)
+			}
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidMultipartRequest)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantUploads, uploads)
+			assert.Equal(t, original, r.Header.Get("Content-Type"))
+		})
+	}
+}
+
+func TestStreamUploads_NotMultipart(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ foo }"}`))
+	r.Header.Set("Content-Type", "application/json")
+
+	uploads, err := streamUploads(t, r)
+	assert.NoError(t, err)
+	assert.Empty(t, uploads)
+	assert.False(t, IsMultipart(r))
+}
+
+func TestStreamUploads(t *testing.T) {
+	fields := []multipartField{
+		{name: "operations", content: `{"query":"mutation ($file: Upload!) { upload(file: $file) }","variables":{"file":null}}`},
+		{name: "map", content: `{"0":["variables.file"]}`},
+		{name: "0", filename: "a.txt", contentType: "text/plain", content: strings.Repeat("a", 100_000)},
+	}
+
+	t.Run("passes the body through unchanged", func(t *testing.T) {
+		r := newMultipartRequest(t, fields...)
+		original, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		r.Body = io.NopCloser(bytes.NewReader(original))
+
+		var complete bool
+		require.NoError(t, StreamUploads(r, func(_ []Upload, done bool) error {
+			complete = complete || done
+			return nil
+		}))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, original, body)
+		assert.True(t, complete)
+	})
+
+	t.Run("fails reading the body once a file is rejected", func(t *testing.T) {
+		r := newMultipartRequest(t, fields...)
+		limit := errors.New("too large")
+
+		require.NoError(t, StreamUploads(r, func(uploads []Upload, _ bool) error {
+			if uploads[0].Size > 1000 {
+				return limit
+			}
+			return nil
+		}))
+		_, err := io.ReadAll(r.Body)
+		assert.ErrorIs(t, err, ErrUploadRejected)
+		assert.ErrorIs(t, err, limit)
+	})
+
+	t.Run("files are never parsed when the body isn't read", func(t *testing.T) {
+		r := newMultipartRequest(t, fields...)
+
+		require.NoError(t, StreamUploads(r, func(_ []Upload, _ bool) error {
+			t.Fatal("files were parsed")
+			return nil
+		}))
+		assert.NoError(t, r.Body.Close())
+	})
+}
+
+func TestReplaceMultipartOperations(t *testing.T) {
+	r := newMultipartRequest(t,
+		multipartField{name: "operations", content: `{"extensions":{"persistedQuery":{"sha256Hash":"foobar"}},"variables":{"file":null}}`},
+		multipartField{name: "map", content: `{"0":["variables.file"]}`},
+		multipartField{name: "0", filename: "a.png", contentType: "image/png", content: "png"},
+	)
+
+	err := ReplaceMultipartOperations(r, []RequestData{
+		{
+			Query:     "mutation Upload($file: Upload!) { upload(file: $file) }",
+			Variables: map[string]interface{}{"file": nil},
+		},
+	})
+	require.NoError(t, err)
+
+	got, err := ParseRequestPayload(r)
+	require.NoError(t, err)
+	assert.Equal(t, []RequestData{
+		{
+			Query:     "mutation Upload($file: Upload!) { upload(file: $file) }",
+			Variables: map[string]interface{}{"file": nil},
+		},
+	}, got)
+
+	length := r.ContentLength
+	uploads, err := streamUploads(t, r)
+	require.NoError(t, err)
+	assert.Equal(t, []Upload{{Name: "0", Filename: "a.png", ContentType: "image/png", Size: 3}}, uploads)
+
+	r = newMultipartRequest(t,
+		multipartField{name: "operations", content: `{"extensions":{"persistedQuery":{"sha256Hash":"foobar"}},"variables":{"file":null}}`},
+		multipartField{name: "map", content: `{"0":["variables.file"]}`},
+		multipartField{name: "0", filename: "a.png", contentType: "image/png", content: "png"},
+	)
+	require.NoError(t, ReplaceMultipartOperations(r, []RequestData{{Query: "mutation Upload($file: Upload!) { upload(file: $file) }"}}))
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(body)), r.ContentLength)
+	assert.Greater(t, length, int64(0))
+}
@@ -0,0 +1,103 @@
+package gql
+
+import (
+	"context"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+type requestInfoKey struct{}
+
+// Operation describes a single validated operation of a request
+type Operation struct {
+	Name       string
+	Type       ast.Operation
+	RootFields []string
+	// Definition of the operation, annotated with schema definitions once validated
+	Definition *ast.OperationDefinition
+}
+
+// RequestInfo holds what protect learned about a request while validating it,
+// so components further down the chain (such as the proxy) don't have to parse the request again
+type RequestInfo struct {
+	// Operations of the request that could be resolved, which may be fewer than the operations of the request
+	Operations []Operation
+	// OperationCount is the number of operations of the request, including those that couldn't be resolved
+	OperationCount int
+	// TrustedDocuments is true when all operations of the request were resolved from trusted documents
+	TrustedDocuments bool
+	// ResponseMediaType is the media type negotiated for the response
+	ResponseMediaType string
+}
+
+// OnlyQueries returns whether the request consists of query operations only.
+// Returns false if no operations are known for the request, or if any of its operations couldn't be resolved.
+func (r *RequestInfo) OnlyQueries() bool {
+	if r == nil || len(r.Operations) == 0 || len(r.Operations) != r.OperationCount {
+		return false
+	}
+	for _, operation := range r.Operations {
+		if operation.Type != ast.Query {
+			return false
+		}
+	}
+	return true
+}
+
+// WithRequestInfo adds a RequestInfo to the request context
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext retrieves the RequestInfo from the request context
+func RequestInfoFromContext(ctx context.Context) *RequestInfo {
+	info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	if !ok {
+		return nil
+	}
+	return info
+}
+
+// NewOperation describes the operation selected by operationName within the query document.
+// Returns false if no such operation exists.
+func NewOperation(doc *ast.QueryDocument, operationName string) (Operation, bool) {
+	if doc == nil {
+		return Operation{}, false
+	}
+
+	definition := doc.Operations.ForName(operationName)
+	if definition == nil {
+		return Operation{}, false
+	}
+
+	return Operation{
+		Name:       definition.Name,
+		Type:       definition.Operation,
+		RootFields: rootFields(doc, definition.SelectionSet, map[string]bool{}),
+		Definition: definition,
+	}, true
+}
+
+func rootFields(doc *ast.QueryDocument, set ast.SelectionSet, visitedFragments map[string]bool) []string {
+	var fields []string
+	for _, selection := range set {
+		switch s := selection.(type) {
+		case *ast.Field:
+			fields = append(fields, s.Name)
+		case *ast.InlineFragment:
+			fields = append(fields, rootFields(doc, s.SelectionSet, visitedFragments)...)
+		case *ast.FragmentSpread:
+			if visitedFragments[s.Name] {
+				continue
+			}
+			visitedFragments[s.Name] = true
+
+			fragment := doc.Fragments.ForName(s.Name)
+			if fragment == nil {
+				continue
+			}
+			fields = append(fields, rootFields(doc, fragment.SelectionSet, visitedFragments)...)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,77 @@
+package gql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+func TestNewOperation(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		operationName string
+		want          Operation
+		wantOk        bool
+	}{
+		{
+			name:   "anonymous query",
+			query:  "{ product { id } user { id } }",
+			want:   Operation{Name: "", Type: ast.Query, RootFields: []string{"product", "user"}},
+			wantOk: true,
+		},
+		{
+			name:          "selects the operation by name",
+			query:         "query Foo { product { id } } mutation Bar { addProduct { id } }",
+			operationName: "Bar",
+			want:          Operation{Name: "Bar", Type: ast.Mutation, RootFields: []string{"addProduct"}},
+			wantOk:        true,
+		},
+		{
+			name:   "resolves root fields through fragments",
+			query:  "query Foo { ...F ... on Query { user { id } } } fragment F on Query { product { id } }",
+			want:   Operation{Name: "Foo", Type: ast.Query, RootFields: []string{"product", "user"}},
+			wantOk: true,
+		},
+		{
+			name:   "no operation selected when the name is ambiguous",
+			query:  "query Foo { product { id } } query Bar { user { id } }",
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parser.ParseQuery(&ast.Source{Input: tt.query})
+			assert.NoError(t, err)
+
+			got, ok := NewOperation(doc, tt.operationName)
+			assert.Equal(t, tt.wantOk, ok)
+			if ok {
+				assert.Same(t, doc.Operations.ForName(tt.operationName), got.Definition)
+				got.Definition = nil
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRequestInfoFromContext(t *testing.T) {
+	assert.Nil(t, RequestInfoFromContext(context.Background()))
+
+	info := &RequestInfo{}
+	ctx := WithRequestInfo(context.Background(), info)
+	assert.Same(t, info, RequestInfoFromContext(ctx))
+}
+
+func TestRequestInfo_OnlyQueries(t *testing.T) {
+	var missing *RequestInfo
+	assert.False(t, missing.OnlyQueries())
+	assert.False(t, (&RequestInfo{}).OnlyQueries())
+	assert.True(t, (&RequestInfo{Operations: []Operation{{Type: ast.Query}, {Type: ast.Query}}, OperationCount: 2}).OnlyQueries())
+	assert.False(t, (&RequestInfo{Operations: []Operation{{Type: ast.Query}, {Type: ast.Mutation}}, OperationCount: 2}).OnlyQueries())
+	// the operation that couldn't be resolved may be a mutation
+	assert.False(t, (&RequestInfo{Operations: []Operation{{Type: ast.Query}}, OperationCount: 2}).OnlyQueries())
+}
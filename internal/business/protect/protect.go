@@ -15,10 +15,14 @@ import (
 	"github.com/ldebruijn/graphql-protect/internal/business/gql"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/accesslogging"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/aliases"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/authentication"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/batch"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/block_field_suggestions"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/csrf_prevention"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/enforce_post"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/max_depth"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/tokens"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/uploads"
 	"github.com/ldebruijn/graphql-protect/internal/business/schema"
 	"github.com/ldebruijn/graphql-protect/internal/business/trusteddocuments"
 	"github.com/ldebruijn/graphql-protect/internal/business/validation"
@@ -31,7 +35,8 @@ import (
 )
 
 var (
-	ErrRedacted = errors.New("error(s) redacted")
+	ErrRedacted            = errors.New("error(s) redacted")
+	ErrOperationTypeForGet = errors.New("only query operations can be executed using GET requests")
 
 	tracer = otel.Tracer("github.com/ldebruijn/graphql-protect/internal/business/protect")
 )
@@ -42,10 +47,14 @@ type GraphQLProtect struct {
 	schema         *schema.Provider
 	tokens         *tokens.MaxTokensRule
 	maxBatch       *batch.MaxBatchRule
+	uploads        *uploads.UploadsRule
 	accessLogging  *accesslogging.AccessLogging
+	authentication *authentication.Authentication
+	suggestions    *block_field_suggestions.BlockFieldSuggestionsHandler
 	next           http.Handler
 	preFilterChain func(handler http.Handler) http.Handler
 	rules          *validatorrules.Rules
+	contentTypes   []string
 }
 
 func NewGraphQLProtect(log *slog.Logger, cfg *config.Config, po *trusteddocuments.Handler, schema *schema.Provider, upstreamHandler http.Handler) (*GraphQLProtect, error) {
@@ -67,20 +76,38 @@ func NewGraphQLProtect(log *slog.Logger, cfg *config.Config, po *trusteddocument
 		return nil, fmt.Errorf("failed to initialize access logging: %w", err)
 	}
 
+	suggestions, err := block_field_suggestions.NewBlockFieldSuggestionsHandler(cfg.BlockFieldSuggestions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize block field suggestions: %w", err)
+	}
+
+	auth, err := authentication.NewAuthentication(cfg.Authentication, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize authentication: %w", err)
+	}
+
 	enforcePostMethod := enforce_post.EnforcePostMethod(cfg.EnforcePost)
+	csrfPrevention := csrf_prevention.CSRFPrevention(cfg.CSRFPrevention)
+
+	uploadsRule := uploads.NewUploadsRule(cfg.Uploads)
+	contentTypes := supportedContentTypes(log, cfg.Web.ContentTypes, uploadsRule.Enabled(), cfg.CSRFPrevention.Enabled)
 
 	return &GraphQLProtect{
-		log:           log,
-		cfg:           cfg,
-		schema:        schema,
-		tokens:        tokens.MaxTokens(cfg.MaxTokens),
-		maxBatch:      maxBatch,
-		accessLogging: accessLogging,
+		log:            log,
+		cfg:            cfg,
+		schema:         schema,
+		tokens:         tokens.MaxTokens(cfg.MaxTokens),
+		maxBatch:       maxBatch,
+		uploads:        uploadsRule,
+		accessLogging:  accessLogging,
+		authentication: auth,
+		suggestions:    suggestions,
 		preFilterChain: func(next http.Handler) http.Handler {
-			return enforcePostMethod(po.SwapHashForQuery(next))
+			return enforcePostMethod(csrfPrevention(auth.Handle(po.SwapHashForQuery(next))))
 		},
-		next:  upstreamHandler,
-		rules: rules,
+		next:         upstreamHandler,
+		rules:        rules,
+		contentTypes: contentTypes,
 	}, nil
 }
 
@@ -92,6 +119,18 @@ func (p *GraphQLProtect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	mediaType, err := gql.NegotiateResponseMediaType(r)
+	if err != nil {
+		writeError(w, gql.MediaTypeJSON, http.StatusNotAcceptable, err)
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := gql.ValidateContentType(r, p.supportedContentTypes()); err != nil {
+			writeError(w, mediaType, http.StatusUnsupportedMediaType, err)
+			return
+		}
+	}
+
 	ctx := r.Context()
 
 	// Create timing context if not already present (middleware normally provides this)
@@ -100,6 +139,13 @@ func (p *GraphQLProtect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		ctx = WithTimingContext(ctx, tc)
 	}
 
+	info := gql.RequestInfoFromContext(ctx)
+	if info == nil {
+		info = &gql.RequestInfo{}
+		ctx = gql.WithRequestInfo(ctx, info)
+	}
+	info.ResponseMediaType = mediaType
+
 	ctx, span := tracer.Start(ctx, "Handle Request")
 	defer span.End()
 	p.preFilterChain(http.HandlerFunc(p.handle)).ServeHTTP(w, r.WithContext(ctx))
@@ -107,8 +153,8 @@ func (p *GraphQLProtect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (p *GraphQLProtect) handle(w http.ResponseWriter, r *http.Request) {
 	ctx, span := tracer.Start(r.Context(), "Setup Request Body Limit")
-	if p.cfg.Web.RequestBodyMaxBytes != 0 {
-		r.Body = http.MaxBytesReader(w, r.Body, int64(p.cfg.Web.RequestBodyMaxBytes))
+	if limit := p.bodyLimit(r); limit != 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
 	}
 	span.End()
 
@@ -120,6 +166,11 @@ func (p *GraphQLProtect) handle(w http.ResponseWriter, r *http.Request) {
 
 	if len(validationErrors) > 0 {
 		_, span := tracer.Start(ctx, "Handle Validation Errors")
+		if p.suggestions != nil && p.suggestions.Enabled() {
+			for _, validationError := range validationErrors {
+				p.suggestions.MaskError(validationError)
+			}
+		}
 		if p.cfg.ObfuscateValidationErrors {
 			validationErrors = gqlerror.List{gqlerror.Wrap(ErrRedacted)}
 		}
@@ -129,7 +180,14 @@ func (p *GraphQLProtect) handle(w http.ResponseWriter, r *http.Request) {
 			"errors": validationErrors,
 		}
 
-		w.Header().Set("Content-Type", "application/json")
+		mediaType := gql.ResponseMediaType(r)
+		if mediaType == gql.MediaTypeGraphQLResponse {
+			// the operation wasn't executed, so the response must not contain data
+			delete(response, "data")
+		}
+
+		w.Header().Set("Content-Type", mediaType)
+		w.WriteHeader(gql.RequestErrorStatus(r))
 		err := json.NewEncoder(w).Encode(response)
 		if err != nil {
 			p.log.Error("could not encode error", "err", err)
@@ -138,16 +196,82 @@ func (p *GraphQLProtect) handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method == http.MethodGet && !onlyQueries(gql.RequestInfoFromContext(ctx), len(payloads)) {
+		// GET requests are safe by definition, so mutations could be triggered by a link or cached by intermediaries
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, gql.ResponseMediaType(r), http.StatusMethodNotAllowed, ErrOperationTypeForGet)
+		return
+	}
+
 	tc := TimingContextFromContext(ctx)
 	if tc != nil {
 		tc.MarkEnd()
 	}
 
+	if err := gql.NormalizeBody(r, payloads); err != nil {
+		p.log.Warn("could not normalize request body", "err", err)
+	}
+
 	ctx, span = tracer.Start(ctx, "Proxy to Upstream")
 	p.next.ServeHTTP(w, r.WithContext(ctx))
 	span.End()
 }
 
+// bodyLimit returns the maximum size of the request body, multipart requests are allowed to be larger to accommodate the files
+func (p *GraphQLProtect) bodyLimit(r *http.Request) int64 {
+	limit := int64(p.cfg.Web.RequestBodyMaxBytes)
+	if p.uploads != nil && p.uploads.Enabled() && gql.IsMultipart(r) {
+		return p.uploads.BodyLimit(limit)
+	}
+	return limit
+}
+
+// onlyQueries returns whether all n operations of the request are known to be queries
+func onlyQueries(info *gql.RequestInfo, n int) bool {
+	if n == 0 {
+		return true
+	}
+	return info.OnlyQueries()
+}
+
+// supportedContentTypes returns the media types accepted as request body, multipart requests are only accepted when uploads are enabled
+func supportedContentTypes(log *slog.Logger, configured []string, uploadsEnabled bool, csrfPrevention bool) []string {
+	contentTypes := make([]string, 0, len(configured)+1)
+	for _, contentType := range configured {
+		if contentType == gql.MediaTypeMultipart {
+			continue
+		}
+		if gql.IsSimpleMediaType(contentType) && !csrfPrevention {
+			log.Warn("Accepting a content type that browsers can send cross-origin without a preflight request, which makes the API susceptible to cross-site request forgery. Enable CSRF prevention to protect against this", "contentType", contentType)
+		}
+		contentTypes = append(contentTypes, contentType)
+	}
+	if len(contentTypes) == 0 {
+		contentTypes = append(contentTypes, gql.MediaTypeJSON)
+	}
+	if uploadsEnabled {
+		contentTypes = append(contentTypes, gql.MediaTypeMultipart)
+	}
+	return contentTypes
+}
+
+func (p *GraphQLProtect) supportedContentTypes() []string {
+	if len(p.contentTypes) == 0 {
+		return []string{gql.MediaTypeJSON}
+	}
+	return p.contentTypes
+}
+
+// writeError responds with a single GraphQL error, for requests rejected before they're parsed
+func writeError(w http.ResponseWriter, mediaType string, status int, err error) {
+	res, _ := json.Marshal(map[string]interface{}{
+		"errors": gqlerror.List{gqlerror.Wrap(err)},
+	})
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(status)
+	_, _ = w.Write(res)
+}
+
 func (p *GraphQLProtect) validateRequest(r *http.Request) ([]gql.RequestData, gqlerror.List) {
 	tc := TimingContextFromContext(r.Context())
 
@@ -164,6 +288,12 @@ func (p *GraphQLProtect) validateRequest(r *http.Request) ([]gql.RequestData, gq
 		return nil, errs
 	}
 
+	err = p.validateUploadsAndTime(r.Context(), r, tc)
+	if err != nil {
+		errs = append(errs, gqlerror.Wrap(err))
+		return nil, errs
+	}
+
 	errs = p.validateQueriesAndTime(r.Context(), payload, tc)
 
 	_, span := tracer.Start(r.Context(), "Filter Rejected Errors")
@@ -201,17 +331,50 @@ func (p *GraphQLProtect) validateBatchAndTime(ctx context.Context, payload []gql
 	return err
 }
 
+func (p *GraphQLProtect) validateUploadsAndTime(ctx context.Context, r *http.Request, tc *TimingContext) error {
+	if p.uploads == nil || !p.uploads.Enabled() {
+		return nil
+	}
+
+	_, span := tracer.Start(ctx, "Validate Upload Limits")
+	start := time.Now()
+	// the amount of files is known up front, the files themselves are checked while they're forwarded to the upstream
+	files, err := gql.MultipartFiles(r)
+	if err == nil {
+		err = p.uploads.ValidateCount(len(files))
+	}
+	if err == nil {
+		err = gql.StreamUploads(r, p.uploads.Check)
+	}
+	span.End()
+
+	if tc != nil {
+		duration := time.Since(start)
+		tc.RecordPhase("uploads_check", duration)
+		RecordValidationDuration("uploads_check", resultFromError(err), duration)
+	}
+	return err
+}
+
 func (p *GraphQLProtect) validateQueriesAndTime(ctx context.Context, payload []gql.RequestData, tc *TimingContext) gqlerror.List {
 	_, span := tracer.Start(ctx, "Validate Individual Queries")
 	start := time.Now()
 
+	info := gql.RequestInfoFromContext(ctx)
+	if info != nil {
+		info.OperationCount = len(payload)
+	}
+
 	var errs gqlerror.List
 	for _, data := range payload {
 		_, querySpan := tracer.Start(ctx, "Validate Query")
-		validationErrors := p.ValidateQuery(ctx, data)
+		query, validationErrors := p.validateQuery(ctx, data)
 		if len(validationErrors) > 0 {
 			errs = append(errs, validationErrors...)
 		}
+		if operation, ok := gql.NewOperation(query, data.OperationName); ok && info != nil {
+			info.Operations = append(info.Operations, operation)
+		}
 		querySpan.End()
 	}
 
@@ -243,6 +406,12 @@ func filterRejected(errs gqlerror.List) gqlerror.List {
 }
 
 func (p *GraphQLProtect) ValidateQuery(ctx context.Context, data gql.RequestData) gqlerror.List {
+	_, errs := p.validateQuery(ctx, data)
+	return errs
+}
+
+// validateQuery validates the operation and returns the parsed query document, if it could be parsed
+func (p *GraphQLProtect) validateQuery(ctx context.Context, data gql.RequestData) (*ast.QueryDocument, gqlerror.List) {
 	tc := TimingContextFromContext(ctx)
 
 	ctx, span := tracer.Start(ctx, "Create Operation Source")
@@ -260,7 +429,7 @@ func (p *GraphQLProtect) ValidateQuery(ctx context.Context, data gql.RequestData
 		RecordValidationDuration("tokens", resultFromError(err), duration)
 	}
 	if err != nil {
-		return gqlerror.List{gqlerror.Wrap(err)}
+		return nil, gqlerror.List{gqlerror.Wrap(err)}
 	}
 
 	ctx, span = tracer.Start(ctx, "Parse GraphQL Query")
@@ -272,7 +441,7 @@ func (p *GraphQLProtect) ValidateQuery(ctx context.Context, data gql.RequestData
 		RecordValidationDuration("parse_gql", resultFromError(err), duration)
 	}
 	if err != nil {
-		return gqlerror.List{gqlerror.Wrap(err)}
+		return nil, gqlerror.List{gqlerror.Wrap(err)}
 	}
 
 	_, span = tracer.Start(ctx, "Validate with Protection Rules")
@@ -284,7 +453,7 @@ func (p *GraphQLProtect) ValidateQuery(ctx context.Context, data gql.RequestData
 		RecordValidationDuration("schema_validate", resultFromErrors(result), duration)
 	}
 
-	return result
+	return query, result
 }
 
 func resultFromError(err error) string {
@@ -305,3 +474,7 @@ func resultFromErrors(errs gqlerror.List) string {
 func (p *GraphQLProtect) ShutdownAccessLogging(ctx context.Context) error {
 	return p.accessLogging.Shutdown(ctx)
 }
+
+func (p *GraphQLProtect) ShutdownAuthentication() {
+	p.authentication.Shutdown()
+}
@@ -1,21 +1,33 @@
 package protect
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
 	"github.com/ldebruijn/graphql-protect/internal/app/config"
 	_http "github.com/ldebruijn/graphql-protect/internal/app/http"
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/accesslogging"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/authentication"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/batch"
 	block_field_suggestions "github.com/ldebruijn/graphql-protect/internal/business/rules/block_field_suggestions"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/tokens"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/uploads"
 	"github.com/ldebruijn/graphql-protect/internal/business/schema"
 	"github.com/ldebruijn/graphql-protect/internal/business/trusteddocuments"
 	"github.com/stretchr/testify/assert"
@@ -184,7 +196,7 @@ func createTestSchemaProvider(t *testing.T) *schema.Provider {
 	}
 	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
 
-	schemaContent := `type Query { hello: String }`
+	schemaContent := `type Query { hello: String } type Mutation { hello: String }`
 	if _, err := tmpFile.WriteString(schemaContent); err != nil {
 		t.Fatalf("Failed to write schema: %v", err)
 	}
@@ -419,4 +431,473 @@ func TestNewGraphQLProtect_BlockFieldSuggestionsEnabled_NoSuggestionsInValidatio
 	body, _ := io.ReadAll(w.Result().Body)
 	assert.NotContains(t, string(body), "Did you mean",
 		"protect-layer validation errors must not leak field suggestions when BlockFieldSuggestions is enabled")
+
+	// suggestions of other validation rules, such as unknown arguments, are masked as well
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ __type(nme: \"Query\") { name } }"}`))
+	p.ServeHTTP(w, r)
+
+	body, _ = io.ReadAll(w.Result().Body)
+	assert.NotContains(t, string(body), "Did you mean")
+	assert.Contains(t, string(body), "[redacted]")
+}
+
+func TestGraphQLProtect_GraphQLOverHTTP(t *testing.T) {
+	log := slog.Default()
+	schemaProvider := createTestSchemaProvider(t)
+
+	noopLoader, err := trusteddocuments.NewNoOpLoader()
+	require.NoError(t, err)
+	po, err := trusteddocuments.NewPersistedOperations(log, trusteddocuments.Config{
+		Enabled: false,
+		Loader: trusteddocuments.LoaderConfig{
+			Reload: struct {
+				Enabled  bool          `yaml:"enabled"`
+				Interval time.Duration `yaml:"interval"`
+				Timeout  time.Duration `yaml:"timeout"`
+			}{Enabled: false},
+		},
+	}, noopLoader)
+	require.NoError(t, err)
+
+	p, err := NewGraphQLProtect(log, &config.Config{}, po, schemaProvider, &noop{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		accept          string
+		contentType     string
+		body            string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "validation errors are returned with a 200 for application/json",
+			accept:          "application/json",
+			contentType:     "application/json",
+			body:            `{"query":"{ unknown }"}`,
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `{"data":null,"errors":[{"message":"Cannot query field \"unknown\" on type \"Query\".","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name:            "validation errors are returned with a 400 without data for application/graphql-response+json",
+			accept:          "application/graphql-response+json",
+			contentType:     "application/json",
+			body:            `{"query":"{ unknown }"}`,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "application/graphql-response+json",
+			wantBody:        `{"errors":[{"message":"Cannot query field \"unknown\" on type \"Query\".","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name:            "unsupported Accept headers are rejected",
+			accept:          "text/html",
+			contentType:     "application/json",
+			body:            `{"query":"{ hello }"}`,
+			wantStatus:      http.StatusNotAcceptable,
+			wantContentType: "application/json",
+			wantBody:        `{"errors":[{"message":"none of the accepted media types are supported, use application/graphql-response+json or application/json"}]}`,
+		},
+		{
+			name:            "unsupported content types are rejected",
+			accept:          "application/graphql-response+json",
+			contentType:     "text/plain",
+			body:            `{"query":"{ hello }"}`,
+			wantStatus:      http.StatusUnsupportedMediaType,
+			wantContentType: "application/graphql-response+json",
+			wantBody:        `{"errors":[{"message":"unsupported content type, use application/json"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.body))
+			r.Header.Set("Accept", tt.accept)
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			p.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantContentType, w.Header().Get("Content-Type"))
+			assert.JSONEq(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
+
+type recordingHandler struct {
+	called      bool
+	contentType string
+	body        string
+}
+
+func (h *recordingHandler) ServeHTTP(_ http.ResponseWriter, r *http.Request) {
+	h.called = true
+	h.contentType = r.Header.Get("Content-Type")
+	body, _ := io.ReadAll(r.Body)
+	h.body = string(body)
+}
+
+func TestGraphQLProtect_GetRequests(t *testing.T) {
+	log := slog.Default()
+	schemaProvider := createTestSchemaProvider(t)
+
+	noopLoader, err := trusteddocuments.NewNoOpLoader()
+	require.NoError(t, err)
+	po, err := trusteddocuments.NewPersistedOperations(log, trusteddocuments.Config{
+		Enabled: false,
+		Loader: trusteddocuments.LoaderConfig{
+			Reload: struct {
+				Enabled  bool          `yaml:"enabled"`
+				Interval time.Duration `yaml:"interval"`
+				Timeout  time.Duration `yaml:"timeout"`
+			}{Enabled: false},
+		},
+	}, noopLoader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantCalled bool
+		wantStatus int
+		wantAllow  string
+	}{
+		{
+			name:       "queries are forwarded",
+			query:      "query Hello { hello }",
+			wantCalled: true,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "mutations are rejected",
+			query:      "mutation Hello { hello }",
+			wantCalled: false,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantAllow:  http.MethodPost,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &recordingHandler{}
+			p, err := NewGraphQLProtect(log, &config.Config{}, po, schemaProvider, next)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodGet, "/graphql?"+url.Values{"query": {tt.query}}.Encode(), nil)
+			w := httptest.NewRecorder()
+
+			p.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantCalled, next.called)
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantAllow, w.Header().Get("Allow"))
+		})
+	}
+}
+
+func TestGraphQLProtect_MultipartUploads(t *testing.T) {
+	log := slog.Default()
+	schemaProvider := createTestSchemaProvider(t)
+
+	noopLoader, err := trusteddocuments.NewNoOpLoader()
+	require.NoError(t, err)
+	po, err := trusteddocuments.NewPersistedOperations(log, trusteddocuments.Config{
+		Enabled: false,
+		Loader: trusteddocuments.LoaderConfig{
+			Reload: struct {
+				Enabled  bool          `yaml:"enabled"`
+				Interval time.Duration `yaml:"interval"`
+				Timeout  time.Duration `yaml:"timeout"`
+			}{Enabled: false},
+		},
+	}, noopLoader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		uploads    uploads.Config
+		files      int
+		wantCalled bool
+		wantStatus int
+	}{
+		{
+			name:       "multipart requests are rejected when uploads are disabled",
+			uploads:    uploads.Config{Enabled: false},
+			files:      1,
+			wantCalled: false,
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "multipart requests within the limits are forwarded",
+			uploads:    uploads.Config{Enabled: true, MaxFiles: 2},
+			files:      2,
+			wantCalled: true,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "multipart requests exceeding the limits are rejected",
+			uploads:    uploads.Config{Enabled: true, MaxFiles: 1},
+			files:      2,
+			wantCalled: false,
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &recordingHandler{}
+			p, err := NewGraphQLProtect(log, &config.Config{Uploads: tt.uploads}, po, schemaProvider, next)
+			require.NoError(t, err)
+
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			_ = writer.WriteField("operations", `{"query":"mutation { hello }","variables":{"files":[]}}`)
+			fileMap := map[string][]string{}
+			for i := range tt.files {
+				fileMap[strconv.Itoa(i)] = []string{fmt.Sprintf("variables.files.%d", i)}
+			}
+			bts, _ := json.Marshal(fileMap)
+			_ = writer.WriteField("map", string(bts))
+			for i := range tt.files {
+				part, _ := writer.CreateFormFile(strconv.Itoa(i), "file.txt")
+				_, _ = part.Write([]byte("content"))
+			}
+			require.NoError(t, writer.Close())
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", &body)
+			r.Header.Set("Content-Type", writer.FormDataContentType())
+			w := httptest.NewRecorder()
+
+			p.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantCalled, next.called)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+// bodyReadingHandler reads the request body like the upstream would, recording the error of reading it
+type bodyReadingHandler struct {
+	size int
+	err  error
+}
+
+func (h *bodyReadingHandler) ServeHTTP(_ http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	h.size = len(body)
+	h.err = err
+}
+
+func TestGraphQLProtect_MultipartUploadsStreamed(t *testing.T) {
+	log := slog.Default()
+	schemaProvider := createTestSchemaProvider(t)
+
+	noopLoader, err := trusteddocuments.NewNoOpLoader()
+	require.NoError(t, err)
+	po, err := trusteddocuments.NewPersistedOperations(log, trusteddocuments.Config{
+		Enabled: false,
+		Loader: trusteddocuments.LoaderConfig{
+			Reload: struct {
+				Enabled  bool          `yaml:"enabled"`
+				Interval time.Duration `yaml:"interval"`
+				Timeout  time.Duration `yaml:"timeout"`
+			}{Enabled: false},
+		},
+	}, noopLoader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		size    int
+		wantErr error
+	}{
+		{
+			name: "files larger than the request body limit are accepted within the upload limits",
+			size: 2000,
+		},
+		{
+			name:    "files exceeding the file size limit are rejected while they're read",
+			size:    4000,
+			wantErr: uploads.ErrMaxFileSizeExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &bodyReadingHandler{}
+			p, err := NewGraphQLProtect(log, &config.Config{
+				Web:     _http.Config{RequestBodyMaxBytes: 1000},
+				Uploads: uploads.Config{Enabled: true, MaxFiles: 1, MaxFileSize: 3000, MaxTotalSize: 5000},
+			}, po, schemaProvider, next)
+			require.NoError(t, err)
+
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			_ = writer.WriteField("operations", `{"query":"mutation { hello }","variables":{"file":null}}`)
+			_ = writer.WriteField("map", `{"0":["variables.file"]}`)
+			part, _ := writer.CreateFormFile("0", "file.txt")
+			_, _ = part.Write(bytes.Repeat([]byte("a"), tt.size))
+			require.NoError(t, writer.Close())
+			length := body.Len()
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", &body)
+			r.Header.Set("Content-Type", writer.FormDataContentType())
+			p.ServeHTTP(httptest.NewRecorder(), r)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, next.err, gql.ErrUploadRejected)
+				assert.ErrorIs(t, next.err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, next.err)
+			assert.Equal(t, length, next.size)
+		})
+	}
+}
+
+func TestGraphQLProtect_ContentTypes(t *testing.T) {
+	log := slog.Default()
+	schemaProvider := createTestSchemaProvider(t)
+
+	noopLoader, err := trusteddocuments.NewNoOpLoader()
+	require.NoError(t, err)
+	po, err := trusteddocuments.NewPersistedOperations(log, trusteddocuments.Config{
+		Enabled: false,
+		Loader: trusteddocuments.LoaderConfig{
+			Reload: struct {
+				Enabled  bool          `yaml:"enabled"`
+				Interval time.Duration `yaml:"interval"`
+				Timeout  time.Duration `yaml:"timeout"`
+			}{Enabled: false},
+		},
+	}, noopLoader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		contentTypes []string
+		contentType  string
+		body         string
+		wantStatus   int
+		wantBody     string
+	}{
+		{
+			name:         "application/graphql is forwarded as json",
+			contentTypes: _http.DefaultConfig().ContentTypes,
+			contentType:  "application/graphql",
+			body:         "query Hello { hello }",
+			wantStatus:   http.StatusOK,
+			wantBody:     `{"query":"query Hello { hello }","extensions":{}}`,
+		},
+		{
+			name:         "form encoded bodies are rejected by default",
+			contentTypes: _http.DefaultConfig().ContentTypes,
+			contentType:  "application/x-www-form-urlencoded",
+			body:         "query=query+Hello+%7B+hello+%7D",
+			wantStatus:   http.StatusUnsupportedMediaType,
+		},
+		{
+			name:         "form encoded bodies are forwarded as json when accepted",
+			contentTypes: []string{"application/json", "application/x-www-form-urlencoded"},
+			contentType:  "application/x-www-form-urlencoded",
+			body:         "query=query+Hello+%7B+hello+%7D&operationName=Hello",
+			wantStatus:   http.StatusOK,
+			wantBody:     `{"operationName":"Hello","query":"query Hello { hello }","extensions":{}}`,
+		},
+		{
+			name:         "text/plain is rejected by default",
+			contentTypes: _http.DefaultConfig().ContentTypes,
+			contentType:  "text/plain",
+			body:         `{"query":"query Hello { hello }"}`,
+			wantStatus:   http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &recordingHandler{}
+			p, err := NewGraphQLProtect(log, &config.Config{Web: _http.Config{ContentTypes: tt.contentTypes}}, po, schemaProvider, next)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			p.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody == "" {
+				assert.False(t, next.called)
+				return
+			}
+			assert.Equal(t, "application/json", next.contentType)
+			assert.JSONEq(t, tt.wantBody, next.body)
+		})
+	}
+}
+
+func TestGraphQLProtect_Authentication(t *testing.T) {
+	log := slog.Default()
+	schemaProvider := createTestSchemaProvider(t)
+
+	noopLoader, err := trusteddocuments.NewNoOpLoader()
+	require.NoError(t, err)
+	po, err := trusteddocuments.NewPersistedOperations(log, trusteddocuments.Config{}, noopLoader)
+	require.NoError(t, err)
+
+	secret := []byte("a-secret-of-at-least-thirty-two-bytes")
+	jwks, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: secret, KeyID: "hmac"}}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwks, 0o600))
+
+	cfg := authentication.DefaultConfig()
+	cfg.Enabled = true
+	cfg.Algorithms = []string{"HS256"}
+	cfg.JWKS.File = path
+	cfg.JWKS.RefreshInterval = 0
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: secret}, (&jose.SignerOptions{}).WithHeader("kid", "hmac"))
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{Subject: "user-1", Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}).Serialize()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		token       string
+		wantStatus  int
+		wantSubject string
+	}{
+		{
+			name:        "forwards authenticated requests with their claims",
+			token:       token,
+			wantStatus:  http.StatusOK,
+			wantSubject: "user-1",
+		},
+		{
+			name:       "rejects unauthenticated requests before validating them",
+			token:      "",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var subject string
+			next := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				claims, _ := authentication.ClaimsFromContext(r.Context())
+				subject = claims.Subject()
+			})
+			p, err := NewGraphQLProtect(log, &config.Config{Authentication: cfg}, po, schemaProvider, next)
+			require.NoError(t, err)
+			defer p.ShutdownAuthentication()
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"query Hello { hello }"}`))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+
+			p.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantSubject, subject)
+		})
+	}
 }
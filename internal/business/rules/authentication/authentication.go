@@ -0,0 +1,289 @@
+package authentication
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+var resultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "graphql_protect",
+	Subsystem: "authentication",
+	Name:      "results",
+	Help:      "The results of verifying the tokens of requests",
+},
+	[]string{"result", "reason"},
+)
+
+func init() {
+	prometheus.MustRegister(resultCounter, jwksLoadCounter)
+}
+
+var (
+	ErrUnauthenticated      = errors.New("a valid token is required")
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrUnknownKey           = errors.New("no key found to verify the token")
+	ErrInvalidSignature     = errors.New("token signature could not be verified")
+	ErrMissingExpiry        = errors.New("token has no expiry (exp)")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+	ErrInvalidJWKSConfig    = errors.New("exactly one of jwks.file or jwks.url is required")
+	ErrInvalidRefresh       = errors.New("jwks.refresh_interval cannot be less than 10 seconds")
+)
+
+var supportedAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.HS256, jose.HS384, jose.HS512,
+}
+
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Reject requests without a token. Requests with an invalid token are always rejected
+	Required bool `yaml:"required"`
+	// Request header holding the token, a `Bearer` prefix is stripped. Values using another scheme, such as `Basic`, are treated as requests without a token
+	Header string `yaml:"header"`
+	// Signature algorithms accepted, any of RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512, HS256, HS384 or HS512
+	Algorithms []string `yaml:"algorithms"`
+	// Key set used to verify the signature of tokens
+	JWKS JWKSConfig `yaml:"jwks"`
+	// Expected `iss` claim, not checked when empty
+	Issuer string `yaml:"issuer"`
+	// The `aud` claim must contain at least one of these audiences, not checked when empty
+	Audiences []string `yaml:"audiences"`
+	// Allowed clock skew when checking the `exp`, `nbf` and `iat` claims
+	Leeway time.Duration `yaml:"leeway"`
+}
+
+type JWKSConfig struct {
+	// Path of a local JSON Web Key Set
+	File string `yaml:"file"`
+	// URL to fetch the JSON Web Key Set from, such as the `jwks_uri` of an identity provider
+	URL string `yaml:"url"`
+	// Interval at which the key set is reloaded, `0s` disables reloading. Tokens signed by an unknown key also trigger a reload, at most once every 10 seconds
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// Timeout of fetching the key set from the URL
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:    false,
+		Required:   true,
+		Header:     "Authorization",
+		Algorithms: []string{string(jose.RS256), string(jose.ES256)},
+		JWKS: JWKSConfig{
+			RefreshInterval: 5 * time.Minute,
+			Timeout:         10 * time.Second,
+		},
+		Audiences: []string{},
+		Leeway:    time.Minute,
+	}
+}
+
+// Authentication verifies the JWT of requests, and makes the verified claims available through the request context
+type Authentication struct {
+	cfg        Config
+	log        *slog.Logger
+	algorithms []jose.SignatureAlgorithm
+	keys       *keySet
+	rejection  []byte
+}
+
+func NewAuthentication(cfg Config, log *slog.Logger) (*Authentication, error) {
+	a := &Authentication{
+		cfg: cfg,
+		log: log,
+	}
+	if !cfg.Enabled {
+		return a, nil
+	}
+
+	algorithms, err := parseAlgorithms(cfg.Algorithms)
+	if err != nil {
+		return nil, err
+	}
+	a.algorithms = algorithms
+
+	keys, err := newKeySet(cfg.JWKS, log)
+	if err != nil {
+		return nil, err
+	}
+	a.keys = keys
+
+	a.rejection, _ = json.Marshal(map[string]interface{}{
+		"errors": gqlerror.List{{
+			Message: ErrUnauthenticated.Error(),
+			Extensions: map[string]interface{}{
+				"code": "UNAUTHENTICATED",
+			},
+		}},
+	})
+	return a, nil
+}
+
+func parseAlgorithms(names []string) ([]jose.SignatureAlgorithm, error) {
+	algorithms := make([]jose.SignatureAlgorithm, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, supported := range supportedAlgorithms {
+			if strings.EqualFold(name, string(supported)) {
+				algorithms = append(algorithms, supported)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, name)
+		}
+	}
+	if len(algorithms) == 0 {
+		return nil, fmt.Errorf("%w: no algorithms configured", ErrUnsupportedAlgorithm)
+	}
+	return algorithms, nil
+}
+
+// Handle rejects requests with an invalid token, or without a token when one is required.
+// The claims of valid tokens are added to the request context.
+func (a *Authentication) Handle(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !a.cfg.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := a.token(r)
+		if token == "" {
+			if a.cfg.Required {
+				resultCounter.WithLabelValues("rejected", "missing_token").Inc()
+				a.reject(w, r)
+				return
+			}
+			resultCounter.WithLabelValues("anonymous", "").Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := a.Verify(token)
+		if err != nil {
+			resultCounter.WithLabelValues("rejected", reason(err)).Inc()
+			a.log.Debug("Rejected request with invalid token", "err", err)
+			a.reject(w, r)
+			return
+		}
+
+		resultCounter.WithLabelValues("authenticated", "").Inc()
+		next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// token returns the bearer token of the request. Values using another scheme, such as `Basic`, hold no token.
+// Custom headers may hold the token without a scheme, the `Authorization` header always requires one.
+func (a *Authentication) token(r *http.Request) string {
+	value := strings.TrimSpace(r.Header.Get(a.cfg.Header))
+	scheme, token, ok := strings.Cut(value, " ")
+	switch {
+	case ok && strings.EqualFold(scheme, "Bearer"):
+		return strings.TrimSpace(token)
+	case ok, strings.EqualFold(a.cfg.Header, "Authorization"):
+		return ""
+	default:
+		return value
+	}
+}
+
+func (a *Authentication) reject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.Header().Set("Content-Type", gql.ResponseMediaType(r))
+	w.WriteHeader(http.StatusUnauthorized)
+	_, _ = w.Write(a.rejection)
+}
+
+// Verify checks the signature and the registered claims of a token, and returns its claims
+func (a *Authentication) Verify(token string) (Claims, error) {
+	tok, err := jwt.ParseSigned(token, a.algorithms)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	header := tok.Headers[0]
+
+	keys := a.keys.find(header.KeyID)
+	if len(keys) == 0 && a.keys.refreshUnknownKey() {
+		keys = a.keys.find(header.KeyID)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, header.KeyID)
+	}
+
+	var registered jwt.Claims
+	var claims Claims
+	verified := false
+	for _, key := range keys {
+		if (key.Algorithm != "" && key.Algorithm != header.Algorithm) || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		if err := tok.Claims(verificationKey(key), &registered, &claims); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalidSignature
+	}
+
+	if registered.Expiry == nil {
+		return nil, ErrMissingExpiry
+	}
+	err = registered.ValidateWithLeeway(jwt.Expected{
+		Issuer:      a.cfg.Issuer,
+		AnyAudience: a.cfg.Audiences,
+		Time:        time.Now(),
+	}, a.cfg.Leeway)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verificationKey returns the public key of asymmetric keys, so key sets holding private keys can be used as well
+func verificationKey(key jose.JSONWebKey) jose.JSONWebKey {
+	if _, symmetric := key.Key.([]byte); symmetric || key.IsPublic() {
+		return key
+	}
+	return key.Public()
+}
+
+func reason(err error) string {
+	switch {
+	case errors.Is(err, ErrUnknownKey):
+		return "unknown_key"
+	case errors.Is(err, ErrInvalidSignature):
+		return "invalid_signature"
+	case errors.Is(err, ErrMissingExpiry), errors.Is(err, jwt.ErrExpired):
+		return "expired"
+	case errors.Is(err, jwt.ErrNotValidYet), errors.Is(err, jwt.ErrIssuedInTheFuture):
+		return "not_valid_yet"
+	case errors.Is(err, jwt.ErrInvalidIssuer), errors.Is(err, jwt.ErrInvalidAudience):
+		return "invalid_claims"
+	default:
+		return "invalid_token"
+	}
+}
+
+// Shutdown stops reloading the key set
+func (a *Authentication) Shutdown() {
+	if a.keys != nil {
+		a.keys.shutdown()
+	}
+}
@@ -0,0 +1,513 @@
+package authentication
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	hmac []byte
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return testKeys{
+		rsa:  rsaKey,
+		ec:   ecKey,
+		hmac: []byte("a-secret-of-at-least-thirty-two-bytes"),
+	}
+}
+
+// jwks returns the key set to verify the test keys with, holding only the public keys of the asymmetric keys
+func (k testKeys) jwks() []byte {
+	bts, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &k.rsa.PublicKey, KeyID: "rsa", Algorithm: string(jose.RS256), Use: "sig"},
+		{Key: &k.ec.PublicKey, KeyID: "ec", Algorithm: string(jose.ES256), Use: "sig"},
+		{Key: k.hmac, KeyID: "hmac", Algorithm: string(jose.HS256), Use: "sig"},
+	}})
+	return bts
+}
+
+func sign(t *testing.T, alg jose.SignatureAlgorithm, key interface{}, kid string, claims interface{}) string {
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if kid != "" {
+		opts = opts.WithHeader(jose.HeaderKey("kid"), kid)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts)
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	require.NoError(t, err)
+	return token
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "user-1",
+		"iss":   "https://issuer.example.com",
+		"aud":   []string{"graphql"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"roles": []string{"admin"},
+	}
+}
+
+func with(claims map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	if value == nil {
+		delete(claims, key)
+	} else {
+		claims[key] = value
+	}
+	return claims
+}
+
+func newTestConfig(t *testing.T, keys testKeys) Config {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, keys.jwks(), 0o600))
+
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	cfg.Algorithms = []string{"RS256", "ES256", "HS256"}
+	cfg.JWKS.File = path
+	cfg.JWKS.RefreshInterval = 0
+	cfg.Issuer = "https://issuer.example.com"
+	cfg.Audiences = []string{"graphql"}
+	return cfg
+}
+
+func TestAuthentication_Handle(t *testing.T) {
+	keys := newTestKeys(t)
+	cfg := newTestConfig(t, keys)
+	optional := cfg
+	optional.Required = false
+
+	tests := []struct {
+		name        string
+		cfg         Config
+		token       func(t *testing.T) string
+		wantAllowed bool
+		wantSubject string
+	}{
+		{
+			name: "allows tokens signed with RS256",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.RS256, keys.rsa, "rsa", validClaims())
+			},
+			wantAllowed: true,
+			wantSubject: "user-1",
+		},
+		{
+			name: "allows tokens signed with ES256",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.ES256, keys.ec, "ec", validClaims())
+			},
+			wantAllowed: true,
+			wantSubject: "user-1",
+		},
+		{
+			name: "allows tokens signed with HS256",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.HS256, keys.hmac, "hmac", validClaims())
+			},
+			wantAllowed: true,
+			wantSubject: "user-1",
+		},
+		{
+			name: "allows tokens without a key id when a key verifies the signature",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.ES256, keys.ec, "", validClaims())
+			},
+			wantAllowed: true,
+			wantSubject: "user-1",
+		},
+		{
+			name: "rejects requests without a token",
+			cfg:  cfg,
+			token: func(_ *testing.T) string {
+				return ""
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "allows requests without a token when not required",
+			cfg:  optional,
+			token: func(_ *testing.T) string {
+				return ""
+			},
+			wantAllowed: true,
+		},
+		{
+			name: "rejects invalid tokens when not required",
+			cfg:  optional,
+			token: func(_ *testing.T) string {
+				return "not-a-token"
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "rejects tokens signed by another key",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				other := newTestKeys(t)
+				return sign(t, jose.RS256, other.rsa, "rsa", validClaims())
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "rejects tokens signed by an unknown key",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.RS256, keys.rsa, "unknown", validClaims())
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "rejects tokens using an algorithm that isn't allowed",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.RS512, keys.rsa, "rsa", validClaims())
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "rejects tokens using another algorithm than the key",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.HS256, keys.hmac, "rsa", validClaims())
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "rejects expired tokens",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.RS256, keys.rsa, "rsa", with(validClaims(), "exp", time.Now().Add(-time.Hour).Unix()))
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "allows recently expired tokens within the leeway",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.RS256, keys.rsa, "rsa", with(validClaims(), "exp", time.Now().Add(-10*time.Second).Unix()))
+			},
+			wantAllowed: true,
+			wantSubject: "user-1",
+		},
+		{
+			name: "rejects tokens without an expiry",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.RS256, keys.rsa, "rsa", with(validClaims(), "exp", nil))
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "rejects tokens that are not valid yet",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.RS256, keys.rsa, "rsa", with(validClaims(), "nbf", time.Now().Add(time.Hour).Unix()))
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "rejects tokens of another issuer",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.RS256, keys.rsa, "rsa", with(validClaims(), "iss", "https://evil.example.com"))
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "rejects tokens for another audience",
+			cfg:  cfg,
+			token: func(t *testing.T) string {
+				return sign(t, jose.RS256, keys.rsa, "rsa", with(validClaims(), "aud", "billing"))
+			},
+			wantAllowed: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := NewAuthentication(tt.cfg, slog.Default())
+			require.NoError(t, err)
+
+			var claims Claims
+			var authenticated bool
+			handler := auth.Handle(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				claims, authenticated = ClaimsFromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+			if token := tt.token(t); token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if !tt.wantAllowed {
+				assert.Equal(t, http.StatusUnauthorized, w.Code)
+				assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+				assert.JSONEq(t, `{"errors":[{"message":"a valid token is required","extensions":{"code":"UNAUTHENTICATED"}}]}`, w.Body.String())
+				return
+			}
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.wantSubject != "", authenticated)
+			assert.Equal(t, tt.wantSubject, claims.Subject())
+		})
+	}
+}
+
+func TestAuthentication_Disabled(t *testing.T) {
+	auth, err := NewAuthentication(DefaultConfig(), slog.Default())
+	require.NoError(t, err)
+
+	called := false
+	handler := auth.Handle(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		called = true
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/graphql", nil))
+
+	assert.True(t, called)
+}
+
+func TestAuthentication_Header(t *testing.T) {
+	keys := newTestKeys(t)
+	cfg := newTestConfig(t, keys)
+	cfg.Header = "X-Token"
+
+	auth, err := NewAuthentication(cfg, slog.Default())
+	require.NoError(t, err)
+
+	called := false
+	handler := auth.Handle(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		called = true
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	r.Header.Set("X-Token", sign(t, jose.ES256, keys.ec, "ec", validClaims()))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.True(t, called)
+}
+
+func TestAuthentication_Schemes(t *testing.T) {
+	keys := newTestKeys(t)
+	token := sign(t, jose.ES256, keys.ec, "ec", validClaims())
+
+	tests := []struct {
+		name          string
+		header        string
+		value         string
+		required      bool
+		wantAllowed   bool
+		authenticated bool
+	}{
+		{
+			name:          "accepts the bearer scheme in any case",
+			header:        "Authorization",
+			value:         "bearer " + token,
+			required:      true,
+			wantAllowed:   true,
+			authenticated: true,
+		},
+		{
+			name:        "treats other schemes as requests without a token",
+			header:      "Authorization",
+			value:       "Basic dXNlcjpwYXNzd29yZA==",
+			required:    true,
+			wantAllowed: false,
+		},
+		{
+			name:        "forwards requests using other schemes when a token isn't required",
+			header:      "Authorization",
+			value:       "Basic dXNlcjpwYXNzd29yZA==",
+			required:    false,
+			wantAllowed: true,
+		},
+		{
+			name:        "requires a scheme in the authorization header",
+			header:      "Authorization",
+			value:       token,
+			required:    true,
+			wantAllowed: false,
+		},
+		{
+			name:          "accepts the bearer scheme in a custom header",
+			header:        "X-Token",
+			value:         "Bearer " + token,
+			required:      true,
+			wantAllowed:   true,
+			authenticated: true,
+		},
+		{
+			name:        "treats other schemes in a custom header as requests without a token",
+			header:      "X-Token",
+			value:       "Basic dXNlcjpwYXNzd29yZA==",
+			required:    false,
+			wantAllowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t, keys)
+			cfg.Header = tt.header
+			cfg.Required = tt.required
+			auth, err := NewAuthentication(cfg, slog.Default())
+			require.NoError(t, err)
+
+			called, authenticated := false, false
+			handler := auth.Handle(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				called = true
+				_, authenticated = ClaimsFromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+			r.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantAllowed, called)
+			assert.Equal(t, tt.authenticated, authenticated)
+			if !tt.wantAllowed {
+				assert.Equal(t, http.StatusUnauthorized, w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthentication_JWKSURL(t *testing.T) {
+	keys := newTestKeys(t)
+	rotated := newTestKeys(t)
+
+	jwks := keys.jwks()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		_, _ = w.Write(jwks)
+	}))
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	cfg.JWKS.URL = server.URL
+	auth, err := NewAuthentication(cfg, slog.Default())
+	require.NoError(t, err)
+	defer auth.Shutdown()
+
+	_, err = auth.Verify(sign(t, jose.RS256, keys.rsa, "rsa", validClaims()))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests, "the key set is cached")
+
+	// keys are rotated at the identity provider
+	bts, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &rotated.rsa.PublicKey, KeyID: "rotated", Algorithm: string(jose.RS256)},
+	}})
+	jwks = bts
+
+	_, err = auth.Verify(sign(t, jose.RS256, rotated.rsa, "rotated", validClaims()))
+	assert.ErrorIs(t, err, ErrUnknownKey, "unknown keys don't trigger a reload right after loading")
+	assert.Equal(t, 1, requests)
+
+	auth.keys.lastLoad = time.Now().Add(-minUnknownKeyRefresh)
+	_, err = auth.Verify(sign(t, jose.RS256, rotated.rsa, "rotated", validClaims()))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requests)
+}
+
+func TestNewAuthentication_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  func(cfg Config) Config
+		want error
+	}{
+		{
+			name: "without a key set",
+			cfg: func(cfg Config) Config {
+				return cfg
+			},
+			want: ErrInvalidJWKSConfig,
+		},
+		{
+			name: "with both a key set file and url",
+			cfg: func(cfg Config) Config {
+				cfg.JWKS.File = "jwks.json"
+				cfg.JWKS.URL = "https://issuer.example.com/.well-known/jwks.json"
+				return cfg
+			},
+			want: ErrInvalidJWKSConfig,
+		},
+		{
+			name: "unsupported algorithm",
+			cfg: func(cfg Config) Config {
+				cfg.JWKS.File = "jwks.json"
+				cfg.Algorithms = []string{"none"}
+				return cfg
+			},
+			want: ErrUnsupportedAlgorithm,
+		},
+		{
+			name: "refresh interval too short",
+			cfg: func(cfg Config) Config {
+				cfg.JWKS.File = "jwks.json"
+				cfg.JWKS.RefreshInterval = time.Second
+				return cfg
+			},
+			want: ErrInvalidRefresh,
+		},
+		{
+			name: "key set file that doesn't exist",
+			cfg: func(cfg Config) Config {
+				cfg.JWKS.File = filepath.Join(t.TempDir(), "jwks.json")
+				return cfg
+			},
+			want: os.ErrNotExist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Enabled = true
+
+			_, err := NewAuthentication(tt.cfg(cfg), slog.Default())
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestClaims(t *testing.T) {
+	claims := Claims{
+		"sub":   "user-1",
+		"scope": "read write",
+		"roles": []interface{}{"admin", 1, "support"},
+	}
+
+	assert.Equal(t, "user-1", claims.Subject())
+	assert.Equal(t, []string{"read", "write"}, claims.Strings("scope"))
+	assert.Equal(t, []string{"admin", "support"}, claims.Strings("roles"))
+	assert.Nil(t, claims.Strings("missing"))
+	assert.Equal(t, "", claims.String("roles"))
+}
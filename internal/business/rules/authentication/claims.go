@@ -0,0 +1,50 @@
+package authentication
+
+import (
+	"context"
+	"strings"
+)
+
+type claimsKey struct{}
+
+// Claims are the verified claims of the token of a request
+type Claims map[string]interface{}
+
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the verified claims of the request, if it was authenticated
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
+
+// Subject returns the `sub` claim
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// String returns a claim holding a string, or an empty string if it's missing or of another type
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings returns a claim holding an array of strings, or a string separated by spaces such as the `scope` claim
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,168 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var jwksLoadCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "graphql_protect",
+	Subsystem: "authentication",
+	Name:      "jwks_load_count",
+	Help:      "Amount of loads of the JSON Web Key Set, by result",
+},
+	[]string{"result"},
+)
+
+// keySet holds the JSON Web Key Set used to verify tokens, and reloads it periodically
+type keySet struct {
+	cfg    JWKSConfig
+	log    *slog.Logger
+	client *http.Client
+
+	keys        atomic.Pointer[jose.JSONWebKeySet]
+	refreshLock sync.Mutex
+	lastLoad    time.Time
+	done        chan bool
+}
+
+func newKeySet(cfg JWKSConfig, log *slog.Logger) (*keySet, error) {
+	if (cfg.File == "") == (cfg.URL == "") {
+		return nil, ErrInvalidJWKSConfig
+	}
+	if cfg.RefreshInterval > 0 && cfg.RefreshInterval < 10*time.Second {
+		return nil, ErrInvalidRefresh
+	}
+
+	k := &keySet{
+		cfg: cfg,
+		log: log,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+		},
+		done: make(chan bool, 1),
+	}
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	k.start()
+	return k, nil
+}
+
+// find returns the keys matching the key id, or all keys if the token doesn't specify a key id
+func (k *keySet) find(kid string) []jose.JSONWebKey {
+	keys := k.keys.Load()
+	if kid == "" {
+		return keys.Keys
+	}
+	return keys.Key(kid)
+}
+
+func (k *keySet) load() error {
+	contents, err := k.read()
+	if err != nil {
+		jwksLoadCounter.WithLabelValues("failed").Inc()
+		return fmt.Errorf("unable to load jwks: %w", err)
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.Unmarshal(contents, &keys); err != nil {
+		jwksLoadCounter.WithLabelValues("failed").Inc()
+		return fmt.Errorf("unable to parse jwks: %w", err)
+	}
+
+	k.keys.Store(&keys)
+	jwksLoadCounter.WithLabelValues("success").Inc()
+	return nil
+}
+
+func (k *keySet) read() ([]byte, error) {
+	if k.cfg.File != "" {
+		return os.ReadFile(k.cfg.File)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), k.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// minUnknownKeyRefresh limits how often tokens signed by an unknown key trigger a reload
+const minUnknownKeyRefresh = 10 * time.Second
+
+func (k *keySet) reload() bool {
+	k.refreshLock.Lock()
+	defer k.refreshLock.Unlock()
+	return k.reloadLocked()
+}
+
+func (k *keySet) reloadLocked() bool {
+	k.lastLoad = time.Now()
+	if err := k.load(); err != nil {
+		k.log.Warn("Error reloading jwks, continuing with the previous keys", "err", err)
+		return false
+	}
+	return true
+}
+
+// refreshUnknownKey reloads the key set when a token is signed by an unknown key, as the keys may have been rotated.
+// It returns whether the key set was reloaded.
+func (k *keySet) refreshUnknownKey() bool {
+	if k.cfg.RefreshInterval <= 0 {
+		return false
+	}
+
+	k.refreshLock.Lock()
+	defer k.refreshLock.Unlock()
+	if time.Since(k.lastLoad) < minUnknownKeyRefresh {
+		return false
+	}
+	return k.reloadLocked()
+}
+
+func (k *keySet) start() {
+	k.lastLoad = time.Now()
+	if k.cfg.RefreshInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(k.cfg.RefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-k.done:
+				return
+			case <-ticker.C:
+				k.reload()
+			}
+		}
+	}()
+}
+
+func (k *keySet) shutdown() {
+	k.done <- true
+}
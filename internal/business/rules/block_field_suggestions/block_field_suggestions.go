@@ -1,8 +1,13 @@
 package block_field_suggestions // nolint:revive
 
 import (
-	"github.com/prometheus/client_golang/prometheus"
+	"fmt"
+	"regexp"
+	"slices"
 	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
 var resultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
@@ -14,30 +19,55 @@ var resultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
 	[]string{"result"},
 )
 
+// defaultPatterns matches the suggestions of graphql-js and most libraries following its phrasing, such as `Did you mean "hello"?`
+var defaultPatterns = []string{`(?i)did you mean[^?]*\??`}
+
 type Config struct {
 	Enabled bool   `yaml:"enabled"`
 	Mask    string `yaml:"mask"`
+	// Regular expressions matching field suggestions, the default patterns are used when empty
+	Patterns []string `yaml:"patterns"`
+	// Only remove the suggestion from the message instead of replacing the entire message with the mask
+	PartialMask bool `yaml:"partial_mask"`
 }
 
 func DefaultConfig() Config {
 	return Config{
-		Enabled: true,
-		Mask:    "[redacted]",
+		Enabled:     true,
+		Mask:        "[redacted]",
+		Patterns:    slices.Clone(defaultPatterns),
+		PartialMask: false,
 	}
 }
 
 type BlockFieldSuggestionsHandler struct {
-	cfg Config
+	cfg      Config
+	patterns []*regexp.Regexp
 }
 
 func init() {
 	prometheus.MustRegister(resultCounter)
 }
 
-func NewBlockFieldSuggestionsHandler(cfg Config) *BlockFieldSuggestionsHandler {
-	return &BlockFieldSuggestionsHandler{
-		cfg: cfg,
+func NewBlockFieldSuggestionsHandler(cfg Config) (*BlockFieldSuggestionsHandler, error) {
+	patterns := cfg.Patterns
+	if len(patterns) == 0 {
+		patterns = defaultPatterns
 	}
+
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field suggestion pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+
+	return &BlockFieldSuggestionsHandler{
+		cfg:      cfg,
+		patterns: compiled,
+	}, nil
 }
 
 func (b *BlockFieldSuggestionsHandler) Enabled() bool {
@@ -51,6 +81,18 @@ func (b *BlockFieldSuggestionsHandler) ProcessBody(payload map[string]interface{
 	return payload
 }
 
+// MaskError removes field suggestions from an error produced by GraphQL Protect itself
+func (b *BlockFieldSuggestionsHandler) MaskError(err *gqlerror.Error) {
+	message, masked := b.replaceSuggestions(err.Message)
+	err.Message = message
+	for key, value := range err.Extensions {
+		var found bool
+		err.Extensions[key], found = b.replaceValue(value)
+		masked = masked || found
+	}
+	countResult(masked)
+}
+
 func (b *BlockFieldSuggestionsHandler) processErrors(payload interface{}) interface{} {
 	switch payload := payload.(type) {
 	case []map[string]interface{}:
@@ -70,19 +112,65 @@ func (b *BlockFieldSuggestionsHandler) processErrors(payload interface{}) interf
 }
 
 func (b *BlockFieldSuggestionsHandler) processError(err map[string]interface{}) map[string]interface{} {
+	var masked bool
 	if msg, ok4 := err["message"]; ok4 {
 		if message, ok := msg.(string); ok {
-			err["message"] = b.replaceSuggestions(message)
+			err["message"], masked = b.replaceSuggestions(message)
 		}
 	}
+	if extensions, ok := err["extensions"]; ok {
+		var found bool
+		err["extensions"], found = b.replaceValue(extensions)
+		masked = masked || found
+	}
+	countResult(masked)
 	return err
 }
 
-func (b *BlockFieldSuggestionsHandler) replaceSuggestions(message string) string {
-	if strings.Contains(message, "Did you mean") {
+// replaceValue replaces suggestions in all strings of a value, including those nested in objects and arrays
+func (b *BlockFieldSuggestionsHandler) replaceValue(value interface{}) (interface{}, bool) {
+	var masked bool
+	switch v := value.(type) {
+	case string:
+		return b.replaceSuggestions(v)
+	case map[string]interface{}:
+		for key, child := range v {
+			var found bool
+			v[key], found = b.replaceValue(child)
+			masked = masked || found
+		}
+	case []interface{}:
+		for i, child := range v {
+			var found bool
+			v[i], found = b.replaceValue(child)
+			masked = masked || found
+		}
+	}
+	return value, masked
+}
+
+func (b *BlockFieldSuggestionsHandler) replaceSuggestions(message string) (string, bool) {
+	masked := false
+	for _, pattern := range b.patterns {
+		if !pattern.MatchString(message) {
+			continue
+		}
+		if !b.cfg.PartialMask {
+			return b.cfg.Mask, true
+		}
+		message = pattern.ReplaceAllString(message, "")
+		masked = true
+	}
+	if masked {
+		message = strings.TrimSpace(message)
+	}
+	return message, masked
+}
+
+func countResult(masked bool) {
+	if masked {
 		resultCounter.WithLabelValues("masked").Inc()
-		return b.cfg.Mask
+		return
 	}
 	resultCounter.WithLabelValues("unmasked").Inc()
-	return message
 }
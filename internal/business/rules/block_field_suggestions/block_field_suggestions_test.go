@@ -3,6 +3,10 @@ package block_field_suggestions // nolint:revive
 import (
 	"reflect"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
 func TestProcessBody(t *testing.T) {
@@ -139,10 +143,11 @@ func TestProcessBody(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			b := NewBlockFieldSuggestionsHandler(Config{
+			b, err := NewBlockFieldSuggestionsHandler(Config{
 				Enabled: true,
 				Mask:    "[redacted]",
 			})
+			require.NoError(t, err)
 
 			if got := b.ProcessBody(tt.args.payload); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("ProcessBody() = %v, want %v", got, tt.want)
@@ -150,3 +155,105 @@ func TestProcessBody(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessBody_PatternsAndExtensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		payload map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name: "masks messages matching configured patterns",
+			cfg: Config{
+				Mask:     "[redacted]",
+				Patterns: []string{`Perhaps you meant [^.]*\.`},
+			},
+			payload: map[string]interface{}{
+				"errors": []interface{}{
+					map[string]interface{}{"message": "Unknown field 'emial' on type 'User'. Perhaps you meant 'email'."},
+				},
+			},
+			want: map[string]interface{}{
+				"errors": []interface{}{
+					map[string]interface{}{"message": "[redacted]"},
+				},
+			},
+		},
+		{
+			name: "partially masks only the suggestion",
+			cfg: Config{
+				Mask:        "[redacted]",
+				PartialMask: true,
+			},
+			payload: map[string]interface{}{
+				"errors": []interface{}{
+					map[string]interface{}{"message": `Cannot query field "hell" on type "Query". Did you mean "hello" or "help"?`},
+				},
+			},
+			want: map[string]interface{}{
+				"errors": []interface{}{
+					map[string]interface{}{"message": `Cannot query field "hell" on type "Query".`},
+				},
+			},
+		},
+		{
+			name: "masks suggestions in nested extensions",
+			cfg: Config{
+				Mask: "[redacted]",
+			},
+			payload: map[string]interface{}{
+				"errors": []interface{}{
+					map[string]interface{}{
+						"message": "Validation error",
+						"extensions": map[string]interface{}{
+							"classification": "ValidationError",
+							"details": []interface{}{
+								map[string]interface{}{"description": "Did you mean 'email'?"},
+							},
+						},
+					},
+				},
+			},
+			want: map[string]interface{}{
+				"errors": []interface{}{
+					map[string]interface{}{
+						"message": "Validation error",
+						"extensions": map[string]interface{}{
+							"classification": "ValidationError",
+							"details": []interface{}{
+								map[string]interface{}{"description": "[redacted]"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewBlockFieldSuggestionsHandler(tt.cfg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, b.ProcessBody(tt.payload))
+		})
+	}
+}
+
+func TestMaskError(t *testing.T) {
+	b, err := NewBlockFieldSuggestionsHandler(Config{Mask: "[redacted]", PartialMask: true})
+	require.NoError(t, err)
+
+	gqlErr := &gqlerror.Error{
+		Message:    `Unknown argument "fist" on field "Query.users". Did you mean "first"?`,
+		Extensions: map[string]interface{}{"hint": `Did you mean "first"?`},
+	}
+	b.MaskError(gqlErr)
+
+	assert.Equal(t, `Unknown argument "fist" on field "Query.users".`, gqlErr.Message)
+	assert.Equal(t, map[string]interface{}{"hint": ""}, gqlErr.Extensions)
+}
+
+func TestNewBlockFieldSuggestionsHandler_InvalidPattern(t *testing.T) {
+	_, err := NewBlockFieldSuggestionsHandler(Config{Patterns: []string{"did you mean ("}})
+	assert.Error(t, err)
+}
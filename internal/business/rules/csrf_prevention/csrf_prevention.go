@@ -0,0 +1,92 @@
+package csrf_prevention // nolint:revive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+var blockedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "graphql_protect",
+	Subsystem: "csrf_prevention",
+	Name:      "count",
+	Help:      "Amount of times the CSRF prevention rule was triggered and blocked a request",
+},
+	[]string{},
+)
+
+var ErrPotentialCSRF = errors.New("this operation has been blocked as a potential Cross-Site Request Forgery (CSRF)")
+
+func init() {
+	prometheus.MustRegister(blockedCounter)
+}
+
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Headers of which any one marks a request as preflighted, as browsers only send custom headers cross-origin after a preflight request
+	RequiredHeaders []string `yaml:"required_headers"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:         false,
+		RequiredHeaders: []string{"X-Apollo-Operation-Name", "Apollo-Require-Preflight"},
+	}
+}
+
+// CSRFPrevention rejects requests that browsers could have sent cross-origin without a preflight request.
+// Requests are considered preflighted when they have a Content-Type that isn't a simple media type, or one of the required headers.
+func CSRFPrevention(cfg Config) func(next http.Handler) http.Handler {
+	err := fmt.Errorf("%w. Specify a Content-Type header that is not one of application/x-www-form-urlencoded, multipart/form-data or text/plain, "+
+		"or provide a non-empty value for one of the following headers: %s", ErrPotentialCSRF, strings.Join(cfg.RequiredHeaders, ", "))
+	res, _ := json.Marshal(map[string]interface{}{
+		"errors": gqlerror.List{gqlerror.Wrap(err)},
+	})
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !cfg.Enabled || !hasOperation(r) || preflighted(cfg, r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			blockedCounter.WithLabelValues().Inc()
+			w.Header().Set("Content-Type", gql.ResponseMediaType(r))
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write(res)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// hasOperation returns whether the request could contain an operation, GET requests without an operation are allowed for i.e. GraphiQL access
+func hasOperation(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+	query := r.URL.Query()
+	return query.Has("query") || query.Has("extensions")
+}
+
+func preflighted(cfg Config, r *http.Request) bool {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err == nil && !gql.IsSimpleMediaType(mediaType) {
+			return true
+		}
+	}
+
+	for _, header := range cfg.RequiredHeaders {
+		if r.Header.Get(header) != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package csrf_prevention // nolint:revive
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRFPrevention(t *testing.T) {
+	enabled := DefaultConfig()
+	enabled.Enabled = true
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		request func() *http.Request
+		blocked bool
+	}{
+		{
+			name: "does not block when disabled",
+			cfg:  DefaultConfig(),
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/graphql", strings.NewReader("query=%7B+foo+%7D"))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return r
+			},
+			blocked: false,
+		},
+		{
+			name: "does not block requests with a content type that requires a preflight",
+			cfg:  enabled,
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ foo }"}`))
+				r.Header.Set("Content-Type", "application/json; charset=utf-8")
+				return r
+			},
+			blocked: false,
+		},
+		{
+			name: "blocks requests with a simple content type",
+			cfg:  enabled,
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ foo }"}`))
+				r.Header.Set("Content-Type", "text/plain")
+				return r
+			},
+			blocked: true,
+		},
+		{
+			name: "blocks requests without a content type",
+			cfg:  enabled,
+			request: func() *http.Request {
+				return httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ foo }"}`))
+			},
+			blocked: true,
+		},
+		{
+			name: "does not block requests with a simple content type and a required header",
+			cfg:  enabled,
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/graphql", strings.NewReader("--boundary--"))
+				r.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+				r.Header.Set("Apollo-Require-Preflight", "true")
+				return r
+			},
+			blocked: false,
+		},
+		{
+			name: "blocks GETs that contain an operation",
+			cfg:  enabled,
+			request: func() *http.Request {
+				return httptest.NewRequest("GET", "/graphql?query=%7B+foo+%7D", nil)
+			},
+			blocked: true,
+		},
+		{
+			name: "does not block GETs that contain an operation and a required header",
+			cfg:  enabled,
+			request: func() *http.Request {
+				r := httptest.NewRequest("GET", "/graphql?query=%7B+foo+%7D", nil)
+				r.Header.Set("X-Apollo-Operation-Name", "Foo")
+				return r
+			},
+			blocked: false,
+		},
+		{
+			name: "does not block GETs that contain no operation (for i.e. graphiql access)",
+			cfg:  enabled,
+			request: func() *http.Request {
+				return httptest.NewRequest("GET", "/graphql", nil)
+			},
+			blocked: false,
+		},
+		{
+			name: "uses the configured required headers",
+			cfg:  Config{Enabled: true, RequiredHeaders: []string{"X-Requested-With"}},
+			request: func() *http.Request {
+				r := httptest.NewRequest("GET", "/graphql?query=%7B+foo+%7D", nil)
+				r.Header.Set("Apollo-Require-Preflight", "true")
+				return r
+			},
+			blocked: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := CSRFPrevention(tt.cfg)(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+				called = true
+			}))
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, tt.request())
+
+			assert.Equal(t, !tt.blocked, called)
+			if tt.blocked {
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				assert.Contains(t, w.Body.String(), "Cross-Site Request Forgery")
+			}
+		})
+	}
+}
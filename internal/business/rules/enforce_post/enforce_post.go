@@ -1,8 +1,13 @@
 package enforce_post // nolint:revive
 
 import (
-	"github.com/prometheus/client_golang/prometheus"
+	"encoding/json"
+	"errors"
 	"net/http"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
 var methodCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
@@ -14,6 +19,8 @@ var methodCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
 	[]string{},
 )
 
+var ErrMethodNotAllowed = errors.New("operations must be sent using a POST request")
+
 func init() {
 	prometheus.MustRegister(methodCounter)
 }
@@ -29,6 +36,10 @@ func DefaultConfig() Config {
 }
 
 func EnforcePostMethod(cfg Config) func(next http.Handler) http.Handler {
+	res, _ := json.Marshal(map[string]interface{}{
+		"errors": gqlerror.List{gqlerror.Wrap(ErrMethodNotAllowed)},
+	})
+
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			if !cfg.Enabled {
@@ -40,7 +51,10 @@ func EnforcePostMethod(cfg Config) func(next http.Handler) http.Handler {
 
 			if r.Method != "POST" && (query.Has("query") || query.Has("extensions")) {
 				methodCounter.WithLabelValues().Inc()
-				http.Error(w, "405 - method not allowed", http.StatusMethodNotAllowed)
+				w.Header().Set("Allow", http.MethodPost)
+				w.Header().Set("Content-Type", gql.ResponseMediaType(r))
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				_, _ = w.Write(res)
 				return
 			}
 
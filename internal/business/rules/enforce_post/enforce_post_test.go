@@ -1,10 +1,13 @@
 package enforce_post // nolint:revive
 
 import (
-	"github.com/stretchr/testify/assert"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDisableMethodRule(t *testing.T) {
@@ -57,6 +60,27 @@ func TestDisableMethodRule(t *testing.T) {
 			},
 			want: func(res *http.Response) {
 				assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+				assert.Equal(t, http.MethodPost, res.Header.Get("Allow"))
+				assert.Equal(t, gql.MediaTypeJSON, res.Header.Get("Content-Type"))
+				body, _ := io.ReadAll(res.Body)
+				assert.JSONEq(t, `{"errors":[{"message":"operations must be sent using a POST request"}]}`, string(body))
+			},
+		},
+		{
+			name: "responds with the negotiated media type",
+			args: args{
+				cfg: Config{
+					Enabled: true,
+				},
+				request: func() *http.Request {
+					r := httptest.NewRequest("GET", "/graphql?query=foobar", nil)
+					info := &gql.RequestInfo{ResponseMediaType: gql.MediaTypeGraphQLResponse}
+					return r.WithContext(gql.WithRequestInfo(r.Context(), info))
+				}(),
+			},
+			want: func(res *http.Response) {
+				assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+				assert.Equal(t, gql.MediaTypeGraphQLResponse, res.Header.Get("Content-Type"))
 			},
 		},
 		{
@@ -0,0 +1,332 @@
+package field_masking // nolint:revive
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/authentication"
+	"github.com/ldebruijn/graphql-protect/internal/business/schema"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+var resultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "graphql_protect",
+	Subsystem: "field_masking",
+	Name:      "results",
+	Help:      "The results of the field masking rule",
+},
+	[]string{"field", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(resultCounter)
+}
+
+const (
+	ActionNull   = "null"
+	ActionRemove = "remove"
+)
+
+var (
+	ErrInvalidField  = errors.New("field must be in the form Type.field")
+	ErrInvalidAction = errors.New("action must be one of null or remove")
+)
+
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Request header holding the roles or scopes of the client, separated by commas or spaces
+	RolesHeader string `yaml:"roles_header"`
+	// Claim of the verified token holding the roles or scopes of the client, as an array or separated by spaces.
+	// Takes precedence over the roles header, which clients can set themselves. Requires authentication to be enabled
+	RolesClaim string       `yaml:"roles_claim"`
+	Rules      []RuleConfig `yaml:"rules"`
+}
+
+type RuleConfig struct {
+	// Field to mask, in the form Type.field
+	Field string `yaml:"field"`
+	// Clients with any of these roles or scopes may see the field
+	Roles []string `yaml:"roles"`
+	// What to do with the field for other clients, null or remove
+	Action string `yaml:"action"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:     false,
+		RolesHeader: "X-Roles",
+		RolesClaim:  "",
+		Rules:       []RuleConfig{},
+	}
+}
+
+type rule struct {
+	field  string
+	roles  []string
+	remove bool
+}
+
+// FieldMasking nulls or removes fields from responses for clients that lack the role or scope to see them
+type FieldMasking struct {
+	cfg    Config
+	schema *schema.Provider
+	// rules by type name and field name
+	rules map[string]map[string]*rule
+}
+
+func NewFieldMasking(cfg Config, schema *schema.Provider) (*FieldMasking, error) {
+	rules := map[string]map[string]*rule{}
+	for _, ruleCfg := range cfg.Rules {
+		typeName, fieldName, ok := strings.Cut(ruleCfg.Field, ".")
+		if !ok || typeName == "" || fieldName == "" {
+			return nil, fmt.Errorf("%w, got: %s", ErrInvalidField, ruleCfg.Field)
+		}
+
+		switch ruleCfg.Action {
+		case "", ActionNull, ActionRemove:
+		default:
+			return nil, fmt.Errorf("%w, got: %s", ErrInvalidAction, ruleCfg.Action)
+		}
+
+		if rules[typeName] == nil {
+			rules[typeName] = map[string]*rule{}
+		}
+		rules[typeName][fieldName] = &rule{
+			field:  ruleCfg.Field,
+			roles:  ruleCfg.Roles,
+			remove: ruleCfg.Action == ActionRemove,
+		}
+	}
+
+	return &FieldMasking{
+		cfg:    cfg,
+		schema: schema,
+		rules:  rules,
+	}, nil
+}
+
+func (f *FieldMasking) Enabled() bool {
+	return f.cfg.Enabled
+}
+
+// ProcessBody masks the fields of a response to the operation the client lacks the roles for.
+// If the operation is unknown, it's impossible to tell which fields are selected, so all data is removed.
+func (f *FieldMasking) ProcessBody(r *http.Request, operation *gql.Operation, payload map[string]interface{}) map[string]interface{} {
+	masked := f.maskedRules(f.roles(r))
+	if len(masked) == 0 {
+		return payload
+	}
+
+	if operation == nil || operation.Definition == nil {
+		if payload["data"] != nil {
+			resultCounter.WithLabelValues("", "unknown_operation").Inc()
+			payload["data"] = nil
+		}
+		return payload
+	}
+
+	f.mask(payload["data"], operation.Definition.SelectionSet, masked)
+	return payload
+}
+
+// maskedRules returns the rules applying to a client with the given roles, by type name and field name
+func (f *FieldMasking) maskedRules(roles map[string]bool) map[string]map[string]*rule {
+	masked := map[string]map[string]*rule{}
+	for typeName, fields := range f.rules {
+		for fieldName, fieldRule := range fields {
+			if fieldRule.allows(roles) {
+				continue
+			}
+			if masked[typeName] == nil {
+				masked[typeName] = map[string]*rule{}
+			}
+			masked[typeName][fieldName] = fieldRule
+		}
+	}
+	return masked
+}
+
+func (f *FieldMasking) mask(data interface{}, selections ast.SelectionSet, masked map[string]map[string]*rule) {
+	switch value := data.(type) {
+	case []interface{}:
+		for _, item := range value {
+			f.mask(item, selections, masked)
+		}
+	case map[string]interface{}:
+		f.maskObject(value, selections, typename(value, selections), masked)
+	}
+}
+
+func (f *FieldMasking) maskObject(object map[string]interface{}, selections ast.SelectionSet, typename string, masked map[string]map[string]*rule) {
+	for _, selection := range selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			f.maskField(object, s, typename, masked)
+		case *ast.InlineFragment:
+			f.maskObject(object, s.SelectionSet, typename, masked)
+		case *ast.FragmentSpread:
+			if s.Definition != nil {
+				f.maskObject(object, s.Definition.SelectionSet, typename, masked)
+			}
+		}
+	}
+}
+
+func (f *FieldMasking) maskField(object map[string]interface{}, field *ast.Field, typename string, masked map[string]map[string]*rule) {
+	key := field.Alias
+	if key == "" {
+		key = field.Name
+	}
+
+	child, ok := object[key]
+	if !ok {
+		return
+	}
+
+	rule := f.ruleFor(typename, field, masked)
+	if rule == nil {
+		f.mask(child, field.SelectionSet, masked)
+		return
+	}
+
+	resultCounter.WithLabelValues(rule.field, "masked").Inc()
+	if rule.remove {
+		delete(object, key)
+		return
+	}
+	object[key] = nil
+}
+
+// typename returns the concrete type of the object, when the client selected `__typename`.
+// The value can't be trusted when the client aliased another field to `__typename`, in which case it's ignored.
+func typename(object map[string]interface{}, selections ast.SelectionSet) string {
+	if selected, spoofed := typenameSelection(selections); !selected || spoofed {
+		return ""
+	}
+	typename, _ := object["__typename"].(string)
+	return typename
+}
+
+// typenameSelection returns whether `__typename` is selected, and whether another field is aliased to `__typename`
+func typenameSelection(selections ast.SelectionSet) (selected bool, spoofed bool) {
+	for _, selection := range selections {
+		var fragment ast.SelectionSet
+		switch s := selection.(type) {
+		case *ast.Field:
+			key := s.Alias
+			if key == "" {
+				key = s.Name
+			}
+			if key == "__typename" {
+				selected = selected || s.Name == "__typename"
+				spoofed = spoofed || s.Name != "__typename"
+			}
+			continue
+		case *ast.InlineFragment:
+			fragment = s.SelectionSet
+		case *ast.FragmentSpread:
+			if s.Definition == nil {
+				continue
+			}
+			fragment = s.Definition.SelectionSet
+		}
+		fragmentSelected, fragmentSpoofed := typenameSelection(fragment)
+		selected = selected || fragmentSelected
+		spoofed = spoofed || fragmentSpoofed
+	}
+	return selected, spoofed
+}
+
+// ruleFor finds the rule masking the field. For fields of interfaces and unions the concrete type of the object is used when the client selected `__typename`,
+// otherwise the field is masked if any of the possible types has a rule for it.
+func (f *FieldMasking) ruleFor(typename string, field *ast.Field, masked map[string]map[string]*rule) *rule {
+	if field.ObjectDefinition == nil {
+		return nil
+	}
+
+	if rule := masked[field.ObjectDefinition.Name][field.Name]; rule != nil {
+		return rule
+	}
+
+	if !field.ObjectDefinition.IsAbstractType() {
+		return nil
+	}
+
+	if typename != "" {
+		return masked[typename][field.Name]
+	}
+
+	if f.schema == nil || f.schema.Get() == nil {
+		return nil
+	}
+	for _, possibleType := range f.schema.Get().GetPossibleTypes(field.ObjectDefinition) {
+		if rule := masked[possibleType.Name][field.Name]; rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (r *rule) allows(roles map[string]bool) bool {
+	for _, role := range r.roles {
+		if roles[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// VaryHeaders returns the request headers the roles of clients are read from, which masked responses differ by.
+// When the roles are read from a claim, this is the header holding the token.
+func (f *FieldMasking) VaryHeaders(auth authentication.Config) []string {
+	switch {
+	case !f.cfg.Enabled:
+		return nil
+	case f.cfg.RolesClaim == "":
+		return []string{f.cfg.RolesHeader}
+	case auth.Enabled:
+		return []string{auth.Header}
+	default:
+		// without authentication no client has any roles
+		return nil
+	}
+}
+
+// roles returns the roles of the client from the verified claims if a roles claim is configured, or from the roles header otherwise
+func (f *FieldMasking) roles(r *http.Request) map[string]bool {
+	if f.cfg.RolesClaim == "" {
+		return roles(r, f.cfg.RolesHeader)
+	}
+
+	roles := map[string]bool{}
+	if r == nil {
+		return roles
+	}
+	// unauthenticated clients have no roles
+	if claims, ok := authentication.ClaimsFromContext(r.Context()); ok {
+		for _, role := range claims.Strings(f.cfg.RolesClaim) {
+			roles[role] = true
+		}
+	}
+	return roles
+}
+
+func roles(r *http.Request, header string) map[string]bool {
+	roles := map[string]bool{}
+	if r == nil || header == "" {
+		return roles
+	}
+
+	for _, value := range r.Header.Values(header) {
+		for _, role := range strings.FieldsFunc(value, func(c rune) bool {
+			return c == ',' || c == ' '
+		}) {
+			roles[role] = true
+		}
+	}
+	return roles
+}
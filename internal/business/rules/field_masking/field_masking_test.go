@@ -0,0 +1,325 @@
+package field_masking // nolint:revive
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/authentication"
+	"github.com/ldebruijn/graphql-protect/internal/business/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+)
+
+const testSchema = `
+type Query {
+	user(id: ID!): User
+	users: [User]
+	node(id: ID!): Node
+}
+
+interface Node {
+	id: ID!
+	email: String
+}
+
+type User implements Node {
+	id: ID!
+	name: String
+	email: String
+	salary: Int
+}
+
+type Product implements Node {
+	id: ID!
+	email: String
+}
+`
+
+func newSchemaProvider(t *testing.T) *schema.Provider {
+	path := filepath.Join(t.TempDir(), "schema.graphql")
+	require.NoError(t, os.WriteFile(path, []byte(testSchema), 0o600))
+
+	cfg := schema.DefaultConfig()
+	cfg.Path = path
+	cfg.AutoReload.Enabled = false
+
+	provider, err := schema.NewSchema(cfg, slog.Default())
+	require.NoError(t, err)
+	return provider
+}
+
+func TestFieldMasking_ProcessBody(t *testing.T) {
+	provider := newSchemaProvider(t)
+
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	cfg.Rules = []RuleConfig{
+		{Field: "User.email", Roles: []string{"admin", "support"}},
+		{Field: "User.salary", Roles: []string{"admin"}, Action: ActionRemove},
+	}
+	masking, err := NewFieldMasking(cfg, provider)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		query    string
+		roles    string
+		response string
+		want     string
+	}{
+		{
+			name:     "nulls fields the client lacks the role for",
+			query:    `{ user(id: 1) { name email } }`,
+			response: `{"data":{"user":{"name":"foo","email":"foo@example.com"}}}`,
+			want:     `{"data":{"user":{"name":"foo","email":null}}}`,
+		},
+		{
+			name:     "removes fields when configured",
+			query:    `{ user(id: 1) { name salary } }`,
+			response: `{"data":{"user":{"name":"foo","salary":100}}}`,
+			want:     `{"data":{"user":{"name":"foo"}}}`,
+		},
+		{
+			name:     "leaves fields alone for clients with the role",
+			query:    `{ user(id: 1) { name email salary } }`,
+			roles:    "support, admin",
+			response: `{"data":{"user":{"name":"foo","email":"foo@example.com","salary":100}}}`,
+			want:     `{"data":{"user":{"name":"foo","email":"foo@example.com","salary":100}}}`,
+		},
+		{
+			name:     "masks only the fields the client lacks the role for",
+			query:    `{ user(id: 1) { email salary } }`,
+			roles:    "support",
+			response: `{"data":{"user":{"email":"foo@example.com","salary":100}}}`,
+			want:     `{"data":{"user":{"email":"foo@example.com"}}}`,
+		},
+		{
+			name:     "masks aliased fields in lists",
+			query:    `{ users { contact: email } }`,
+			response: `{"data":{"users":[{"contact":"foo@example.com"},{"contact":"bar@example.com"}]}}`,
+			want:     `{"data":{"users":[{"contact":null},{"contact":null}]}}`,
+		},
+		{
+			name:     "masks fields selected in fragments",
+			query:    `{ user(id: 1) { ...UserFields } } fragment UserFields on User { ... on User { email } }`,
+			response: `{"data":{"user":{"email":"foo@example.com"}}}`,
+			want:     `{"data":{"user":{"email":null}}}`,
+		},
+		{
+			name:     "uses the concrete type of interface fields",
+			query:    `{ a: node(id: 1) { __typename email } b: node(id: 2) { __typename email } }`,
+			response: `{"data":{"a":{"__typename":"User","email":"foo@example.com"},"b":{"__typename":"Product","email":"sales@example.com"}}}`,
+			want:     `{"data":{"a":{"__typename":"User","email":null},"b":{"__typename":"Product","email":"sales@example.com"}}}`,
+		},
+		{
+			name:     "uses the typename selected outside of fragments",
+			query:    `{ node(id: 1) { __typename ... on Node { email } } }`,
+			response: `{"data":{"node":{"__typename":"User","email":"foo@example.com"}}}`,
+			want:     `{"data":{"node":{"__typename":"User","email":null}}}`,
+		},
+		{
+			name:     "ignores the typename when another field is aliased to it",
+			query:    `{ node(id: 1) { __typename: id email } }`,
+			response: `{"data":{"node":{"__typename":"1","email":"foo@example.com"}}}`,
+			want:     `{"data":{"node":{"__typename":"1","email":null}}}`,
+		},
+		{
+			name:     "ignores the typename when another field is aliased to it in a fragment",
+			query:    `{ node(id: 1) { ... on Product { __typename: id } email } }`,
+			response: `{"data":{"node":{"__typename":"Product","email":"foo@example.com"}}}`,
+			want:     `{"data":{"node":{"__typename":"Product","email":null}}}`,
+		},
+		{
+			name:     "masks interface fields any possible type has a rule for without a typename",
+			query:    `{ node(id: 1) { email } }`,
+			response: `{"data":{"node":{"email":"foo@example.com"}}}`,
+			want:     `{"data":{"node":{"email":null}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, errs := gqlparser.LoadQuery(provider.Get(), tt.query)
+			require.Empty(t, errs)
+			operation, ok := gql.NewOperation(doc, "")
+			require.True(t, ok)
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+			if tt.roles != "" {
+				r.Header.Set("X-Roles", tt.roles)
+			}
+
+			var payload map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(tt.response), &payload))
+
+			got, err := json.Marshal(masking.ProcessBody(r, &operation, payload))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestFieldMasking_UnknownOperation(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	cfg.Rules = []RuleConfig{{Field: "User.email", Roles: []string{"admin"}}}
+	masking, err := NewFieldMasking(cfg, nil)
+	require.NoError(t, err)
+
+	payload := map[string]interface{}{
+		"data":   map[string]interface{}{"user": map[string]interface{}{"email": "foo@example.com"}},
+		"errors": []interface{}{map[string]interface{}{"message": "partial failure"}},
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	got := masking.ProcessBody(r, nil, payload)
+	assert.Nil(t, got["data"])
+	assert.NotNil(t, got["errors"])
+
+	// clients allowed to see every masked field don't depend on the operation
+	r.Header.Set("X-Roles", "admin")
+	payload["data"] = map[string]interface{}{"user": map[string]interface{}{"email": "foo@example.com"}}
+	got = masking.ProcessBody(r, nil, payload)
+	assert.NotNil(t, got["data"])
+}
+
+func TestFieldMasking_RolesClaim(t *testing.T) {
+	provider := newSchemaProvider(t)
+
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	cfg.RolesClaim = "roles"
+	cfg.Rules = []RuleConfig{{Field: "User.email", Roles: []string{"admin"}}}
+	masking, err := NewFieldMasking(cfg, provider)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		claims authentication.Claims
+		header string
+		want   string
+	}{
+		{
+			name:   "uses the roles of the verified claims",
+			claims: authentication.Claims{"roles": []interface{}{"support", "admin"}},
+			want:   `{"data":{"user":{"email":"foo@example.com"}}}`,
+		},
+		{
+			name:   "masks fields the claims lack the role for",
+			claims: authentication.Claims{"roles": "support"},
+			want:   `{"data":{"user":{"email":null}}}`,
+		},
+		{
+			name:   "ignores the roles header",
+			header: "admin",
+			want:   `{"data":{"user":{"email":null}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, errs := gqlparser.LoadQuery(provider.Get(), `{ user(id: 1) { email } }`)
+			require.Empty(t, errs)
+			operation, ok := gql.NewOperation(doc, "")
+			require.True(t, ok)
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+			r.Header.Set("X-Roles", tt.header)
+			if tt.claims != nil {
+				r = r.WithContext(authentication.WithClaims(r.Context(), tt.claims))
+			}
+
+			var payload map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(`{"data":{"user":{"email":"foo@example.com"}}}`), &payload))
+
+			got, err := json.Marshal(masking.ProcessBody(r, &operation, payload))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestFieldMasking_VaryHeaders(t *testing.T) {
+	auth := authentication.DefaultConfig()
+	auth.Enabled = true
+	auth.Header = "X-Token"
+
+	tests := []struct {
+		name string
+		cfg  func(cfg *Config)
+		auth authentication.Config
+		want []string
+	}{
+		{
+			name: "nothing when disabled",
+			cfg: func(cfg *Config) {
+				cfg.Enabled = false
+			},
+			auth: auth,
+			want: nil,
+		},
+		{
+			name: "the roles header",
+			cfg:  func(_ *Config) {},
+			auth: auth,
+			want: []string{"X-Roles"},
+		},
+		{
+			name: "the header of the token when using a claim",
+			cfg: func(cfg *Config) {
+				cfg.RolesClaim = "roles"
+			},
+			auth: auth,
+			want: []string{"X-Token"},
+		},
+		{
+			name: "nothing when using a claim without authentication",
+			cfg: func(cfg *Config) {
+				cfg.RolesClaim = "roles"
+			},
+			auth: authentication.DefaultConfig(),
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Enabled = true
+			tt.cfg(&cfg)
+			masking, err := NewFieldMasking(cfg, nil)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, masking.VaryHeaders(tt.auth))
+		})
+	}
+}
+
+func TestNewFieldMasking_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RuleConfig
+		want error
+	}{
+		{
+			name: "field without a type",
+			rule: RuleConfig{Field: "email"},
+			want: ErrInvalidField,
+		},
+		{
+			name: "unknown action",
+			rule: RuleConfig{Field: "User.email", Action: "hash"},
+			want: ErrInvalidAction,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFieldMasking(Config{Rules: []RuleConfig{tt.rule}}, nil)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
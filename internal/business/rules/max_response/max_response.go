@@ -0,0 +1,154 @@
+package max_response // nolint:revive
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var resultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "graphql_protect",
+	Subsystem: "max_response",
+	Name:      "results",
+	Help:      "The results of the max response rule",
+},
+	[]string{"type", "result", "operationName"},
+)
+
+func init() {
+	prometheus.MustRegister(resultCounter)
+}
+
+var (
+	ErrMaxBytesExceeded = errors.New("response size limit exceeded")
+	ErrMaxDepthExceeded = errors.New("response depth limit exceeded")
+)
+
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Maximum size of the upstream response body in bytes. 0 disables the limit.
+	MaxBytes int `yaml:"max_bytes"`
+	// Maximum nesting depth of objects and arrays in the upstream response. 0 disables the limit.
+	MaxDepth int `yaml:"max_depth"`
+	// Whether or not to include the operation name in the metrics.
+	// Be careful with enabling this! There's a risk of unbounded metric cardinality as the client provides this information
+	MetricsIncludeOperationName bool `yaml:"metrics_include_operation_name"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:                     false,
+		MaxBytes:                    10_485_760, // 10mb
+		MaxDepth:                    100,
+		MetricsIncludeOperationName: false,
+	}
+}
+
+type MaxResponseRule struct {
+	cfg Config
+}
+
+func NewMaxResponseRule(cfg Config) *MaxResponseRule {
+	return &MaxResponseRule{
+		cfg: cfg,
+	}
+}
+
+func (m *MaxResponseRule) Enabled() bool {
+	return m.cfg.Enabled
+}
+
+// ReadBody reads the upstream response body while enforcing the configured limits.
+// Reading stops as soon as the size limit is exceeded, so excessively large responses are never fully buffered.
+func (m *MaxResponseRule) ReadBody(res *http.Response) ([]byte, error) {
+	if !m.cfg.Enabled {
+		return io.ReadAll(res.Body)
+	}
+
+	operationName := m.operationName(res.Request)
+
+	if m.cfg.MaxBytes > 0 {
+		if res.ContentLength > int64(m.cfg.MaxBytes) {
+			resultCounter.WithLabelValues("bytes", "rejected", operationName).Inc()
+			return nil, ErrMaxBytesExceeded
+		}
+
+		body, err := io.ReadAll(io.LimitReader(res.Body, int64(m.cfg.MaxBytes)+1))
+		if err != nil {
+			return body, err
+		}
+		if len(body) > m.cfg.MaxBytes {
+			resultCounter.WithLabelValues("bytes", "rejected", operationName).Inc()
+			return nil, ErrMaxBytesExceeded
+		}
+		resultCounter.WithLabelValues("bytes", "allowed", operationName).Inc()
+		return body, m.validateDepth(body, operationName)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return body, err
+	}
+	return body, m.validateDepth(body, operationName)
+}
+
+func (m *MaxResponseRule) validateDepth(body []byte, operationName string) error {
+	if m.cfg.MaxDepth <= 0 {
+		return nil
+	}
+
+	if depth(body) > m.cfg.MaxDepth {
+		resultCounter.WithLabelValues("depth", "rejected", operationName).Inc()
+		return ErrMaxDepthExceeded
+	}
+	resultCounter.WithLabelValues("depth", "allowed", operationName).Inc()
+	return nil
+}
+
+func (m *MaxResponseRule) operationName(r *http.Request) string {
+	if !m.cfg.MetricsIncludeOperationName || r == nil {
+		return ""
+	}
+	info := gql.RequestInfoFromContext(r.Context())
+	if info == nil || len(info.Operations) == 0 {
+		return ""
+	}
+	return info.Operations[0].Name
+}
+
+// depth returns the maximum nesting depth of objects and arrays in a JSON document,
+// without decoding it
+func depth(body []byte) int {
+	current, maxDepth := 0, 0
+	inString, escaped := false, false
+
+	for _, b := range body {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			current++
+			if current > maxDepth {
+				maxDepth = current
+			}
+		case '}', ']':
+			current--
+		}
+	}
+	return maxDepth
+}
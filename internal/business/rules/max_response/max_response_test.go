@@ -0,0 +1,73 @@
+package max_response // nolint:revive
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxResponseRule_ReadBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           Config
+		body          string
+		contentLength int64
+		want          error
+	}{
+		{
+			name: "reads the full body when disabled",
+			cfg:  Config{Enabled: false, MaxBytes: 1, MaxDepth: 1},
+			body: `{"data":{"foo":{"bar":1}}}`,
+			want: nil,
+		},
+		{
+			name: "allows bodies within the limits",
+			cfg:  Config{Enabled: true, MaxBytes: 100, MaxDepth: 3},
+			body: `{"data":{"foo":{"bar":1}}}`,
+			want: nil,
+		},
+		{
+			name: "rejects bodies exceeding the max bytes",
+			cfg:  Config{Enabled: true, MaxBytes: 10},
+			body: `{"data":{"foo":{"bar":1}}}`,
+			want: ErrMaxBytesExceeded,
+		},
+		{
+			name:          "rejects on content length without reading",
+			cfg:           Config{Enabled: true, MaxBytes: 10},
+			body:          `{}`,
+			contentLength: 100,
+			want:          ErrMaxBytesExceeded,
+		},
+		{
+			name: "rejects bodies exceeding the max depth",
+			cfg:  Config{Enabled: true, MaxDepth: 2},
+			body: `{"data":{"foo":[{"bar":1}]}}`,
+			want: ErrMaxDepthExceeded,
+		},
+		{
+			name: "ignores brackets inside strings",
+			cfg:  Config{Enabled: true, MaxDepth: 2},
+			body: `{"data":{"foo":"[[{{\"}}]]"}}`,
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{
+				Body:          io.NopCloser(strings.NewReader(tt.body)),
+				ContentLength: tt.contentLength,
+			}
+			defer res.Body.Close()
+
+			body, err := NewMaxResponseRule(tt.cfg).ReadBody(res)
+			assert.ErrorIs(t, err, tt.want)
+			if tt.want == nil {
+				assert.Equal(t, tt.body, string(body))
+			}
+		})
+	}
+}
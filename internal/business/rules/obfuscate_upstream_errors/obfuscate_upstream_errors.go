@@ -1,29 +1,128 @@
 package obfuscate_upstream_errors // nolint:revive
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Message replacing the message of every upstream error
+	Message string `yaml:"message"`
+	// Values of `extensions.code` that are safe to return to clients, all other codes are removed
+	AllowedCodes []string `yaml:"allowed_codes"`
+	// Keep the path of the field that produced the error
+	IncludePath bool `yaml:"include_path"`
+	// Add a generated ID to `extensions.errorId`, which is logged alongside the original error
+	IncludeErrorID bool `yaml:"include_error_id"`
+	// Log the original upstream errors along with their error ID, so errors reported by clients can be traced
+	LogOriginalErrors bool `yaml:"log_original_errors"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:           true,
+		Message:           "Error(s) redacted",
+		AllowedCodes:      []string{},
+		IncludePath:       true,
+		IncludeErrorID:    true,
+		LogOriginalErrors: true,
+	}
+}
+
+// UnmarshalYAML accepts both a boolean, to only toggle the protection, and the full configuration
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
+	var enabled bool
+	if value.Kind == yaml.ScalarNode && value.Decode(&enabled) == nil {
+		c.Enabled = enabled
+		return nil
+	}
+
+	type plain Config
+	return value.Decode((*plain)(c))
+}
+
 type ObfuscateUpstreamErrors struct {
-	enabled bool
+	cfg Config
+	log *slog.Logger
 }
 
-func NewObfuscateUpstreamErrors(obfuscateUpstreamErrors bool) *ObfuscateUpstreamErrors {
+func NewObfuscateUpstreamErrors(cfg Config, log *slog.Logger) *ObfuscateUpstreamErrors {
 	return &ObfuscateUpstreamErrors{
-		enabled: obfuscateUpstreamErrors,
+		cfg: cfg,
+		log: log,
 	}
 }
 
+// ProcessBody replaces every upstream error with one holding only the information considered safe to return to clients.
+// The original errors are logged along with their error ID when enabled.
 func (a *ObfuscateUpstreamErrors) ProcessBody(payload map[string]interface{}) map[string]interface{} {
-	redactedErrorArray := []map[string]interface{}{
-		{
-			"message": "Error(s) redacted",
-		},
+	switch errs := payload["errors"].(type) {
+	case nil:
+		return payload
+	case []interface{}:
+		obfuscated := make([]map[string]interface{}, 0, len(errs))
+		for _, err := range errs {
+			obfuscated = append(obfuscated, a.obfuscate(err))
+		}
+		payload["errors"] = obfuscated
+	case []map[string]interface{}:
+		obfuscated := make([]map[string]interface{}, 0, len(errs))
+		for _, err := range errs {
+			obfuscated = append(obfuscated, a.obfuscate(err))
+		}
+		payload["errors"] = obfuscated
+	default:
+		payload["errors"] = []map[string]interface{}{a.obfuscate(errs)}
 	}
 
-	if payload["errors"] != nil {
-		payload["errors"] = redactedErrorArray
+	return payload
+}
+
+func (a *ObfuscateUpstreamErrors) obfuscate(original interface{}) map[string]interface{} {
+	obfuscated := map[string]interface{}{
+		"message": a.cfg.Message,
 	}
+	extensions := map[string]interface{}{}
 
-	return payload
+	if err, ok := original.(map[string]interface{}); ok {
+		if path, ok := err["path"]; ok && a.cfg.IncludePath {
+			obfuscated["path"] = path
+		}
+		if originalExtensions, ok := err["extensions"].(map[string]interface{}); ok {
+			if code, ok := originalExtensions["code"].(string); ok && slices.Contains(a.cfg.AllowedCodes, code) {
+				extensions["code"] = code
+			}
+		}
+	}
+
+	var errorID string
+	if a.cfg.IncludeErrorID {
+		errorID = newErrorID()
+		extensions["errorId"] = errorID
+	}
+
+	if len(extensions) > 0 {
+		obfuscated["extensions"] = extensions
+	}
+
+	if a.log != nil && a.cfg.LogOriginalErrors {
+		a.log.Info("Obfuscated upstream error", "errorId", errorID, "error", original)
+	}
+
+	return obfuscated
 }
 
 func (a *ObfuscateUpstreamErrors) Enabled() bool {
-	return a.enabled
+	return a.cfg.Enabled
+}
+
+func newErrorID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
 }
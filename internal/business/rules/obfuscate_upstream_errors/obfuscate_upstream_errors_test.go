@@ -1,8 +1,15 @@
 package obfuscate_upstream_errors // nolint:revive
 
 import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
 	"reflect"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestProcessBody(t *testing.T) {
@@ -82,6 +89,53 @@ func TestProcessBody(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "can handle errors that aren't objects",
+			args: args{
+				payload: map[string]interface{}{
+					"errors": []interface{}{
+						1,
+					},
+				},
+			},
+			want: map[string]interface{}{
+				"errors": []map[string]interface{}{
+					{
+						"message": "Error(s) redacted",
+					},
+				},
+			},
+		},
+		{
+			name: "keeps allowed codes and paths, strips everything else",
+			args: args{
+				payload: map[string]interface{}{
+					"errors": []interface{}{
+						map[string]interface{}{
+							"message":    "user 42 is not allowed to see the salary of user 43",
+							"path":       []interface{}{"user", "salary"},
+							"extensions": map[string]interface{}{"code": "FORBIDDEN", "stacktrace": []interface{}{"at resolveSalary"}},
+						},
+						map[string]interface{}{
+							"message":    "connection refused: postgres:5432",
+							"extensions": map[string]interface{}{"code": "INTERNAL_SERVER_ERROR"},
+						},
+					},
+				},
+			},
+			want: map[string]interface{}{
+				"errors": []map[string]interface{}{
+					{
+						"message":    "Error(s) redacted",
+						"path":       []interface{}{"user", "salary"},
+						"extensions": map[string]interface{}{"code": "FORBIDDEN"},
+					},
+					{
+						"message": "Error(s) redacted",
+					},
+				},
+			},
+		},
 		{
 			name: "Doesn't affect any other fields",
 			args: args{
@@ -112,7 +166,10 @@ func TestProcessBody(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			b := NewObfuscateUpstreamErrors(true)
+			cfg := DefaultConfig()
+			cfg.AllowedCodes = []string{"FORBIDDEN"}
+			cfg.IncludeErrorID = false
+			b := NewObfuscateUpstreamErrors(cfg, nil)
 
 			if got := b.ProcessBody(tt.args.payload); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("ProcessBody() = %v, want %v", got, tt.want)
@@ -120,3 +177,74 @@ func TestProcessBody(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessBody_ErrorID(t *testing.T) {
+	var logs bytes.Buffer
+	b := NewObfuscateUpstreamErrors(DefaultConfig(), slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	got := b.ProcessBody(map[string]interface{}{
+		"errors": []interface{}{
+			map[string]interface{}{"message": "connection refused: postgres:5432"},
+		},
+	})
+
+	errs := got["errors"].([]map[string]interface{})
+	require.Len(t, errs, 1)
+	errorID := errs[0]["extensions"].(map[string]interface{})["errorId"].(string)
+	assert.Len(t, errorID, 16)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, errorID, entry["errorId"])
+	assert.Equal(t, map[string]interface{}{"message": "connection refused: postgres:5432"}, entry["error"])
+}
+
+func TestProcessBody_OriginalErrorsNotLoggedWhenDisabled(t *testing.T) {
+	var logs bytes.Buffer
+	cfg := DefaultConfig()
+	cfg.LogOriginalErrors = false
+	b := NewObfuscateUpstreamErrors(cfg, slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	b.ProcessBody(map[string]interface{}{
+		"errors": []interface{}{
+			map[string]interface{}{"message": "connection refused: postgres:5432"},
+		},
+	})
+
+	assert.Empty(t, logs.String())
+}
+
+func TestConfig_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Config
+	}{
+		{
+			name:  "boolean toggles the protection",
+			input: "false",
+			want: func() Config {
+				cfg := DefaultConfig()
+				cfg.Enabled = false
+				return cfg
+			}(),
+		},
+		{
+			name:  "full configuration",
+			input: "allowed_codes: [ UNAUTHENTICATED ]\ninclude_path: false",
+			want: func() Config {
+				cfg := DefaultConfig()
+				cfg.AllowedCodes = []string{"UNAUTHENTICATED"}
+				cfg.IncludePath = false
+				return cfg
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			require.NoError(t, yaml.Unmarshal([]byte(tt.input), &cfg))
+			assert.Equal(t, tt.want, cfg)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package uploads
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"slices"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const megabyte = 1024 * 1024
+
+var (
+	resultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "uploads",
+		Name:      "results",
+		Help:      "The results of the uploads rule, including the reason a request was rejected",
+	},
+		[]string{"result", "reason"},
+	)
+
+	ErrMaxFilesExceeded      = errors.New("request has exceeded the maximum amount of files")
+	ErrMaxFileSizeExceeded   = errors.New("file has exceeded the maximum file size")
+	ErrMaxTotalSizeExceeded  = errors.New("files have exceeded the maximum total size")
+	ErrContentTypeNotAllowed = errors.New("file content type is not allowed")
+)
+
+type Config struct {
+	// Accept requests following the GraphQL multipart request spec, these are rejected when disabled
+	Enabled bool `yaml:"enabled"`
+	// Maximum amount of files per request, `0` disables the limit
+	MaxFiles int `yaml:"max_files"`
+	// Maximum size of a single file in bytes, `0` disables the limit
+	MaxFileSize int64 `yaml:"max_file_size"`
+	// Maximum size of all files of a request combined in bytes, `0` disables the limit
+	MaxTotalSize int64 `yaml:"max_total_size"`
+	// Content types files are allowed to have, any content type is allowed when empty
+	AllowedContentTypes []string `yaml:"allowed_content_types"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:             false,
+		MaxFiles:            10,
+		MaxFileSize:         10 * megabyte,
+		MaxTotalSize:        50 * megabyte,
+		AllowedContentTypes: []string{},
+	}
+}
+
+func init() {
+	prometheus.MustRegister(resultCounter)
+}
+
+type UploadsRule struct {
+	cfg Config
+}
+
+func NewUploadsRule(cfg Config) *UploadsRule {
+	return &UploadsRule{
+		cfg: cfg,
+	}
+}
+
+func (u *UploadsRule) Enabled() bool {
+	return u.cfg.Enabled
+}
+
+// BodyLimit returns the maximum size of a multipart request body, room for the files on top of the limit of other request bodies.
+// 0 means the size isn't limited.
+func (u *UploadsRule) BodyLimit(requestBodyMaxBytes int64) int64 {
+	if requestBodyMaxBytes == 0 || u.cfg.MaxTotalSize == 0 {
+		return 0
+	}
+	return requestBodyMaxBytes + u.cfg.MaxTotalSize
+}
+
+// ValidateCount rejects requests mapping more files than allowed, before any of the files is read
+func (u *UploadsRule) ValidateCount(files int) error {
+	if !u.cfg.Enabled {
+		return nil
+	}
+	if u.cfg.MaxFiles > 0 && files > u.cfg.MaxFiles {
+		resultCounter.WithLabelValues("rejected", "max_files").Inc()
+		return fmt.Errorf("%w. found [%d], max [%d]", ErrMaxFilesExceeded, files, u.cfg.MaxFiles)
+	}
+	return nil
+}
+
+func (u *UploadsRule) Validate(uploads []gql.Upload) error {
+	return u.Check(uploads, true)
+}
+
+// Check validates the files of a request while they're being read, the result is recorded once the request is rejected or complete
+func (u *UploadsRule) Check(uploads []gql.Upload, complete bool) error {
+	if !u.cfg.Enabled || len(uploads) == 0 {
+		return nil
+	}
+
+	if u.cfg.MaxFiles > 0 && len(uploads) > u.cfg.MaxFiles {
+		resultCounter.WithLabelValues("rejected", "max_files").Inc()
+		return fmt.Errorf("%w. found [%d], max [%d]", ErrMaxFilesExceeded, len(uploads), u.cfg.MaxFiles)
+	}
+
+	var total int64
+	for _, upload := range uploads {
+		if u.cfg.MaxFileSize > 0 && upload.Size > u.cfg.MaxFileSize {
+			resultCounter.WithLabelValues("rejected", "max_file_size").Inc()
+			return fmt.Errorf("%w. file [%s], max [%d] bytes", ErrMaxFileSizeExceeded, upload.Name, u.cfg.MaxFileSize)
+		}
+		if !u.contentTypeAllowed(upload.ContentType) {
+			resultCounter.WithLabelValues("rejected", "content_type").Inc()
+			return fmt.Errorf("%w. file [%s], content type [%s]", ErrContentTypeNotAllowed, upload.Name, upload.ContentType)
+		}
+		total += upload.Size
+	}
+
+	if u.cfg.MaxTotalSize > 0 && total > u.cfg.MaxTotalSize {
+		resultCounter.WithLabelValues("rejected", "max_total_size").Inc()
+		return fmt.Errorf("%w. found [%d] bytes, max [%d] bytes", ErrMaxTotalSizeExceeded, total, u.cfg.MaxTotalSize)
+	}
+
+	if complete {
+		resultCounter.WithLabelValues("allowed", "").Inc()
+	}
+	return nil
+}
+
+func (u *UploadsRule) contentTypeAllowed(contentType string) bool {
+	if len(u.cfg.AllowedContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(u.cfg.AllowedContentTypes, mediaType)
+}
@@ -0,0 +1,107 @@
+package uploads
+
+import (
+	"testing"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadsRule_Validate(t *testing.T) {
+	cfg := Config{
+		Enabled:             true,
+		MaxFiles:            2,
+		MaxFileSize:         100,
+		MaxTotalSize:        150,
+		AllowedContentTypes: []string{"image/png"},
+	}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		uploads []gql.Upload
+		wantErr error
+	}{
+		{
+			name: "disabled has no effect",
+			cfg:  Config{Enabled: false, MaxFiles: 1},
+			uploads: []gql.Upload{
+				{Name: "0", Size: 10},
+				{Name: "1", Size: 10},
+			},
+		},
+		{
+			name: "within limits passes",
+			cfg:  cfg,
+			uploads: []gql.Upload{
+				{Name: "0", ContentType: "image/png", Size: 100},
+				{Name: "1", ContentType: "image/png", Size: 50},
+			},
+		},
+		{
+			name: "too many files",
+			cfg:  cfg,
+			uploads: []gql.Upload{
+				{Name: "0", ContentType: "image/png", Size: 1},
+				{Name: "1", ContentType: "image/png", Size: 1},
+				{Name: "2", ContentType: "image/png", Size: 1},
+			},
+			wantErr: ErrMaxFilesExceeded,
+		},
+		{
+			name: "file too large",
+			cfg:  cfg,
+			uploads: []gql.Upload{
+				{Name: "0", ContentType: "image/png", Size: 101},
+			},
+			wantErr: ErrMaxFileSizeExceeded,
+		},
+		{
+			name: "files too large combined",
+			cfg:  cfg,
+			uploads: []gql.Upload{
+				{Name: "0", ContentType: "image/png", Size: 100},
+				{Name: "1", ContentType: "image/png", Size: 51},
+			},
+			wantErr: ErrMaxTotalSizeExceeded,
+		},
+		{
+			name: "content type not allowed",
+			cfg:  cfg,
+			uploads: []gql.Upload{
+				{Name: "0", ContentType: "application/x-sh", Size: 1},
+			},
+			wantErr: ErrContentTypeNotAllowed,
+		},
+		{
+			name: "zero disables limits",
+			cfg:  Config{Enabled: true},
+			uploads: []gql.Upload{
+				{Name: "0", ContentType: "application/octet-stream", Size: 1 << 30},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewUploadsRule(tt.cfg).Validate(tt.uploads)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestUploadsRule_ValidateCount(t *testing.T) {
+	assert.NoError(t, NewUploadsRule(Config{Enabled: true, MaxFiles: 2}).ValidateCount(2))
+	assert.ErrorIs(t, NewUploadsRule(Config{Enabled: true, MaxFiles: 2}).ValidateCount(3), ErrMaxFilesExceeded)
+	assert.NoError(t, NewUploadsRule(Config{Enabled: true}).ValidateCount(100))
+	assert.NoError(t, NewUploadsRule(Config{Enabled: false, MaxFiles: 2}).ValidateCount(3))
+}
+
+func TestUploadsRule_BodyLimit(t *testing.T) {
+	rule := NewUploadsRule(DefaultConfig())
+	assert.Equal(t, int64(102_400+50*megabyte), rule.BodyLimit(102_400))
+	assert.Equal(t, int64(0), rule.BodyLimit(0))
+	assert.Equal(t, int64(0), NewUploadsRule(Config{Enabled: true}).BodyLimit(102_400))
+}
@@ -51,6 +51,9 @@ type Provider struct {
 	done          chan bool
 	refreshTicker *time.Ticker
 	log           *slog.Logger
+	// time the schema was last loaded successfully, and the error of the latest load if it failed
+	lastReload time.Time
+	lastErr    error
 }
 
 func NewSchema(cfg Config, log *slog.Logger) (*Provider, error) {
@@ -100,11 +103,24 @@ func (p *Provider) load(contents string) error {
 
 func (p *Provider) loadFromFs() error {
 	contents, err := os.ReadFile(p.cfg.Path)
-	if err != nil {
-		return err
+	if err == nil {
+		err = p.load(string(contents))
+	}
 
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		p.lastReload = time.Now()
 	}
-	return p.load(string(contents))
+	p.lastErr = err
+	return err
+}
+
+// LastReload returns the time the schema was last loaded successfully, and the error of the latest reload if it failed
+func (p *Provider) LastReload() (time.Time, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.lastReload, p.lastErr
 }
 
 func (p *Provider) Get() *ast.Schema {
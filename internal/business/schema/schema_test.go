@@ -74,3 +74,30 @@ func TestSchemaGetNoRaceWithReload(t *testing.T) {
 	close(stop)
 	wg.Wait()
 }
+
+func TestLastReload(t *testing.T) {
+	path := writeTempSchema(t, minimalSchema)
+
+	p, err := NewSchema(Config{Path: path}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastReload, err := p.LastReload()
+	if lastReload.IsZero() || err != nil {
+		t.Fatalf("expected the initial load to be reported, got %v, %v", lastReload, err)
+	}
+
+	if err := os.WriteFile(path, []byte("type Query {"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_ = p.loadFromFs()
+
+	failedReload, err := p.LastReload()
+	if err == nil {
+		t.Error("expected the failed reload to be reported")
+	}
+	if !failedReload.Equal(lastReload) {
+		t.Errorf("a failed reload changed the last reload time from %v to %v", lastReload, failedReload)
+	}
+}
@@ -117,6 +117,9 @@ type Handler struct {
 	loader Loader
 	done   chan bool
 	lock   sync.RWMutex
+	// time the trusted documents were last loaded successfully, and the error of the latest load if it failed
+	lastReload time.Time
+	lastErr    error
 }
 
 func init() {
@@ -166,7 +169,7 @@ func NewPersistedOperations(log *slog.Logger, cfg Config, loader Loader) (*Handl
 // it uses the configuration supplied to decide its behavior
 func (p *Handler) SwapHashForQuery(next http.Handler) http.Handler { // nolint:funlen,cyclop
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		if !p.cfg.Enabled || r.Method != "POST" {
+		if !p.cfg.Enabled || (r.Method != http.MethodPost && r.Method != http.MethodGet) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -177,7 +180,7 @@ func (p *Handler) SwapHashForQuery(next http.Handler) http.Handler { // nolint:f
 		if err != nil {
 			p.log.Warn("error decoding payload", "err", err)
 			if p.cfg.RejectOnFailure {
-				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Type", gql.ResponseMediaType(r))
 				w.WriteHeader(http.StatusBadRequest)
 				res, _ := json.Marshal(ErrorPayload{Errors: gqlerror.List{gqlerror.Wrap(err)}})
 				_, _ = w.Write(res)
@@ -187,9 +190,11 @@ func (p *Handler) SwapHashForQuery(next http.Handler) http.Handler { // nolint:f
 			return
 		}
 
+		trusted := len(payload) > 0
 		for i, data := range payload {
 			if !p.cfg.RejectOnFailure && data.Query != "" {
 				persistedOpsCounter.WithLabelValues("unknown", "allowed").Inc()
+				trusted = false
 				continue
 			}
 
@@ -222,12 +227,39 @@ func (p *Handler) SwapHashForQuery(next http.Handler) http.Handler { // nolint:f
 		if len(errs) > 0 {
 			// if any error occurred we fail
 			res, _ := json.Marshal(ErrorPayload{Errors: errs})
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", gql.ResponseMediaType(r))
+			w.WriteHeader(gql.RequestErrorStatus(r))
 			_, _ = w.Write(res)
 			return
 		}
 
+		if info := gql.RequestInfoFromContext(r.Context()); info != nil {
+			info.TrustedDocuments = trusted
+		}
+
+		if r.Method == http.MethodGet {
+			if trusted && len(payload) == 1 {
+				// forward the query in place of the hash, keeping any other query parameters
+				values, err := payload[0].QueryParameters(r.URL.Query())
+				if err != nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+				r.URL.RawQuery = values.Encode()
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if gql.IsMultipart(r) {
+			// replace the operations only, so the files are forwarded unchanged
+			if err := gql.ReplaceMultipartOperations(r, payload); err != nil {
+				p.log.Warn("error replacing multipart operations", "err", err)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		var bts []byte
 		// forward batched request
 		if len(payload) > 1 {
@@ -250,6 +282,10 @@ func (p *Handler) SwapHashForQuery(next http.Handler) http.Handler { // nolint:f
 		// overwrite request body with new payload
 		r.Body = io.NopCloser(bytes.NewBuffer(bts))
 		r.ContentLength = int64(len(bts))
+		if r.Header.Get("Content-Type") != "" {
+			// the payload is encoded as JSON, regardless of the media type it was sent in
+			r.Header.Set("Content-Type", gql.MediaTypeJSON)
+		}
 
 		next.ServeHTTP(w, r)
 	}
@@ -262,6 +298,13 @@ func (p *Handler) GetTrustedDocuments() map[string]PersistedOperation {
 	return p.cache
 }
 
+// LastReload returns the time the trusted documents were last loaded successfully, and the error of the latest reload if it failed
+func (p *Handler) LastReload() (time.Time, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.lastReload, p.lastErr
+}
+
 func (p *Handler) Validate(validate func(data gql.RequestData) gqlerror.List) []validation.Error {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
@@ -285,6 +328,12 @@ func (p *Handler) Validate(validate func(data gql.RequestData) gqlerror.List) []
 
 func (p *Handler) load(failureStrategy ReloadFailureStrategy) error {
 	newState, err := p.loader.Load(context.Background())
+	p.lock.Lock()
+	if err == nil {
+		p.lastReload = time.Now()
+	}
+	p.lastErr = err
+	p.lock.Unlock()
 	if err != nil {
 		p.log.Error("error loading persisted operations", "err", err)
 		loadingResultCounter.WithLabelValues(p.loader.Type(), "failure").Inc()
@@ -12,8 +12,10 @@ import (
 
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -315,6 +317,185 @@ func TestNewPersistedOperations(t *testing.T) {
 	}
 }
 
+func TestSwapHashForQuery_MarksTrustedDocuments(t *testing.T) {
+	tests := []struct {
+		name string
+		data gql.RequestData
+		want bool
+	}{
+		{
+			name: "operations resolved from trusted documents",
+			data: gql.RequestData{
+				Extensions: gql.Extensions{
+					PersistedQuery: &gql.PersistedQuery{
+						Sha256Hash: "foobar",
+					},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "unpersisted operations",
+			data: gql.RequestData{
+				Query: "query { foo }",
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := map[string]PersistedOperation{
+				"foobar": newPersistedOperation("query { foobar }"),
+			}
+			po, _ := NewPersistedOperations(slog.Default(), Config{Enabled: true}, newMemoryLoader(cache))
+			po.cache = cache
+
+			bts, _ := json.Marshal(tt.data)
+			info := &gql.RequestInfo{}
+			req := httptest.NewRequest("POST", "/", bytes.NewBuffer(bts))
+			req = req.WithContext(gql.WithRequestInfo(req.Context(), info))
+
+			called := false
+			po.SwapHashForQuery(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+				called = true
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.True(t, called)
+			assert.Equal(t, tt.want, info.TrustedDocuments)
+		})
+	}
+}
+
+func TestSwapHashForQuery_GetRequests(t *testing.T) {
+	cache := map[string]PersistedOperation{
+		"foobar": newPersistedOperation("query Foobar { foobar }"),
+	}
+	po, _ := NewPersistedOperations(slog.Default(), Config{Enabled: true}, newMemoryLoader(cache))
+	po.cache = cache
+
+	req := httptest.NewRequest("GET", "/graphql?operationName=Foobar&extensions=%7B%22persistedQuery%22%3A%7B%22sha256Hash%22%3A%22foobar%22%7D%7D&locale=nl", nil)
+	req = req.WithContext(gql.WithRequestInfo(req.Context(), &gql.RequestInfo{}))
+
+	var forwarded *http.Request
+	po.SwapHashForQuery(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	if assert.NotNil(t, forwarded) {
+		assert.Equal(t, "query Foobar { foobar }", forwarded.URL.Query().Get("query"))
+		assert.Equal(t, "Foobar", forwarded.URL.Query().Get("operationName"))
+		assert.Equal(t, "nl", forwarded.URL.Query().Get("locale"))
+		assert.False(t, forwarded.URL.Query().Has("extensions"))
+	}
+}
+
+func TestSwapHashForQuery_FormEncodedRequests(t *testing.T) {
+	cache := map[string]PersistedOperation{
+		"foobar": newPersistedOperation("query Foobar { foobar }"),
+	}
+	po, _ := NewPersistedOperations(slog.Default(), Config{Enabled: true}, newMemoryLoader(cache))
+	po.cache = cache
+
+	req := httptest.NewRequest("POST", "/graphql", bytes.NewBufferString("extensions=%7B%22persistedQuery%22%3A%7B%22sha256Hash%22%3A%22foobar%22%7D%7D"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var forwarded *http.Request
+	po.SwapHashForQuery(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	if assert.NotNil(t, forwarded) {
+		assert.Equal(t, gql.MediaTypeJSON, forwarded.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(forwarded.Body)
+		assert.JSONEq(t, `{"operationName":"Foobar","query":"query Foobar { foobar }","extensions":{}}`, string(body))
+	}
+}
+
+func TestSwapHashForQuery_MultipartRequests(t *testing.T) {
+	cache := map[string]PersistedOperation{
+		"foobar": newPersistedOperation("mutation Upload($file: Upload!) { upload(file: $file) }"),
+	}
+	po, _ := NewPersistedOperations(slog.Default(), Config{Enabled: true}, newMemoryLoader(cache))
+	po.cache = cache
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("operations", `{"extensions":{"persistedQuery":{"sha256Hash":"foobar"}},"variables":{"file":null}}`)
+	_ = writer.WriteField("map", `{"0":["variables.file"]}`)
+	part, _ := writer.CreateFormFile("0", "a.txt")
+	_, _ = part.Write([]byte("file content"))
+	_ = writer.Close()
+
+	req := httptest.NewRequest("POST", "/graphql", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req = req.WithContext(gql.WithRequestInfo(req.Context(), &gql.RequestInfo{}))
+
+	var forwarded *http.Request
+	po.SwapHashForQuery(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	if assert.NotNil(t, forwarded) {
+		payload, err := gql.ParseRequestPayload(forwarded)
+		assert.NoError(t, err)
+		assert.Equal(t, []gql.RequestData{
+			{
+				OperationName: "Upload",
+				Query:         "mutation Upload($file: Upload!) { upload(file: $file) }",
+				Variables:     map[string]interface{}{"file": nil},
+			},
+		}, payload)
+
+		var uploads []gql.Upload
+		assert.NoError(t, gql.StreamUploads(forwarded, func(files []gql.Upload, _ bool) error {
+			uploads = slices.Clone(files)
+			return nil
+		}))
+		_, err = io.ReadAll(forwarded.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, []gql.Upload{{Name: "0", Filename: "a.txt", ContentType: "application/octet-stream", Size: 12}}, uploads)
+	}
+}
+
+func TestSwapHashForQuery_NegotiatedErrorStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		mediaType  string
+		wantStatus int
+	}{
+		{
+			name:       "application/json",
+			mediaType:  gql.MediaTypeJSON,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "application/graphql-response+json",
+			mediaType:  gql.MediaTypeGraphQLResponse,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			po, _ := NewPersistedOperations(slog.Default(), Config{Enabled: true}, newMemoryLoader(map[string]PersistedOperation{}))
+
+			bts, _ := json.Marshal(gql.RequestData{
+				Extensions: gql.Extensions{PersistedQuery: &gql.PersistedQuery{Sha256Hash: "unknown"}},
+			})
+			req := httptest.NewRequest("POST", "/", bytes.NewBuffer(bts))
+			req = req.WithContext(gql.WithRequestInfo(req.Context(), &gql.RequestInfo{ResponseMediaType: tt.mediaType}))
+			resp := httptest.NewRecorder()
+
+			po.SwapHashForQuery(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+				assert.Fail(t, "should not reach here")
+			})).ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.wantStatus, resp.Code)
+			assert.Equal(t, tt.mediaType, resp.Header().Get("Content-Type"))
+			assert.JSONEq(t, `{"errors":[{"message":"PersistedOperationNotFound"}]}`, resp.Body.String())
+		})
+	}
+}
+
 func TestLoader(t *testing.T) {
 	type args struct {
 		state           map[string]PersistedOperation
@@ -486,6 +667,34 @@ func TestLoadNilDoesNotWipeCache(t *testing.T) {
 	}
 }
 
+func TestLastReload(t *testing.T) {
+	loader := &testLoader{
+		err:             errors.New("storage unavailable"),
+		willReturnError: true,
+	}
+	po, err := NewPersistedOperations(slog.Default(), Config{}, loader)
+	assert.NoError(t, err)
+
+	lastReload, err := po.LastReload()
+	assert.True(t, lastReload.IsZero(), "a failed initial load is never reported as loaded")
+	assert.ErrorContains(t, err, "storage unavailable")
+
+	loader.willReturnError = false
+	loader.data = map[string]PersistedOperation{}
+	assert.NoError(t, po.load(ReloadFailureStrategyReject))
+
+	lastReload, err = po.LastReload()
+	assert.False(t, lastReload.IsZero())
+	assert.NoError(t, err)
+
+	// the next load fails again
+	assert.Error(t, po.load(ReloadFailureStrategyReject))
+
+	failedReload, err := po.LastReload()
+	assert.Equal(t, lastReload, failedReload)
+	assert.Error(t, err)
+}
+
 // TestGetTrustedDocumentsNoRace exercises GetTrustedDocuments concurrently with
 // cache writes to expose the missing lock (run with -race).
 func TestGetTrustedDocumentsNoRace(t *testing.T) {
@@ -0,0 +1,332 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/ldebruijn/graphql-protect/internal/business/schema"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	resultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "response_cache",
+		Name:      "results",
+		Help:      "The results of the response cache",
+	},
+		[]string{"result"},
+	)
+	entriesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "response_cache",
+		Name:      "entries",
+		Help:      "The number of responses held by the in-memory response cache",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(resultCounter, entriesGauge)
+}
+
+var ErrUnknownStoreType = errors.New("unknown response cache store type")
+
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Max age for responses for which neither the upstream nor the schema provide one. 0 means these aren't cached.
+	DefaultMaxAge time.Duration `yaml:"default_max_age"`
+	// Use the max-age or s-maxage of the `Cache-Control` header of the upstream response
+	UseUpstreamHeaders bool `yaml:"use_upstream_headers"`
+	// Use `@cacheControl` hints in the schema
+	UseSchemaHints bool `yaml:"use_schema_hints"`
+	// Request headers whose values are part of the cache key
+	VaryHeaders []string `yaml:"vary_headers"`
+	// Responses larger than this are not cached
+	MaxEntryBytes int         `yaml:"max_entry_bytes"`
+	Store         StoreConfig `yaml:"store"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:            false,
+		DefaultMaxAge:      0,
+		UseUpstreamHeaders: true,
+		UseSchemaHints:     false,
+		VaryHeaders:        []string{},
+		MaxEntryBytes:      1_048_576, // 1mb
+		Store: StoreConfig{
+			Type:       "memory",
+			MaxEntries: 10_000,
+		},
+	}
+}
+
+// ResponseCache serves responses to query operations from a cache, so identical queries don't have to reach the upstream
+type ResponseCache struct {
+	cfg    Config
+	schema *schema.Provider
+	store  Store
+}
+
+// NewResponseCache creates a response cache. The varyHeaders are added to the configured ones, for headers other rules vary responses by.
+func NewResponseCache(cfg Config, schema *schema.Provider, varyHeaders ...string) (*ResponseCache, error) {
+	var store Store
+	switch cfg.Store.Type {
+	case "memory", "":
+		store = NewMemoryStore(cfg.Store.MaxEntries)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStoreType, cfg.Store.Type)
+	}
+
+	return NewResponseCacheWithStore(cfg, schema, store, varyHeaders...), nil
+}
+
+// NewResponseCacheWithStore creates a response cache backed by a custom store
+func NewResponseCacheWithStore(cfg Config, schema *schema.Provider, store Store, varyHeaders ...string) *ResponseCache {
+	cfg.VaryHeaders = MergeHeaders(cfg.VaryHeaders, varyHeaders)
+	return &ResponseCache{
+		cfg:    cfg,
+		schema: schema,
+		store:  store,
+	}
+}
+
+func (c *ResponseCache) Handle(next http.Handler) http.Handler {
+	if !c.cfg.Enabled {
+		return next
+	}
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		info := gql.RequestInfoFromContext(r.Context())
+		if !info.OnlyQueries() {
+			resultCounter.WithLabelValues("bypass").Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var schemaAge time.Duration
+		if c.cfg.UseSchemaHints && c.schema != nil {
+			age, ok := schemaMaxAge(c.schema.Get(), info.Operations)
+			if !ok {
+				resultCounter.WithLabelValues("bypass").Inc()
+				next.ServeHTTP(w, r)
+				return
+			}
+			schemaAge = age
+		}
+
+		key, err := Key(r, c.cfg.VaryHeaders)
+		if err != nil {
+			resultCounter.WithLabelValues("bypass").Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if entry, ok := c.store.Get(r.Context(), key); ok {
+			resultCounter.WithLabelValues("hit").Inc()
+			writeEntry(w, entry)
+			return
+		}
+
+		resultCounter.WithLabelValues("miss").Inc()
+		w.Header().Set("X-Cache", "MISS")
+
+		rec := newRecorder(w, c.cfg.MaxEntryBytes)
+		next.ServeHTTP(rec, r)
+
+		ttl, ok := c.ttl(rec.Header(), schemaAge)
+		if !ok || !rec.cacheable() {
+			return
+		}
+
+		c.store.Set(r.Context(), key, &Entry{
+			StatusCode: rec.status,
+			Header:     rec.header,
+			Body:       rec.body.Bytes(),
+			StoredAt:   time.Now(),
+		}, ttl)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// ttl decides how long a response may be cached, taking the lowest max age of all enabled sources
+func (c *ResponseCache) ttl(header http.Header, schemaAge time.Duration) (time.Duration, bool) {
+	ttl := c.cfg.DefaultMaxAge
+
+	if c.cfg.UseUpstreamHeaders {
+		age, found, cacheable := upstreamMaxAge(header.Get("Cache-Control"))
+		if !cacheable {
+			return 0, false
+		}
+		if found {
+			ttl = age
+		}
+	}
+
+	if c.cfg.UseSchemaHints && (ttl == 0 || schemaAge < ttl) {
+		ttl = schemaAge
+	}
+
+	return ttl, ttl > 0
+}
+
+// Key calculates the cache key of a request from its normalized payload, path and the values of the vary headers
+func Key(r *http.Request, varyHeaders []string) (string, error) {
+	payload, err := gql.ParseRequestPayload(r)
+	if err != nil {
+		return "", err
+	}
+
+	// marshalling normalizes insignificant whitespace and the order of variables
+	normalized, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%s\n%s\n%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+	hash.Write(normalized)
+	for _, header := range varyHeaders {
+		_, _ = fmt.Fprintf(hash, "\n%s:%q", http.CanonicalHeaderKey(header), r.Header.Values(header))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// MergeHeaders returns the headers of both lists, without duplicates
+func MergeHeaders(headers []string, other []string) []string {
+	merged := make([]string, 0, len(headers)+len(other))
+	for _, header := range slices.Concat(headers, other) {
+		header = http.CanonicalHeaderKey(header)
+		if header != "" && !slices.Contains(merged, header) {
+			merged = append(merged, header)
+		}
+	}
+	return merged
+}
+
+func writeEntry(w http.ResponseWriter, entry *Entry) {
+	for name, values := range entry.Header {
+		if name != "Vary" {
+			w.Header()[name] = values
+			continue
+		}
+		// other middleware may vary the response as well, such as CORS on the Origin
+		for _, value := range values {
+			if !slices.Contains(w.Header().Values(name), value) {
+				w.Header().Add(name, value)
+			}
+		}
+	}
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(entry.StoredAt).Seconds())))
+	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	w.WriteHeader(entry.StatusCode)
+	_, _ = w.Write(entry.Body)
+}
+
+// privateHeaders are specific to a single client, and are never stored with a cached response
+var privateHeaders = []string{
+	"Set-Cookie",
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Expose-Headers",
+}
+
+// IsPrivateHeader returns whether the response header is specific to a single client, and must never be shared with other clients
+func IsPrivateHeader(name string) bool {
+	return slices.Contains(privateHeaders, http.CanonicalHeaderKey(name))
+}
+
+// recorder passes the response through to the client while keeping a copy for the cache
+type recorder struct {
+	http.ResponseWriter
+	maxBytes int
+	// before holds the headers set by other middleware before the request was forwarded
+	before   http.Header
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func newRecorder(w http.ResponseWriter, maxBytes int) *recorder {
+	return &recorder{
+		ResponseWriter: w,
+		maxBytes:       maxBytes,
+		before:         w.Header().Clone(),
+	}
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+		r.header = upstreamHeader(r.before, r.Header())
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// upstreamHeader returns the headers of the response that weren't set by other middleware, leaving out private headers
+func upstreamHeader(before http.Header, after http.Header) http.Header {
+	header := http.Header{}
+	for name, values := range after {
+		if IsPrivateHeader(name) {
+			continue
+		}
+		if previous, ok := before[name]; ok {
+			if len(values) < len(previous) || !slices.Equal(values[:len(previous)], previous) {
+				// replaced rather than added to
+				header[name] = slices.Clone(values)
+				continue
+			}
+			values = values[len(previous):]
+		}
+		if len(values) > 0 {
+			header[name] = slices.Clone(values)
+		}
+	}
+	return header
+}
+
+func (r *recorder) Write(bts []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.overflow {
+		if r.maxBytes > 0 && r.body.Len()+len(bts) > r.maxBytes {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(bts)
+		}
+	}
+	return r.ResponseWriter.Write(bts)
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// cacheable returns whether the recorded response is a complete, successful GraphQL response without errors
+func (r *recorder) cacheable() bool {
+	if r.status != http.StatusOK || r.overflow {
+		return false
+	}
+
+	var response struct {
+		Errors json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(r.body.Bytes(), &response); err != nil {
+		return false
+	}
+	return len(response.Errors) == 0 || string(response.Errors) == "null"
+}
@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/ldebruijn/graphql-protect/internal/http/cors"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+type countingUpstream struct {
+	calls        int
+	cacheControl string
+	body         string
+}
+
+func (u *countingUpstream) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	u.calls++
+	if u.cacheControl != "" {
+		w.Header().Set("Cache-Control", u.cacheControl)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(u.body))
+}
+
+func newRequest(body string, operationType ast.Operation, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	info := &gql.RequestInfo{Operations: []gql.Operation{{Type: operationType}}, OperationCount: 1}
+	return r.WithContext(gql.WithRequestInfo(r.Context(), info))
+}
+
+func serve(handler http.Handler, r *http.Request) (*http.Response, string) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return res, string(body)
+}
+
+func TestResponseCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       func(cfg *Config)
+		upstream  *countingUpstream
+		requests  []*http.Request
+		wantCalls int
+	}{
+		{
+			name:      "serves repeated queries from cache",
+			upstream:  &countingUpstream{cacheControl: "public, max-age=60", body: `{"data":{"foo":"bar"}}`},
+			requests:  []*http.Request{newRequest(`{"query":"{ foo }"}`, ast.Query, nil), newRequest(`{"query":"{ foo }"}`, ast.Query, nil)},
+			wantCalls: 1,
+		},
+		{
+			name:     "normalizes the payload",
+			upstream: &countingUpstream{cacheControl: "max-age=60", body: `{"data":{"foo":"bar"}}`},
+			requests: []*http.Request{
+				newRequest(`{"query":"query($a: Int, $b: Int) { foo }","variables":{"a":1,"b":2}}`, ast.Query, nil),
+				newRequest(`{ "variables": {"b":2, "a":1}, "query": "query($a: Int, $b: Int) { foo }" }`, ast.Query, nil),
+			},
+			wantCalls: 1,
+		},
+		{
+			name:      "different variables are cached separately",
+			upstream:  &countingUpstream{cacheControl: "max-age=60", body: `{"data":{"foo":"bar"}}`},
+			requests:  []*http.Request{newRequest(`{"query":"{ foo }","variables":{"a":1}}`, ast.Query, nil), newRequest(`{"query":"{ foo }","variables":{"a":2}}`, ast.Query, nil)},
+			wantCalls: 2,
+		},
+		{
+			name: "vary headers are part of the key",
+			cfg: func(cfg *Config) {
+				cfg.VaryHeaders = []string{"Accept-Language"}
+			},
+			upstream: &countingUpstream{cacheControl: "max-age=60", body: `{"data":{"foo":"bar"}}`},
+			requests: []*http.Request{
+				newRequest(`{"query":"{ foo }"}`, ast.Query, map[string]string{"Accept-Language": "nl"}),
+				newRequest(`{"query":"{ foo }"}`, ast.Query, map[string]string{"Accept-Language": "en"}),
+				newRequest(`{"query":"{ foo }"}`, ast.Query, map[string]string{"Accept-Language": "nl"}),
+			},
+			wantCalls: 2,
+		},
+		{
+			name:      "never caches mutations",
+			upstream:  &countingUpstream{cacheControl: "max-age=60", body: `{"data":{"foo":"bar"}}`},
+			requests:  []*http.Request{newRequest(`{"query":"mutation { foo }"}`, ast.Mutation, nil), newRequest(`{"query":"mutation { foo }"}`, ast.Mutation, nil)},
+			wantCalls: 2,
+		},
+		{
+			name:      "honors private responses",
+			upstream:  &countingUpstream{cacheControl: "private, max-age=60", body: `{"data":{"foo":"bar"}}`},
+			requests:  []*http.Request{newRequest(`{"query":"{ foo }"}`, ast.Query, nil), newRequest(`{"query":"{ foo }"}`, ast.Query, nil)},
+			wantCalls: 2,
+		},
+		{
+			name:      "does not cache without a max age",
+			upstream:  &countingUpstream{body: `{"data":{"foo":"bar"}}`},
+			requests:  []*http.Request{newRequest(`{"query":"{ foo }"}`, ast.Query, nil), newRequest(`{"query":"{ foo }"}`, ast.Query, nil)},
+			wantCalls: 2,
+		},
+		{
+			name: "uses the default max age when upstream provides none",
+			cfg: func(cfg *Config) {
+				cfg.DefaultMaxAge = time.Minute
+			},
+			upstream:  &countingUpstream{body: `{"data":{"foo":"bar"}}`},
+			requests:  []*http.Request{newRequest(`{"query":"{ foo }"}`, ast.Query, nil), newRequest(`{"query":"{ foo }"}`, ast.Query, nil)},
+			wantCalls: 1,
+		},
+		{
+			name:      "does not cache responses with errors",
+			upstream:  &countingUpstream{cacheControl: "max-age=60", body: `{"data":null,"errors":[{"message":"boom"}]}`},
+			requests:  []*http.Request{newRequest(`{"query":"{ foo }"}`, ast.Query, nil), newRequest(`{"query":"{ foo }"}`, ast.Query, nil)},
+			wantCalls: 2,
+		},
+		{
+			name: "does not cache responses exceeding the entry size",
+			cfg: func(cfg *Config) {
+				cfg.MaxEntryBytes = 10
+			},
+			upstream:  &countingUpstream{cacheControl: "max-age=60", body: `{"data":{"foo":"bar"}}`},
+			requests:  []*http.Request{newRequest(`{"query":"{ foo }"}`, ast.Query, nil), newRequest(`{"query":"{ foo }"}`, ast.Query, nil)},
+			wantCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Enabled = true
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+
+			c, err := NewResponseCache(cfg, nil)
+			assert.NoError(t, err)
+			handler := c.Handle(tt.upstream)
+
+			for _, r := range tt.requests {
+				_, body := serve(handler, r)
+				assert.Equal(t, tt.upstream.body, body)
+			}
+			assert.Equal(t, tt.wantCalls, tt.upstream.calls)
+		})
+	}
+}
+
+func TestResponseCache_Hit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	c, err := NewResponseCache(cfg, nil)
+	assert.NoError(t, err)
+
+	upstream := &countingUpstream{cacheControl: "max-age=60", body: `{"data":{"foo":"bar"}}`}
+	handler := c.Handle(upstream)
+
+	res, _ := serve(handler, newRequest(`{"query":"{ foo }"}`, ast.Query, nil))
+	assert.Equal(t, "MISS", res.Header.Get("X-Cache"))
+
+	res, body := serve(handler, newRequest(`{"query":"{ foo }"}`, ast.Query, nil))
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "HIT", res.Header.Get("X-Cache"))
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.Equal(t, "max-age=60", res.Header.Get("Cache-Control"))
+	assert.Equal(t, "0", res.Header.Get("Age"))
+	assert.Equal(t, `{"data":{"foo":"bar"}}`, body)
+}
+
+func TestResponseCache_PrivateHeaders(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	c, err := NewResponseCache(cfg, nil)
+	assert.NoError(t, err)
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Header().Add("Vary", "Accept-Encoding")
+		_, _ = w.Write([]byte(`{"data":{"foo":"bar"}}`))
+	})
+
+	corsCfg := cors.DefaultConfig()
+	corsCfg.Enabled = true
+	corsCfg.AllowedOrigins = []string{"https://a.example.com", "https://b.example.com"}
+	corsCfg.AllowCredentials = true
+	corsHandler, err := cors.NewCORS(corsCfg)
+	assert.NoError(t, err)
+	handler := corsHandler.Handle(c.Handle(upstream))
+
+	res, _ := serve(handler, newRequest(`{"query":"{ foo }"}`, ast.Query, map[string]string{"Origin": "https://a.example.com"}))
+	assert.Equal(t, "MISS", res.Header.Get("X-Cache"))
+	assert.Equal(t, "https://a.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+
+	res, body := serve(handler, newRequest(`{"query":"{ foo }"}`, ast.Query, map[string]string{"Origin": "https://b.example.com"}))
+	assert.Equal(t, "HIT", res.Header.Get("X-Cache"))
+	assert.Equal(t, `{"data":{"foo":"bar"}}`, body)
+	assert.Equal(t, "https://b.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", res.Header.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, []string{"Origin", "Accept-Encoding"}, res.Header.Values("Vary"))
+	assert.Empty(t, res.Header.Get("Set-Cookie"))
+
+	res, _ = serve(handler, newRequest(`{"query":"{ foo }"}`, ast.Query, map[string]string{"Origin": "https://evil.example.com"}))
+	assert.Equal(t, "HIT", res.Header.Get("X-Cache"))
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Credentials"))
+}
+
+func TestResponseCache_VaryHeaders(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	cfg.VaryHeaders = []string{"accept-language"}
+	c, err := NewResponseCache(cfg, nil, "X-Roles", "Accept-Language")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Accept-Language", "X-Roles"}, c.cfg.VaryHeaders)
+
+	upstream := &countingUpstream{cacheControl: "max-age=60", body: `{"data":{"foo":"bar"}}`}
+	handler := c.Handle(upstream)
+
+	res, _ := serve(handler, newRequest(`{"query":"{ foo }"}`, ast.Query, map[string]string{"X-Roles": "admin"}))
+	assert.Equal(t, "MISS", res.Header.Get("X-Cache"))
+
+	res, _ = serve(handler, newRequest(`{"query":"{ foo }"}`, ast.Query, map[string]string{"X-Roles": "support"}))
+	assert.Equal(t, "MISS", res.Header.Get("X-Cache"))
+
+	res, _ = serve(handler, newRequest(`{"query":"{ foo }"}`, ast.Query, map[string]string{"X-Roles": "admin"}))
+	assert.Equal(t, "HIT", res.Header.Get("X-Cache"))
+	assert.Equal(t, 2, upstream.calls)
+}
+
+func TestNewResponseCache_UnknownStore(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Store.Type = "redis"
+
+	_, err := NewResponseCache(cfg, nil)
+	assert.ErrorIs(t, err, ErrUnknownStoreType)
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(2)
+	ctx := t.Context()
+
+	store.Set(ctx, "a", &Entry{Body: []byte("a")}, time.Minute)
+	store.Set(ctx, "b", &Entry{Body: []byte("b")}, time.Minute)
+	// touch a, so b is the least recently used
+	_, ok := store.Get(ctx, "a")
+	assert.True(t, ok)
+	store.Set(ctx, "c", &Entry{Body: []byte("c")}, time.Minute)
+
+	_, ok = store.Get(ctx, "b")
+	assert.False(t, ok)
+	_, ok = store.Get(ctx, "a")
+	assert.True(t, ok)
+
+	store.Set(ctx, "expired", &Entry{}, -time.Second)
+	_, ok = store.Get(ctx, "expired")
+	assert.False(t, ok)
+}
+
+func TestUpstreamMaxAge(t *testing.T) {
+	tests := []struct {
+		header        string
+		wantAge       time.Duration
+		wantFound     bool
+		wantCacheable bool
+	}{
+		{header: "", wantCacheable: true},
+		{header: "public", wantCacheable: true},
+		{header: "max-age=60", wantAge: time.Minute, wantFound: true, wantCacheable: true},
+		{header: "max-age=60, s-maxage=10", wantAge: 10 * time.Second, wantFound: true, wantCacheable: true},
+		{header: "private, max-age=60"},
+		{header: "no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			age, found, cacheable := upstreamMaxAge(tt.header)
+			assert.Equal(t, tt.wantAge, age)
+			assert.Equal(t, tt.wantFound, found)
+			assert.Equal(t, tt.wantCacheable, cacheable)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// schemaMaxAge calculates the max age of a response from the `@cacheControl` hints in the schema,
+// following the semantics of Apollo Server:
+//   - root fields and fields returning a composite type default to a max age of 0
+//   - fields returning a scalar inherit the max age of their parent
+//   - a hint on a field takes precedence over a hint on its return type
+//   - the response is only cacheable if no field has a `PRIVATE` scope
+//
+// The max age of the response is the lowest max age of all fields.
+func schemaMaxAge(schema *ast.Schema, operations []gql.Operation) (time.Duration, bool) {
+	if schema == nil || len(operations) == 0 {
+		return 0, false
+	}
+
+	policy := &hintPolicy{schema: schema, maxAge: -1}
+	for _, operation := range operations {
+		if operation.Definition == nil {
+			return 0, false
+		}
+		policy.walk(operation.Definition.SelectionSet, nil)
+	}
+
+	if policy.private || policy.maxAge <= 0 {
+		return 0, false
+	}
+	return time.Duration(policy.maxAge) * time.Second, true
+}
+
+type hintPolicy struct {
+	schema  *ast.Schema
+	maxAge  int
+	private bool
+}
+
+func (h *hintPolicy) walk(set ast.SelectionSet, parentMaxAge *int) {
+	for _, selection := range set {
+		switch s := selection.(type) {
+		case *ast.Field:
+			h.field(s, parentMaxAge)
+		case *ast.InlineFragment:
+			h.walk(s.SelectionSet, parentMaxAge)
+		case *ast.FragmentSpread:
+			if s.Definition != nil {
+				h.walk(s.Definition.SelectionSet, parentMaxAge)
+			}
+		}
+	}
+}
+
+func (h *hintPolicy) field(field *ast.Field, parentMaxAge *int) {
+	if field.Definition == nil {
+		return
+	}
+
+	maxAge, ok := h.hint(field, parentMaxAge)
+	if !ok {
+		maxAge = 0
+		if parentMaxAge != nil && len(field.SelectionSet) == 0 {
+			maxAge = *parentMaxAge
+		}
+	}
+
+	if h.maxAge < 0 || maxAge < h.maxAge {
+		h.maxAge = maxAge
+	}
+
+	if len(field.SelectionSet) > 0 {
+		h.walk(field.SelectionSet, &maxAge)
+	}
+}
+
+// hint returns the max age hinted by the field definition, or by the type it returns
+func (h *hintPolicy) hint(field *ast.Field, parentMaxAge *int) (int, bool) {
+	directive := field.Definition.Directives.ForName("cacheControl")
+	if directive == nil && field.Definition.Type != nil {
+		if definition, ok := h.schema.Types[field.Definition.Type.Name()]; ok {
+			directive = definition.Directives.ForName("cacheControl")
+		}
+	}
+	if directive == nil {
+		return 0, false
+	}
+
+	if scope := directive.Arguments.ForName("scope"); scope != nil && scope.Value != nil && strings.EqualFold(scope.Value.Raw, "PRIVATE") {
+		h.private = true
+	}
+
+	if inherit := directive.Arguments.ForName("inheritMaxAge"); inherit != nil && inherit.Value != nil && inherit.Value.Raw == "true" && parentMaxAge != nil {
+		return *parentMaxAge, true
+	}
+
+	argument := directive.Arguments.ForName("maxAge")
+	if argument == nil || argument.Value == nil {
+		return 0, false
+	}
+	maxAge, err := strconv.Atoi(argument.Value.Raw)
+	if err != nil {
+		return 0, false
+	}
+	return maxAge, true
+}
+
+// upstreamMaxAge reads the max age from the Cache-Control header of the upstream response.
+// Returns whether a max age was given, and whether the response may be stored in a shared cache at all.
+func upstreamMaxAge(header string) (maxAge time.Duration, found bool, cacheable bool) {
+	age, sharedAge := -1, -1
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "private", "no-store", "no-cache":
+			return 0, false, false
+		case "max-age":
+			if v, err := strconv.Atoi(value); err == nil {
+				age = v
+			}
+		case "s-maxage":
+			if v, err := strconv.Atoi(value); err == nil {
+				sharedAge = v
+			}
+		}
+	}
+
+	if sharedAge >= 0 {
+		age = sharedAge
+	}
+	if age < 0 {
+		return 0, false, true
+	}
+	return time.Duration(age) * time.Second, true, true
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+const hintsSchema = `
+enum CacheControlScope { PUBLIC PRIVATE }
+directive @cacheControl(maxAge: Int, scope: CacheControlScope, inheritMaxAge: Boolean) on FIELD_DEFINITION | OBJECT | INTERFACE | UNION
+
+type Query {
+	products: [Product] @cacheControl(maxAge: 300)
+	product(id: ID!): Product
+	me: User @cacheControl(maxAge: 60, scope: PRIVATE)
+	uncached: String
+}
+
+type Product @cacheControl(maxAge: 120) {
+	id: ID!
+	name: String
+	price: Price @cacheControl(inheritMaxAge: true)
+	reviews: [Review]
+}
+
+type Price {
+	amount: Int
+}
+
+type Review {
+	text: String
+}
+
+type User {
+	name: String
+}
+`
+
+func TestSchemaMaxAge(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: hintsSchema})
+
+	tests := []struct {
+		name       string
+		query      string
+		wantAge    time.Duration
+		wantCached bool
+	}{
+		{
+			name:       "field hint takes precedence over the type hint",
+			query:      "{ products { id name } }",
+			wantAge:    300 * time.Second,
+			wantCached: true,
+		},
+		{
+			name:       "type hint applies to fields without a hint",
+			query:      "{ product(id: 1) { name } }",
+			wantAge:    120 * time.Second,
+			wantCached: true,
+		},
+		{
+			name:       "inheritMaxAge inherits the max age of the parent",
+			query:      "{ product(id: 1) { price { amount } } }",
+			wantAge:    120 * time.Second,
+			wantCached: true,
+		},
+		{
+			name:       "composite fields without hints default to 0",
+			query:      "{ product(id: 1) { reviews { text } } }",
+			wantCached: false,
+		},
+		{
+			name:       "root fields without hints default to 0",
+			query:      "{ products { id } uncached }",
+			wantCached: false,
+		},
+		{
+			name:       "private scopes are never cached",
+			query:      "{ me { name } }",
+			wantCached: false,
+		},
+		{
+			name:       "hints in fragments are respected",
+			query:      "{ ...F } fragment F on Query { product(id: 1) { ... on Product { name } } }",
+			wantAge:    120 * time.Second,
+			wantCached: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, errs := gqlparser.LoadQuery(schema, tt.query)
+			assert.Empty(t, errs)
+
+			operation, ok := gql.NewOperation(doc, "")
+			assert.True(t, ok)
+
+			age, cached := schemaMaxAge(schema, []gql.Operation{operation})
+			assert.Equal(t, tt.wantCached, cached)
+			assert.Equal(t, tt.wantAge, age)
+		})
+	}
+}
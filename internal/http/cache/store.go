@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Entry is a cached upstream response
+type Entry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	StoredAt   time.Time
+}
+
+// Store is the storage backend of the response cache
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, bool)
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration)
+}
+
+type StoreConfig struct {
+	// Type of store to use, currently only `memory` is supported
+	Type string `yaml:"type"`
+	// Maximum number of responses kept in memory, the least recently used response is evicted first
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// MemoryStore keeps responses in memory, evicting the least recently used response once full
+type MemoryStore struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type memoryItem struct {
+	key       string
+	entry     *Entry
+	expiresAt time.Time
+}
+
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+func (m *MemoryStore) Get(_ context.Context, key string) (*Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	item := element.Value.(*memoryItem) // nolint:forcetypeassert
+	if time.Now().After(item.expiresAt) {
+		m.remove(element)
+		return nil, false
+	}
+
+	m.lru.MoveToFront(element)
+	return item.entry, true
+}
+
+func (m *MemoryStore) Set(_ context.Context, key string, entry *Entry, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item := &memoryItem{
+		key:       key,
+		entry:     entry,
+		expiresAt: time.Now().Add(ttl),
+	}
+
+	if element, ok := m.entries[key]; ok {
+		element.Value = item
+		m.lru.MoveToFront(element)
+		return
+	}
+
+	m.entries[key] = m.lru.PushFront(item)
+	for m.maxEntries > 0 && m.lru.Len() > m.maxEntries {
+		m.remove(m.lru.Back())
+	}
+	entriesGauge.Set(float64(m.lru.Len()))
+}
+
+func (m *MemoryStore) remove(element *list.Element) {
+	item := element.Value.(*memoryItem) // nolint:forcetypeassert
+	delete(m.entries, item.key)
+	m.lru.Remove(element)
+	entriesGauge.Set(float64(m.lru.Len()))
+}
@@ -0,0 +1,202 @@
+package coalesce
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/ldebruijn/graphql-protect/internal/http/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var resultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "graphql_protect",
+	Subsystem: "coalescing",
+	Name:      "results",
+	Help:      "The results of request coalescing, by operation name",
+},
+	[]string{"operation", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(resultCounter)
+}
+
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Names of the query operations that may be coalesced
+	Operations []string `yaml:"operations"`
+	// Request headers whose values must be identical for requests to be coalesced
+	VaryHeaders []string `yaml:"vary_headers"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:     false,
+		Operations:  []string{},
+		VaryHeaders: []string{},
+	}
+}
+
+// Coalescer sends concurrent identical query operations to the upstream only once,
+// and fans out the response to every waiting client
+type Coalescer struct {
+	cfg        Config
+	operations map[string]bool
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done     chan struct{}
+	response *response
+	// failed is set when forwarding panicked, leaving a partial response that must not be replayed
+	failed bool
+}
+
+// NewCoalescer creates a coalescer. The varyHeaders are added to the configured ones, for headers other rules vary responses by.
+func NewCoalescer(cfg Config, varyHeaders ...string) *Coalescer {
+	cfg.VaryHeaders = cache.MergeHeaders(cfg.VaryHeaders, varyHeaders)
+
+	operations := map[string]bool{}
+	for _, operation := range cfg.Operations {
+		operations[operation] = true
+	}
+
+	return &Coalescer{
+		cfg:        cfg,
+		operations: operations,
+		calls:      map[string]*call{},
+	}
+}
+
+func (c *Coalescer) Handle(next http.Handler) http.Handler {
+	if !c.cfg.Enabled {
+		return next
+	}
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		operation, ok := c.eligible(gql.RequestInfoFromContext(r.Context()))
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := cache.Key(r, c.cfg.VaryHeaders)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c.mu.Lock()
+		if existing, ok := c.calls[key]; ok {
+			c.mu.Unlock()
+			resultCounter.WithLabelValues(operation, "coalesced").Inc()
+
+			select {
+			case <-existing.done:
+				if existing.failed {
+					next.ServeHTTP(w, r)
+					return
+				}
+				existing.response.writeTo(w, true)
+			case <-r.Context().Done():
+			}
+			return
+		}
+		current := &call{done: make(chan struct{})}
+		c.calls[key] = current
+		c.mu.Unlock()
+
+		resultCounter.WithLabelValues(operation, "forwarded").Inc()
+
+		current.response = newResponse()
+		func() {
+			completed := false
+			// release waiting clients, even if forwarding panics
+			defer func() {
+				c.mu.Lock()
+				delete(c.calls, key)
+				c.mu.Unlock()
+				current.failed = !completed
+				close(current.done)
+			}()
+
+			// the upstream request is shared, so it should not be cancelled when this particular client goes away
+			next.ServeHTTP(current.response, r.WithContext(context.WithoutCancel(r.Context())))
+			completed = true
+		}()
+
+		current.response.writeTo(w, false)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// eligible returns whether the request consists of a single query operation that may be coalesced
+func (c *Coalescer) eligible(info *gql.RequestInfo) (string, bool) {
+	if !info.OnlyQueries() || len(info.Operations) != 1 {
+		return "", false
+	}
+	name := info.Operations[0].Name
+	return name, c.operations[name]
+}
+
+// response buffers a response so it can be written to multiple clients
+type response struct {
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func newResponse() *response {
+	return &response{
+		header: http.Header{},
+	}
+}
+
+func (r *response) Header() http.Header {
+	return r.header
+}
+
+func (r *response) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *response) Write(bts []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(bts)
+}
+
+// writeTo writes the response to a client. When the response is shared with a client other than the one it was forwarded for,
+// headers specific to that client, such as cookies, are left out.
+func (r *response) writeTo(w http.ResponseWriter, shared bool) {
+	for name, values := range r.header {
+		switch {
+		case shared && cache.IsPrivateHeader(name):
+			continue
+		case name == "Vary":
+			// other middleware may vary the response as well, such as CORS on the Origin
+			for _, value := range values {
+				if !slices.Contains(w.Header().Values(name), value) {
+					w.Header().Add(name, value)
+				}
+			}
+		default:
+			w.Header()[name] = slices.Clone(values)
+		}
+	}
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(r.body.Bytes())
+}
@@ -0,0 +1,203 @@
+package coalesce
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func newRequest(operations ...gql.Operation) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"query Products { products { id } }"}`))
+	return r.WithContext(gql.WithRequestInfo(r.Context(), &gql.RequestInfo{Operations: operations, OperationCount: len(operations)}))
+}
+
+func TestCoalescer(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation gql.Operation
+		wantCalls int32
+	}{
+		{
+			name:      "coalesces configured query operations",
+			operation: gql.Operation{Name: "Products", Type: ast.Query},
+			wantCalls: 1,
+		},
+		{
+			name:      "does not coalesce operations that aren't configured",
+			operation: gql.Operation{Name: "Other", Type: ast.Query},
+			wantCalls: 5,
+		},
+		{
+			name:      "never coalesces mutations",
+			operation: gql.Operation{Name: "Products", Type: ast.Mutation},
+			wantCalls: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const clients = 5
+
+			var calls atomic.Int32
+			var arrived sync.WaitGroup
+			arrived.Add(clients)
+			release := make(chan struct{})
+
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				calls.Add(1)
+				<-release
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"data":{"products":[]}}`))
+			})
+
+			handler := NewCoalescer(Config{Enabled: true, Operations: []string{"Products"}}).Handle(upstream)
+
+			var done sync.WaitGroup
+			bodies := make([]string, clients)
+			for i := range clients {
+				done.Add(1)
+				go func() {
+					defer done.Done()
+					w := httptest.NewRecorder()
+					arrived.Done()
+					handler.ServeHTTP(w, newRequest(tt.operation))
+
+					res := w.Result()
+					defer res.Body.Close()
+					body, _ := io.ReadAll(res.Body)
+					bodies[i] = string(body)
+					assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+				}()
+			}
+
+			// give every client the opportunity to reach the coalescer before the upstream responds
+			arrived.Wait()
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			done.Wait()
+
+			assert.Equal(t, tt.wantCalls, calls.Load())
+			for _, body := range bodies {
+				assert.Equal(t, `{"data":{"products":[]}}`, body)
+			}
+		})
+	}
+}
+
+func TestCoalescer_SequentialRequestsAreForwarded(t *testing.T) {
+	var calls atomic.Int32
+	upstream := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+	})
+
+	handler := NewCoalescer(Config{Enabled: true, Operations: []string{"Products"}}).Handle(upstream)
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest(gql.Operation{Name: "Products", Type: ast.Query}))
+	}
+
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestCoalescer_LeaderPanic(t *testing.T) {
+	var calls atomic.Int32
+	leader := make(chan struct{})
+	release := make(chan struct{})
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			close(leader)
+			<-release
+			_, _ = w.Write([]byte(`{"data":`))
+			panic(http.ErrAbortHandler)
+		}
+		_, _ = w.Write([]byte(`{"data":{"products":[]}}`))
+	})
+
+	handler := NewCoalescer(Config{Enabled: true, Operations: []string{"Products"}}).Handle(upstream)
+
+	go func() {
+		defer func() {
+			_ = recover()
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest(gql.Operation{Name: "Products", Type: ast.Query}))
+	}()
+	<-leader
+
+	waiter := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newRequest(gql.Operation{Name: "Products", Type: ast.Query}))
+		waiter <- w
+	}()
+
+	// give the waiting client the opportunity to reach the coalescer before the leader panics
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	w := <-waiter
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"data":{"products":[]}}`, w.Body.String())
+}
+
+func TestCoalescer_VaryHeaders(t *testing.T) {
+	var calls atomic.Int32
+	leader := make(chan struct{})
+	release := make(chan struct{})
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(leader)
+		}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session="+r.Header.Get("X-Roles"))
+		_, _ = w.Write([]byte(`{"data":{"roles":"` + r.Header.Get("X-Roles") + `"}}`))
+	})
+
+	handler := NewCoalescer(Config{Enabled: true, Operations: []string{"Products"}}, "X-Roles").Handle(upstream)
+
+	serve := func(roles string) <-chan *httptest.ResponseRecorder {
+		result := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			r := newRequest(gql.Operation{Name: "Products", Type: ast.Query})
+			r.Header.Set("X-Roles", roles)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			result <- w
+		}()
+		return result
+	}
+
+	admin := serve("admin")
+	<-leader
+	waiter := serve("admin")
+	anonymous := serve("")
+
+	// give the other clients the opportunity to reach the coalescer before the upstream responds
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	w := <-admin
+	assert.Equal(t, `{"data":{"roles":"admin"}}`, w.Body.String())
+	assert.Equal(t, "session=admin", w.Header().Get("Set-Cookie"))
+
+	w = <-waiter
+	assert.Equal(t, `{"data":{"roles":"admin"}}`, w.Body.String())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+
+	w = <-anonymous
+	assert.Equal(t, `{"data":{"roles":""}}`, w.Body.String())
+	assert.Equal(t, "session=", w.Header().Get("Set-Cookie"))
+
+	assert.Equal(t, int32(2), calls.Load())
+}
@@ -0,0 +1,185 @@
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var preflightCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "graphql_protect",
+	Subsystem: "cors",
+	Name:      "preflight_count",
+	Help:      "Amount of CORS preflight requests answered, by result",
+},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(preflightCounter)
+}
+
+var ErrWildcardWithCredentials = errors.New("cors.allow_credentials can't be combined with the `*` origin, list the allowed origins instead")
+
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Origins allowed to make cross-origin requests. Supports `*` for any origin, and wildcard subdomains such as `https://*.example.com`
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// Methods allowed in cross-origin requests
+	AllowedMethods []string `yaml:"allowed_methods"`
+	// Headers allowed in cross-origin requests, `*` allows any header
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// Allow cross-origin requests to include credentials such as cookies. Can't be combined with the `*` origin
+	AllowCredentials bool `yaml:"allow_credentials"`
+	// Duration the result of a preflight request may be cached by the browser, `0` omits the header
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:          false,
+		AllowedOrigins:   []string{},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Apollo-Require-Preflight", "X-Apollo-Operation-Name"},
+		AllowCredentials: false,
+		MaxAge:           5 * time.Minute,
+	}
+}
+
+type CORS struct {
+	cfg            Config
+	allowedMethods []string
+	allowedHeaders []string
+	anyHeader      bool
+}
+
+func NewCORS(cfg Config) (*CORS, error) {
+	// allowing any origin to make requests with credentials would allow any website to read responses on behalf of its visitors
+	if cfg.Enabled && cfg.AllowCredentials && slices.Contains(cfg.AllowedOrigins, "*") {
+		return nil, ErrWildcardWithCredentials
+	}
+
+	c := &CORS{
+		cfg: cfg,
+	}
+	for _, method := range cfg.AllowedMethods {
+		c.allowedMethods = append(c.allowedMethods, strings.ToUpper(method))
+	}
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			c.anyHeader = true
+			continue
+		}
+		c.allowedHeaders = append(c.allowedHeaders, http.CanonicalHeaderKey(header))
+	}
+	return c, nil
+}
+
+// Handle answers preflight requests and adds CORS headers to the responses of cross-origin requests from allowed origins.
+// Preflight requests are never forwarded to the upstream.
+func (c *CORS) Handle(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !c.cfg.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		if r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+			c.preflight(w, r, origin)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if origin != "" && c.originAllowed(origin) {
+			c.setOrigin(w, origin)
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (c *CORS) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	headers := w.Header()
+	headers.Add("Vary", "Origin")
+	headers.Add("Vary", "Access-Control-Request-Method")
+	headers.Add("Vary", "Access-Control-Request-Headers")
+
+	// a preflight without CORS headers is rejected by the browser
+	if !c.originAllowed(origin) || !c.methodAllowed(r.Header.Get("Access-Control-Request-Method")) {
+		preflightCounter.WithLabelValues("rejected").Inc()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	requestedHeaders, ok := c.headersAllowed(r.Header.Get("Access-Control-Request-Headers"))
+	if !ok {
+		preflightCounter.WithLabelValues("rejected").Inc()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	c.setOrigin(w, origin)
+	headers.Set("Access-Control-Allow-Methods", strings.Join(c.allowedMethods, ", "))
+	if len(requestedHeaders) > 0 {
+		headers.Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+	}
+	if c.cfg.MaxAge > 0 {
+		headers.Set("Access-Control-Max-Age", strconv.Itoa(int(c.cfg.MaxAge.Seconds())))
+	}
+	preflightCounter.WithLabelValues("allowed").Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CORS) setOrigin(w http.ResponseWriter, origin string) {
+	if slices.Contains(c.cfg.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.cfg.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *CORS) originAllowed(origin string) bool {
+	for _, allowed := range c.cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok {
+			// the wildcard must match at least one character, so `https://*.example.com` doesn't match `https://.example.com`
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+				strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *CORS) methodAllowed(method string) bool {
+	return slices.Contains(c.allowedMethods, strings.ToUpper(method))
+}
+
+// headersAllowed returns the requested headers if all of them are allowed
+func (c *CORS) headersAllowed(requested string) ([]string, bool) {
+	var headers []string
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		header = http.CanonicalHeaderKey(header)
+		if !c.anyHeader && !slices.Contains(c.allowedHeaders, header) {
+			return nil, false
+		}
+		headers = append(headers, header)
+	}
+	return headers, true
+}
@@ -0,0 +1,225 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORS_Handle(t *testing.T) {
+	cfg := Config{
+		Enabled:        true,
+		AllowedOrigins: []string{"https://app.example.com", "https://*.preview.example.com"},
+		AllowedMethods: []string{"get", "POST"},
+		AllowedHeaders: []string{"content-type", "Authorization"},
+		MaxAge:         10 * time.Minute,
+	}
+
+	tests := []struct {
+		name        string
+		cfg         Config
+		method      string
+		headers     map[string]string
+		wantCalled  bool
+		wantHeaders map[string]string
+	}{
+		{
+			name:       "disabled does not add headers",
+			cfg:        Config{Enabled: false, AllowedOrigins: []string{"*"}},
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://app.example.com"},
+			wantCalled: true,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:       "requests from allowed origins receive CORS headers",
+			cfg:        cfg,
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://app.example.com"},
+			wantCalled: true,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "https://app.example.com",
+				"Vary":                        "Origin",
+			},
+		},
+		{
+			name:       "requests from wildcard subdomains receive CORS headers",
+			cfg:        cfg,
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://pr-123.preview.example.com"},
+			wantCalled: true,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "https://pr-123.preview.example.com",
+			},
+		},
+		{
+			name:       "requests from other origins are forwarded without CORS headers",
+			cfg:        cfg,
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://evil.com"},
+			wantCalled: true,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:   "preflight requests are answered without reaching the upstream",
+			cfg:    cfg,
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "content-type, authorization",
+			},
+			wantCalled: false,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Content-Type, Authorization",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:   "preflight requests for disallowed methods are rejected",
+			cfg:    cfg,
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			wantCalled: false,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+		{
+			name:   "preflight requests for disallowed headers are rejected",
+			cfg:    cfg,
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "X-Internal-User",
+			},
+			wantCalled: false,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:   "preflight requests from other origins are rejected",
+			cfg:    cfg,
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://preview.example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+			wantCalled: false,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:       "any origin",
+			cfg:        Config{Enabled: true, AllowedOrigins: []string{"*"}},
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://app.example.com"},
+			wantCalled: true,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "*",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+		{
+			name:       "allowed origins with credentials",
+			cfg:        Config{Enabled: true, AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true},
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://app.example.com"},
+			wantCalled: true,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name:   "any header",
+			cfg:    Config{Enabled: true, AllowedOrigins: []string{"*"}, AllowedMethods: []string{"POST"}, AllowedHeaders: []string{"*"}},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "x-custom",
+			},
+			wantCalled: false,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Headers": "X-Custom",
+				"Access-Control-Max-Age":       "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			c, err := NewCORS(tt.cfg)
+			require.NoError(t, err)
+			handler := c.Handle(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+				called = true
+			}))
+
+			r := httptest.NewRequest(tt.method, "/graphql", nil)
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantCalled, called)
+			if !tt.wantCalled {
+				assert.Equal(t, http.StatusNoContent, w.Code)
+			}
+			for key, value := range tt.wantHeaders {
+				assert.Equal(t, value, w.Header().Get(key), key)
+			}
+		})
+	}
+}
+
+func TestNewCORS(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr error
+	}{
+		{
+			name: "allows credentials for listed origins",
+			cfg:  Config{Enabled: true, AllowedOrigins: []string{"https://app.example.com", "https://*.example.com"}, AllowCredentials: true},
+		},
+		{
+			name: "allows any origin without credentials",
+			cfg:  Config{Enabled: true, AllowedOrigins: []string{"*"}},
+		},
+		{
+			name:    "rejects credentials for any origin",
+			cfg:     Config{Enabled: true, AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true},
+			wantErr: ErrWildcardWithCredentials,
+		},
+		{
+			name: "ignores the configuration when disabled",
+			cfg:  Config{Enabled: false, AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCORS(tt.cfg)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	circuitStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breaker per upstream. 0 is closed, 1 is open, 2 is half-open",
+	},
+		[]string{"upstream"},
+	)
+	circuitRejectedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "circuit_breaker_rejected_count",
+		Help:      "Amount of requests failed fast because the circuit breaker of the upstream was open",
+	},
+		[]string{"upstream"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(circuitStateGauge, circuitRejectedCounter)
+}
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitBreakerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Number of consecutive failed upstream requests after which the circuit opens
+	FailureThreshold int `yaml:"failure_threshold"`
+	// Time the circuit stays open before a single request is let through to probe the upstream
+	OpenDuration time.Duration `yaml:"open_duration"`
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker fails requests fast once an upstream keeps failing with connection errors or 5xx status codes
+type circuitBreaker struct {
+	upstream string
+	cfg      CircuitBreakerConfig
+	next     http.RoundTripper
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(upstream string, cfg CircuitBreakerConfig, next http.RoundTripper) *circuitBreaker {
+	circuitStateGauge.WithLabelValues(upstream).Set(float64(circuitClosed))
+
+	return &circuitBreaker{
+		upstream: upstream,
+		cfg:      cfg,
+		next:     next,
+	}
+}
+
+func (c *circuitBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !c.allow() {
+		circuitRejectedCounter.WithLabelValues(c.upstream).Inc()
+		return nil, ErrCircuitOpen
+	}
+
+	res, err := c.next.RoundTrip(req)
+
+	switch {
+	case err != nil && errors.Is(err, context.Canceled):
+		// the client went away, this says nothing about the health of the upstream
+		c.release()
+	case err != nil || res.StatusCode >= http.StatusInternalServerError:
+		c.failure()
+	default:
+		c.success()
+	}
+
+	return res, err
+}
+
+func (c *circuitBreaker) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case circuitOpen:
+		if time.Since(c.openedAt) < c.cfg.OpenDuration {
+			return false
+		}
+		c.setState(circuitHalfOpen)
+		c.probing = true
+		return true
+	case circuitHalfOpen:
+		// only a single probe is allowed while half-open
+		if c.probing {
+			return false
+		}
+		c.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (c *circuitBreaker) success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures = 0
+	c.probing = false
+	c.setState(circuitClosed)
+}
+
+func (c *circuitBreaker) failure() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures++
+	c.probing = false
+	if c.state == circuitHalfOpen || c.failures >= c.cfg.FailureThreshold {
+		c.openedAt = time.Now()
+		c.setState(circuitOpen)
+	}
+}
+
+func (c *circuitBreaker) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.probing = false
+}
+
+func (c *circuitBreaker) setState(state circuitState) {
+	c.state = state
+	circuitStateGauge.WithLabelValues(c.upstream).Set(float64(state))
+}
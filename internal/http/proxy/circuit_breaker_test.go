@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var fail bool
+	next := roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	breaker := newCircuitBreaker("test", CircuitBreakerConfig{Enabled: true, FailureThreshold: 2, OpenDuration: 50 * time.Millisecond}, next)
+	req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+
+	roundTrip := func() error {
+		res, err := breaker.RoundTrip(req)
+		if res != nil {
+			_ = res.Body.Close()
+		}
+		return err
+	}
+
+	fail = true
+	assert.Error(t, roundTrip())
+	assert.Equal(t, circuitClosed, breaker.state)
+	assert.Error(t, roundTrip())
+	assert.Equal(t, circuitOpen, breaker.state)
+
+	// fails fast while open, even when the upstream recovered
+	fail = false
+	assert.ErrorIs(t, roundTrip(), ErrCircuitOpen)
+
+	// after the open duration a probe is let through, which closes the circuit on success
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, roundTrip())
+	assert.Equal(t, circuitClosed, breaker.state)
+}
+
+func TestCircuitBreaker_FailedProbeReopens(t *testing.T) {
+	next := roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusBadGateway, Body: http.NoBody}, nil
+	})
+
+	breaker := newCircuitBreaker("test", CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, OpenDuration: 10 * time.Millisecond}, next)
+	req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+
+	res, err := breaker.RoundTrip(req)
+	assert.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, circuitOpen, breaker.state)
+
+	time.Sleep(20 * time.Millisecond)
+	res, err = breaker.RoundTrip(req)
+	assert.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, circuitOpen, breaker.state)
+
+	_, err = breaker.RoundTrip(req) // nolint:bodyclose
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+}
+
+func TestProxy_CircuitOpenRespondsWithGraphQLError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.Host = upstream.URL
+	cfg.CircuitBreaker = CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, OpenDuration: time.Minute}
+
+	proxy, err := NewProxy(cfg, nil, nil, nil, nil, false, nil)
+	assert.NoError(t, err)
+	defer proxy.Shutdown()
+
+	for _, want := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql", nil))
+
+		res := w.Result()
+		assert.Equal(t, want, res.StatusCode)
+		_ = res.Body.Close()
+	}
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql", nil))
+	res := w.Result()
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	var payload map[string]interface{}
+	assert.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.Equal(t, []interface{}{map[string]interface{}{"message": "upstream unavailable"}}, payload["errors"])
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+var ErrMissingHeaderValue = errors.New("injected header has no value")
+
+type HeadersConfig struct {
+	// Policy for headers of client requests forwarded to the upstream
+	Request HeaderPolicyConfig `yaml:"request"`
+	// Policy for headers of upstream responses returned to the client
+	Response HeaderPolicyConfig `yaml:"response"`
+}
+
+type HeaderPolicyConfig struct {
+	// Only these headers are forwarded. Empty forwards all headers.
+	Allow []string `yaml:"allow"`
+	// These headers are never forwarded
+	Deny []string `yaml:"deny"`
+	// Headers to rename, from the original name to the new name
+	Rename map[string]string `yaml:"rename"`
+	// Headers to add, replacing any value of the same header
+	Inject []HeaderInjectionConfig `yaml:"inject"`
+}
+
+type HeaderInjectionConfig struct {
+	Name string `yaml:"name"`
+	// Static value of the header
+	Value string `yaml:"value"`
+	// Name of the environment variable holding the value of the header, takes precedence over value
+	FromEnv string `yaml:"from_env"`
+}
+
+// headerPolicy filters, renames and injects headers.
+// Allow and deny lists are applied to the original header names, before renaming and injecting.
+type headerPolicy struct {
+	allow  map[string]bool
+	deny   map[string]bool
+	rename map[string]string
+	inject map[string]string
+}
+
+func newHeaderPolicy(cfg HeaderPolicyConfig) (*headerPolicy, error) {
+	p := &headerPolicy{
+		allow:  map[string]bool{},
+		deny:   map[string]bool{},
+		rename: map[string]string{},
+		inject: map[string]string{},
+	}
+
+	for _, name := range cfg.Allow {
+		p.allow[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range cfg.Deny {
+		p.deny[http.CanonicalHeaderKey(name)] = true
+	}
+	for from, to := range cfg.Rename {
+		p.rename[http.CanonicalHeaderKey(from)] = http.CanonicalHeaderKey(to)
+	}
+	for _, injection := range cfg.Inject {
+		value := injection.Value
+		if injection.FromEnv != "" {
+			env, ok := os.LookupEnv(injection.FromEnv)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s, environment variable %s is not set", ErrMissingHeaderValue, injection.Name, injection.FromEnv)
+			}
+			value = env
+		}
+		p.inject[http.CanonicalHeaderKey(injection.Name)] = value
+	}
+
+	return p, nil
+}
+
+func (p *headerPolicy) apply(header http.Header) {
+	for name := range header {
+		if p.deny[name] || (len(p.allow) > 0 && !p.allow[name]) {
+			header.Del(name)
+		}
+	}
+
+	for from, to := range p.rename {
+		values, ok := header[from]
+		if !ok {
+			continue
+		}
+		header.Del(from)
+		header[to] = values
+	}
+
+	for name, value := range p.inject {
+		header.Set(name, value)
+	}
+}
+
+type headerPolicies struct {
+	request  *headerPolicy
+	response *headerPolicy
+}
+
+func newHeaderPolicies(cfg HeadersConfig) (*headerPolicies, error) {
+	request, err := newHeaderPolicy(cfg.Request)
+	if err != nil {
+		return nil, fmt.Errorf("request headers: %w", err)
+	}
+	response, err := newHeaderPolicy(cfg.Response)
+	if err != nil {
+		return nil, fmt.Errorf("response headers: %w", err)
+	}
+
+	return &headerPolicies{
+		request:  request,
+		response: response,
+	}, nil
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderPolicy_Apply(t *testing.T) {
+	t.Setenv("TEST_API_KEY", "secret")
+
+	tests := []struct {
+		name   string
+		cfg    HeaderPolicyConfig
+		header http.Header
+		want   http.Header
+	}{
+		{
+			name:   "forwards everything without a policy",
+			cfg:    HeaderPolicyConfig{},
+			header: http.Header{"Authorization": {"Bearer foo"}, "X-Foo": {"bar"}},
+			want:   http.Header{"Authorization": {"Bearer foo"}, "X-Foo": {"bar"}},
+		},
+		{
+			name:   "removes denied headers",
+			cfg:    HeaderPolicyConfig{Deny: []string{"x-internal-user"}},
+			header: http.Header{"Authorization": {"Bearer foo"}, "X-Internal-User": {"admin"}},
+			want:   http.Header{"Authorization": {"Bearer foo"}},
+		},
+		{
+			name:   "only forwards allowed headers",
+			cfg:    HeaderPolicyConfig{Allow: []string{"Authorization", "Content-Type"}},
+			header: http.Header{"Authorization": {"Bearer foo"}, "Content-Type": {"application/json"}, "X-Foo": {"bar"}},
+			want:   http.Header{"Authorization": {"Bearer foo"}, "Content-Type": {"application/json"}},
+		},
+		{
+			name:   "deny takes precedence over allow",
+			cfg:    HeaderPolicyConfig{Allow: []string{"Authorization", "X-Foo"}, Deny: []string{"X-Foo"}},
+			header: http.Header{"Authorization": {"Bearer foo"}, "X-Foo": {"bar"}},
+			want:   http.Header{"Authorization": {"Bearer foo"}},
+		},
+		{
+			name:   "renames headers",
+			cfg:    HeaderPolicyConfig{Rename: map[string]string{"x-client-id": "x-consumer-id"}},
+			header: http.Header{"X-Client-Id": {"web"}},
+			want:   http.Header{"X-Consumer-Id": {"web"}},
+		},
+		{
+			name: "injects static values and values from the environment, replacing client values",
+			cfg: HeaderPolicyConfig{Inject: []HeaderInjectionConfig{
+				{Name: "X-Served-By", Value: "graphql-protect"},
+				{Name: "X-Api-Key", FromEnv: "TEST_API_KEY"},
+			}},
+			header: http.Header{"X-Api-Key": {"spoofed"}},
+			want:   http.Header{"X-Api-Key": {"secret"}, "X-Served-By": {"graphql-protect"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newHeaderPolicy(tt.cfg)
+			require.NoError(t, err)
+
+			policy.apply(tt.header)
+			assert.Equal(t, tt.want, tt.header)
+		})
+	}
+}
+
+func TestNewHeaderPolicy_MissingEnv(t *testing.T) {
+	_, err := newHeaderPolicy(HeaderPolicyConfig{Inject: []HeaderInjectionConfig{
+		{Name: "X-Api-Key", FromEnv: "TEST_UNSET_API_KEY"},
+	}})
+	assert.ErrorIs(t, err, ErrMissingHeaderValue)
+}
+
+func TestProxy_AppliesHeaderPolicies(t *testing.T) {
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Server", "graphql-server/1.2.3")
+		w.Header().Set("X-Powered-By", "graphql-server")
+		_, _ = w.Write([]byte(`{"data":{}}`))
+	}))
+	defer upstream.Close()
+
+	proxy, err := NewProxy(Config{
+		UpstreamConfig: UpstreamConfig{
+			Timeout:   time.Second,
+			KeepAlive: time.Second,
+			Host:      upstream.URL,
+		},
+		Headers: HeadersConfig{
+			Request: HeaderPolicyConfig{
+				Deny: []string{"X-Internal-User", "X-Forwarded-For"},
+			},
+			Response: HeaderPolicyConfig{
+				Deny: []string{"Server", "X-Powered-By"},
+			},
+		},
+	}, nil, nil, nil, nil, false, nil)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ __typename }"}`))
+	r.Header.Set("X-Internal-User", "admin")
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	r.Header.Set("Authorization", "Bearer foo")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, received.Get("X-Internal-User"))
+	assert.Equal(t, "Bearer foo", received.Get("Authorization"))
+	// the spoofed address is dropped, only the address of the client is forwarded
+	assert.Equal(t, "192.0.2.1", received.Get("X-Forwarded-For"))
+	assert.Empty(t, w.Header().Get("Server"))
+	assert.Empty(t, w.Header().Get("X-Powered-By"))
+}
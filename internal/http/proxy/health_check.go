@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	healthCheckCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "health_check_count",
+		Help:      "Results of the active health checks of upstreams",
+	},
+		[]string{"upstream", "result"},
+	)
+	upstreamHealthyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "upstream_healthy",
+		Help:      "Whether the upstream is considered healthy by the active health checks. 1 is healthy, 0 is unhealthy",
+	},
+		[]string{"upstream"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(healthCheckCounter, upstreamHealthyGauge)
+}
+
+var ErrUpstreamUnhealthy = errors.New("upstream is unhealthy")
+
+const healthCheckQuery = `{"query":"query HealthCheck { __typename }"}`
+
+type HealthCheckConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval between health checks
+	Interval time.Duration `yaml:"interval"`
+	// Timeout of a single health check
+	Timeout time.Duration `yaml:"timeout"`
+	// Path to send the health check query to, defaults to the path of the upstream host
+	Path string `yaml:"path"`
+	// Number of consecutive failed health checks after which the upstream is considered unhealthy
+	UnhealthyThreshold int `yaml:"unhealthy_threshold"`
+}
+
+// UpstreamHealth is the result of the active health checks of an upstream
+type UpstreamHealth struct {
+	Healthy   bool
+	LastCheck time.Time
+}
+
+// healthCheck periodically sends a `{ __typename }` query to the upstream.
+// Requests fail fast while the upstream is considered unhealthy.
+type healthCheck struct {
+	upstream string
+	cfg      HealthCheckConfig
+	target   string
+	client   *http.Client
+	log      *slog.Logger
+
+	healthy   atomic.Bool
+	lastCheck atomic.Pointer[time.Time]
+	failures  int
+	done      chan bool
+}
+
+func newHealthCheck(upstream string, cfg HealthCheckConfig, target *url.URL, transport http.RoundTripper, log *slog.Logger) *healthCheck {
+	checkURL := *target
+	if cfg.Path != "" {
+		checkURL.Path = cfg.Path
+	}
+
+	h := &healthCheck{
+		upstream: upstream,
+		cfg:      cfg,
+		target:   checkURL.String(),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+		},
+		log:  log,
+		done: make(chan bool, 1),
+	}
+	h.setHealthy(true)
+
+	return h
+}
+
+func (h *healthCheck) start() {
+	go func() {
+		ticker := time.NewTicker(h.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.done:
+				return
+			case <-ticker.C:
+				h.check()
+			}
+		}
+	}()
+}
+
+func (h *healthCheck) check() {
+	err := h.probe()
+	now := time.Now()
+	h.lastCheck.Store(&now)
+	if err == nil {
+		healthCheckCounter.WithLabelValues(h.upstream, "success").Inc()
+		h.failures = 0
+		h.setHealthy(true)
+		return
+	}
+
+	healthCheckCounter.WithLabelValues(h.upstream, "failure").Inc()
+	h.failures++
+	if h.failures >= h.cfg.UnhealthyThreshold && h.healthy.Load() {
+		if h.log != nil {
+			h.log.Warn("Upstream marked unhealthy", "upstream", h.upstream, "err", err)
+		}
+		h.setHealthy(false)
+	}
+}
+
+var errUnhealthyStatus = errors.New("health check returned unhealthy status code")
+
+func (h *healthCheck) probe() error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, h.target, bytes.NewBufferString(healthCheckQuery))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errUnhealthyStatus
+	}
+	return nil
+}
+
+func (h *healthCheck) setHealthy(healthy bool) {
+	h.healthy.Store(healthy)
+	value := 0.0
+	if healthy {
+		value = 1.0
+	}
+	upstreamHealthyGauge.WithLabelValues(h.upstream).Set(value)
+}
+
+// status returns whether the upstream is considered healthy, and the time it was last checked
+func (h *healthCheck) status() UpstreamHealth {
+	health := UpstreamHealth{
+		Healthy: h.healthy.Load(),
+	}
+	if lastCheck := h.lastCheck.Load(); lastCheck != nil {
+		health.LastCheck = *lastCheck
+	}
+	return health
+}
+
+func (h *healthCheck) shutdown() {
+	h.done <- true
+}
+
+// gate returns a RoundTripper that fails fast while the upstream is unhealthy
+func (h *healthCheck) gate(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !h.healthy.Load() {
+			return nil, ErrUpstreamUnhealthy
+		}
+		return next.RoundTrip(req)
+	})
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
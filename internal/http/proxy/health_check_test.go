@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var healthy = true
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/health", r.URL.Path)
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"__typename":"Query"}}`))
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	check := newHealthCheck("test", HealthCheckConfig{Enabled: true, Timeout: time.Second, Path: "/health", UnhealthyThreshold: 2}, target, http.DefaultTransport, nil)
+
+	gated := check.gate(roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	assert.True(t, check.status().LastCheck.IsZero(), "not checked yet")
+
+	check.check()
+	assert.True(t, check.healthy.Load())
+
+	healthy = false
+	check.check()
+	assert.True(t, check.healthy.Load(), "a single failure stays below the threshold")
+	check.check()
+	assert.False(t, check.healthy.Load())
+	assert.False(t, check.status().Healthy)
+	assert.False(t, check.status().LastCheck.IsZero())
+
+	_, err := gated.RoundTrip(req) // nolint:bodyclose
+	assert.ErrorIs(t, err, ErrUpstreamUnhealthy)
+
+	healthy = true
+	check.check()
+	assert.True(t, check.healthy.Load())
+
+	res, err := gated.RoundTrip(req)
+	assert.NoError(t, err)
+	_ = res.Body.Close()
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	upstreamGraphqlErrorCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "upstream_graphql_error_count",
+		Help:      "Amount of GraphQL errors returned by the upstream, by operation, error code and status code",
+	},
+		[]string{"operationName", "code", "status"},
+	)
+	upstreamResponseSizeHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "upstream_response_size_bytes",
+		Help:      "Size of the upstream response bodies in bytes, by status code",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+	},
+		[]string{"status"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(upstreamGraphqlErrorCounter, upstreamResponseSizeHistogram)
+}
+
+// otherOperations labels operations that can't be labeled with their name without risking unbounded cardinality
+const otherOperations = "other"
+
+type MetricsConfig struct {
+	// Operation names to label upstream error metrics with, other operations are labeled `other`
+	Operations []string `yaml:"operations"`
+	// Label operations resolved from trusted documents with their name, as these are bounded by the trusted documents
+	IncludeTrustedDocuments bool `yaml:"include_trusted_documents"`
+}
+
+type responseMetrics struct {
+	cfg MetricsConfig
+}
+
+func newResponseMetrics(cfg MetricsConfig) *responseMetrics {
+	return &responseMetrics{
+		cfg: cfg,
+	}
+}
+
+func (m *responseMetrics) observeSize(status int, size int) {
+	upstreamResponseSizeHistogram.WithLabelValues(strconv.Itoa(status)).Observe(float64(size))
+}
+
+// observeErrors counts the GraphQL errors of a single response to the operation
+func (m *responseMetrics) observeErrors(info *gql.RequestInfo, operation *gql.Operation, status int, response map[string]interface{}) {
+	errs, ok := response["errors"].([]interface{})
+	if !ok || len(errs) == 0 {
+		return
+	}
+
+	operationName := m.operationLabel(info, operation)
+	for _, err := range errs {
+		var code string
+		if e, ok := err.(map[string]interface{}); ok {
+			if extensions, ok := e["extensions"].(map[string]interface{}); ok {
+				code, _ = extensions["code"].(string)
+			}
+		}
+		upstreamGraphqlErrorCounter.WithLabelValues(operationName, code, strconv.Itoa(status)).Inc()
+	}
+}
+
+func (m *responseMetrics) operationLabel(info *gql.RequestInfo, operation *gql.Operation) string {
+	if operation == nil {
+		return ""
+	}
+	if slices.Contains(m.cfg.Operations, operation.Name) {
+		return operation.Name
+	}
+	if m.cfg.IncludeTrustedDocuments && info != nil && info.TrustedDocuments {
+		return operation.Name
+	}
+	return otherOperations
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseMetrics_OperationLabel(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       MetricsConfig
+		info      *gql.RequestInfo
+		operation *gql.Operation
+		want      string
+	}{
+		{
+			name:      "empty without a known operation",
+			cfg:       MetricsConfig{Operations: []string{"Products"}},
+			info:      nil,
+			operation: nil,
+			want:      "",
+		},
+		{
+			name:      "allowlisted operations are labeled with their name",
+			cfg:       MetricsConfig{Operations: []string{"Products"}},
+			info:      &gql.RequestInfo{},
+			operation: &gql.Operation{Name: "Products"},
+			want:      "Products",
+		},
+		{
+			name:      "other operations are grouped",
+			cfg:       MetricsConfig{Operations: []string{"Products"}},
+			info:      &gql.RequestInfo{},
+			operation: &gql.Operation{Name: "Random123"},
+			want:      "other",
+		},
+		{
+			name:      "operations from trusted documents are labeled with their name when enabled",
+			cfg:       MetricsConfig{IncludeTrustedDocuments: true},
+			info:      &gql.RequestInfo{TrustedDocuments: true},
+			operation: &gql.Operation{Name: "Checkout"},
+			want:      "Checkout",
+		},
+		{
+			name:      "operations from trusted documents are grouped when disabled",
+			cfg:       MetricsConfig{IncludeTrustedDocuments: false},
+			info:      &gql.RequestInfo{TrustedDocuments: true},
+			operation: &gql.Operation{Name: "Checkout"},
+			want:      "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, newResponseMetrics(tt.cfg).operationLabel(tt.info, tt.operation))
+		})
+	}
+}
+
+func Test_modifyResponse_RecordsMetrics(t *testing.T) {
+	metrics := newResponseMetrics(MetricsConfig{Operations: []string{"Checkout"}})
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	r = r.WithContext(gql.WithRequestInfo(r.Context(), &gql.RequestInfo{Operations: []gql.Operation{{Name: "Checkout"}}}))
+	body := `{"data":null,"errors":[{"message":"out of stock","extensions":{"code":"OUT_OF_STOCK"}},{"message":"out of stock","extensions":{"code":"OUT_OF_STOCK"}},{"message":"boom"}]}`
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     map[string][]string{},
+		Request:    r,
+	}
+
+	coded := testutil.ToFloat64(upstreamGraphqlErrorCounter.WithLabelValues("Checkout", "OUT_OF_STOCK", "200"))
+	uncoded := testutil.ToFloat64(upstreamGraphqlErrorCounter.WithLabelValues("Checkout", "", "200"))
+	sizes := testutil.CollectAndCount(upstreamResponseSizeHistogram)
+
+	err := modifyResponse(nil, nil, nil, nil, metrics, false, nil)(res) // nolint:bodyclose
+	require.NoError(t, err)
+
+	assert.InDelta(t, coded+2, testutil.ToFloat64(upstreamGraphqlErrorCounter.WithLabelValues("Checkout", "OUT_OF_STOCK", "200")), 0)
+	assert.InDelta(t, uncoded+1, testutil.ToFloat64(upstreamGraphqlErrorCounter.WithLabelValues("Checkout", "", "200")), 0)
+	assert.GreaterOrEqual(t, testutil.CollectAndCount(upstreamResponseSizeHistogram), max(sizes, 1))
+}
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const mirrorUpstream = "mirror"
+
+var (
+	mirrorRequestCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "mirror",
+		Name:      "request_count",
+		Help:      "Amount of requests sampled for mirroring to the shadow upstream",
+	},
+		[]string{"result"},
+	)
+	mirrorComparisonCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "mirror",
+		Name:      "comparison_count",
+		Help:      "Comparison of the responses of the primary and the shadow upstream, by status code of each and the amount of GraphQL errors of the shadow upstream relative to the primary",
+	},
+		[]string{"primary_status", "mirror_status", "errors"},
+	)
+	mirrorLatencyHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "mirror",
+		Name:      "latency_seconds",
+		Help:      "Latency of mirrored requests, for the primary and the shadow upstream",
+		Buckets:   prometheus.DefBuckets,
+	},
+		[]string{"upstream"},
+	)
+	mirrorLatencyDifferenceHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "mirror",
+		Name:      "latency_difference_seconds",
+		Help:      "Latency of the shadow upstream minus the latency of the primary upstream. Positive values mean the shadow upstream is slower.",
+		Buckets:   []float64{-2.5, -1, -0.5, -0.25, -0.1, -0.05, -0.01, 0, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+	})
+)
+
+func init() {
+	prometheus.MustRegister(mirrorRequestCounter, mirrorComparisonCounter, mirrorLatencyHistogram, mirrorLatencyDifferenceHistogram)
+}
+
+type MirrorConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// The shadow upstream. Unset timeouts are inherited from the default upstream.
+	UpstreamConfig `yaml:",inline"`
+	// Percentage of requests to mirror, between 0 and 100
+	Percentage float64 `yaml:"percentage"`
+	// Mirror requests containing mutations. Only enable this when the shadow upstream doesn't share any state with the primary upstream.
+	IncludeMutations bool `yaml:"include_mutations"`
+	// Maximum time to wait for a response of the shadow upstream
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// Maximum number of mirrored requests in flight, sampled requests exceeding this are not mirrored
+	MaxConcurrent int `yaml:"max_concurrent"`
+}
+
+// mirror asynchronously sends a copy of sampled requests to a shadow upstream.
+// Responses of the shadow upstream are discarded, only the differences with the primary upstream are recorded.
+type mirror struct {
+	cfg       MirrorConfig
+	upstream  *upstream
+	semaphore chan struct{}
+}
+
+func newMirror(cfg MirrorConfig, u *upstream) *mirror {
+	return &mirror{
+		cfg:       cfg,
+		upstream:  u,
+		semaphore: make(chan struct{}, cfg.MaxConcurrent),
+	}
+}
+
+func (m *mirror) sample(r *http.Request) bool {
+	info := gql.RequestInfoFromContext(r.Context())
+	if info == nil || len(info.Operations) == 0 {
+		return false
+	}
+	// uploads are streamed to the primary upstream, buffering them to be mirrored would keep entire files in memory
+	if gql.IsMultipart(r) {
+		return false
+	}
+	if !m.cfg.IncludeMutations && !info.OnlyQueries() {
+		return false
+	}
+	return rand.Float64()*100 < m.cfg.Percentage // nolint:gosec
+}
+
+// serve forwards the request to the primary upstream, while mirroring it to the shadow upstream
+func (m *mirror) serve(w http.ResponseWriter, r *http.Request, primary *upstream) {
+	select {
+	case m.semaphore <- struct{}{}:
+	default:
+		mirrorRequestCounter.WithLabelValues("dropped").Inc()
+		primary.handler.ServeHTTP(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		// a partial body is never proxied, the error is reported as if the primary upstream failed to read it
+		<-m.semaphore
+		mirrorRequestCounter.WithLabelValues("failed").Inc()
+		errorHandler(primary.name)(w, r, err)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	// the shadow request should not be cancelled when the client goes away
+	shadow := r.Clone(context.WithoutCancel(r.Context()))
+	shadow.Body = io.NopCloser(bytes.NewReader(body))
+
+	primaryResult := make(chan observation, 1)
+	go m.shadow(shadow, primaryResult)
+
+	start := time.Now()
+	rec := newObservingWriter(w)
+	completed := false
+	// the shadow request waits for the primary result, so it must be released when the primary request panics as well
+	defer func() {
+		if !completed {
+			close(primaryResult)
+			return
+		}
+		primaryResult <- rec.observation(time.Since(start))
+	}()
+	primary.handler.ServeHTTP(rec, r)
+	completed = true
+}
+
+func (m *mirror) shadow(r *http.Request, primaryResult chan observation) {
+	defer func() {
+		<-m.semaphore
+	}()
+
+	ctx, cancel := context.WithTimeout(r.Context(), m.cfg.RequestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	rec := newObservingWriter(nil)
+	m.upstream.handler.ServeHTTP(rec, r.WithContext(ctx))
+	mirrored := rec.observation(time.Since(start))
+
+	if ctx.Err() != nil {
+		mirrorRequestCounter.WithLabelValues("timeout").Inc()
+		return
+	}
+	mirrorRequestCounter.WithLabelValues("mirrored").Inc()
+
+	// a partial response of an aborted primary request is not compared
+	if primary, ok := <-primaryResult; ok {
+		compare(primary, mirrored)
+	}
+}
+
+func compare(primary observation, mirrored observation) {
+	mirrorLatencyHistogram.WithLabelValues("primary").Observe(primary.latency.Seconds())
+	mirrorLatencyHistogram.WithLabelValues(mirrorUpstream).Observe(mirrored.latency.Seconds())
+	mirrorLatencyDifferenceHistogram.Observe((mirrored.latency - primary.latency).Seconds())
+
+	errorCount := "match"
+	switch {
+	case mirrored.errors > primary.errors:
+		errorCount = "more"
+	case mirrored.errors < primary.errors:
+		errorCount = "fewer"
+	}
+	mirrorComparisonCounter.WithLabelValues(strconv.Itoa(primary.status), strconv.Itoa(mirrored.status), errorCount).Inc()
+}
+
+type observation struct {
+	status  int
+	errors  int
+	latency time.Duration
+}
+
+// observingWriter keeps a copy of the response to compare it, passing it on to the underlying writer if there is one
+type observingWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newObservingWriter(w http.ResponseWriter) *observingWriter {
+	return &observingWriter{
+		w:      w,
+		header: http.Header{},
+	}
+}
+
+func (o *observingWriter) Header() http.Header {
+	if o.w != nil {
+		return o.w.Header()
+	}
+	return o.header
+}
+
+func (o *observingWriter) WriteHeader(status int) {
+	if o.status == 0 {
+		o.status = status
+	}
+	if o.w != nil {
+		o.w.WriteHeader(status)
+	}
+}
+
+func (o *observingWriter) Write(bts []byte) (int, error) {
+	if o.status == 0 {
+		o.status = http.StatusOK
+	}
+	o.body.Write(bts)
+	if o.w != nil {
+		return o.w.Write(bts)
+	}
+	return len(bts), nil
+}
+
+func (o *observingWriter) Unwrap() http.ResponseWriter {
+	return o.w
+}
+
+func (o *observingWriter) observation(latency time.Duration) observation {
+	var response struct {
+		Errors []json.RawMessage `json:"errors"`
+	}
+	_ = json.Unmarshal(o.body.Bytes(), &response)
+
+	status := o.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return observation{
+		status:  status,
+		errors:  len(response.Errors),
+		latency: latency,
+	}
+}
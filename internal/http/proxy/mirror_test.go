@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestProxy_Mirror(t *testing.T) {
+	tests := []struct {
+		name             string
+		operationType    ast.Operation
+		contentType      string
+		percentage       float64
+		includeMutations bool
+		wantMirrored     int32
+	}{
+		{
+			name:          "mirrors sampled queries",
+			operationType: ast.Query,
+			percentage:    100,
+			wantMirrored:  1,
+		},
+		{
+			name:          "does not mirror requests that aren't sampled",
+			operationType: ast.Query,
+			percentage:    0,
+			wantMirrored:  0,
+		},
+		{
+			name:          "does not mirror mutations by default",
+			operationType: ast.Mutation,
+			percentage:    100,
+			wantMirrored:  0,
+		},
+		{
+			name:             "mirrors mutations when enabled",
+			operationType:    ast.Mutation,
+			percentage:       100,
+			includeMutations: true,
+			wantMirrored:     1,
+		},
+		{
+			name:             "does not mirror multipart requests",
+			operationType:    ast.Mutation,
+			contentType:      "multipart/form-data; boundary=upload",
+			percentage:       100,
+			includeMutations: true,
+			wantMirrored:     0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(`{"data":{"foo":"bar"}}`))
+			}))
+			defer primary.Close()
+
+			var mirrored atomic.Int32
+			received := make(chan string, 1)
+			shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				mirrored.Add(1)
+				received <- string(body)
+				_, _ = w.Write([]byte(`{"data":null,"errors":[{"message":"not implemented"}]}`))
+			}))
+			defer shadow.Close()
+
+			mirror := DefaultConfig().Mirror
+			mirror.Enabled = true
+			mirror.Host = shadow.URL
+			mirror.Percentage = tt.percentage
+			mirror.IncludeMutations = tt.includeMutations
+
+			proxy, err := NewProxy(Config{
+				UpstreamConfig: UpstreamConfig{
+					Timeout:   time.Second,
+					KeepAlive: time.Second,
+					Host:      primary.URL,
+				},
+				Mirror: mirror,
+			}, nil, nil, nil, nil, false, nil)
+			require.NoError(t, err)
+			defer proxy.Shutdown()
+
+			mismatches := testutil.ToFloat64(mirrorComparisonCounter.WithLabelValues("200", "200", "more"))
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ foo }"}`))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			info := &gql.RequestInfo{Operations: []gql.Operation{{Type: tt.operationType}}, OperationCount: 1}
+			r = r.WithContext(gql.WithRequestInfo(r.Context(), info))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, `{"data":{"foo":"bar"}}`, w.Body.String())
+
+			if tt.wantMirrored == 0 {
+				// give an unexpected mirrored request the chance to arrive
+				time.Sleep(50 * time.Millisecond)
+				assert.Equal(t, int32(0), mirrored.Load())
+				return
+			}
+
+			select {
+			case body := <-received:
+				assert.Equal(t, `{"query":"{ foo }"}`, body)
+			case <-time.After(time.Second):
+				t.Fatal("request was not mirrored")
+			}
+			assert.Eventually(t, func() bool {
+				return testutil.ToFloat64(mirrorComparisonCounter.WithLabelValues("200", "200", "more")) == mismatches+1
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestMirror_PrimaryPanic(t *testing.T) {
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"foo":"bar"}}`))
+	}))
+	defer shadow.Close()
+
+	mirror := DefaultConfig().Mirror
+	mirror.Enabled = true
+	mirror.Host = shadow.URL
+	mirror.Percentage = 100
+	mirror.MaxConcurrent = 1
+
+	proxy, err := NewProxy(Config{
+		UpstreamConfig: UpstreamConfig{
+			Timeout:   time.Second,
+			KeepAlive: time.Second,
+			Host:      shadow.URL,
+		},
+		Mirror: mirror,
+	}, nil, nil, nil, nil, false, nil)
+	require.NoError(t, err)
+	defer proxy.Shutdown()
+
+	aborting := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"data":`))
+		panic(http.ErrAbortHandler)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ foo }"}`))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		proxy.mirror.serve(httptest.NewRecorder(), r, &upstream{name: defaultUpstream, handler: aborting})
+	})
+
+	// the shadow request releases its slot, instead of waiting for the primary result forever
+	assert.Eventually(t, func() bool {
+		return len(proxy.mirror.semaphore) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMirror_UnreadableBody(t *testing.T) {
+	var received atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		received.Add(1)
+		_, _ = w.Write([]byte(`{"data":{"foo":"bar"}}`))
+	}))
+	defer upstream.Close()
+
+	mirror := DefaultConfig().Mirror
+	mirror.Enabled = true
+	mirror.Host = upstream.URL
+	mirror.Percentage = 100
+
+	proxy, err := NewProxy(Config{
+		UpstreamConfig: UpstreamConfig{
+			Timeout:   time.Second,
+			KeepAlive: time.Second,
+			Host:      upstream.URL,
+		},
+		Mirror: mirror,
+	}, nil, nil, nil, nil, false, nil)
+	require.NoError(t, err)
+	defer proxy.Shutdown()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ foo }"}`))
+	r.Body = http.MaxBytesReader(w, r.Body, 5)
+	info := &gql.RequestInfo{Operations: []gql.Operation{{Type: ast.Query}}, OperationCount: 1}
+	r = r.WithContext(gql.WithRequestInfo(r.Context(), info))
+	proxy.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.JSONEq(t, `{"errors":[{"message":"http: request body too large"}]}`, w.Body.String())
+
+	// the truncated body is sent to neither upstream
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), received.Load())
+	assert.Empty(t, proxy.mirror.semaphore)
+}
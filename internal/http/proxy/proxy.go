@@ -2,8 +2,14 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/block_field_suggestions"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/field_masking"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/max_response"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/obfuscate_upstream_errors"
 	"io"
 	"log/slog"
@@ -12,64 +18,477 @@ import (
 	"net/url"
 	"strconv"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	routedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "routed_count",
+		Help:      "Amount of requests routed to each upstream",
+	},
+		[]string{"upstream"},
+	)
+	upstreamErrorCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "upstream_error_count",
+		Help:      "Amount of requests that could not be proxied to the upstream, by the status code returned to the client",
+	},
+		[]string{"upstream", "status"},
+	)
 )
 
+func init() {
+	prometheus.MustRegister(routedCounter, upstreamErrorCounter)
+}
+
+const defaultUpstream = "default"
+
+var ErrUnknownUpstream = errors.New("route references an unknown upstream")
+var ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
 type Config struct {
+	// The default upstream, used for any request that doesn't match one of the routes
+	UpstreamConfig `yaml:",inline"`
+	Tracing        TracingConfig `yaml:"tracing"`
+	// Additional named upstreams that requests can be routed to, unset settings are inherited from the default upstream
+	Upstreams map[string]UpstreamConfig `yaml:"upstreams"`
+	// Routes are evaluated in order, the first matching route decides the upstream
+	Routes []RouteConfig `yaml:"routes"`
+	// Policy for the headers forwarded between clients and upstreams
+	Headers HeadersConfig `yaml:"headers"`
+	// Mirror a sample of the requests to a shadow upstream
+	Mirror MirrorConfig `yaml:"mirror"`
+	// Time the upstream may take to respond, by operation
+	RequestTimeout RequestTimeoutConfig `yaml:"request_timeout"`
+	// Metrics about the responses of the upstreams
+	Metrics MetricsConfig `yaml:"metrics"`
+}
+
+type UpstreamConfig struct {
 	Timeout   time.Duration `yaml:"timeout"`
 	KeepAlive time.Duration `yaml:"keep_alive"`
-	Host      string        `yaml:"host"`
-	Tracing   TracingConfig `yaml:"tracing"`
+	// Maximum idle connections across all hosts, 0 means no limit
+	MaxIdleConns int `yaml:"max_idle_conns"`
+	// Maximum idle connections kept per host
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host"`
+	// Time after which an idle connection is closed, 0 means no limit
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout"`
+	// Time to wait for the response headers after sending the request, 0 means no limit
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	// Time to wait for a TLS handshake, 0 means no limit
+	TLSHandshakeTimeout time.Duration        `yaml:"tls_handshake_timeout"`
+	HTTP2               HTTP2Config          `yaml:"http2"`
+	Host                string               `yaml:"host"`
+	Retry               RetryConfig          `yaml:"retry"`
+	HealthCheck         HealthCheckConfig    `yaml:"health_check"`
+	CircuitBreaker      CircuitBreakerConfig `yaml:"circuit_breaker"`
+	TLS                 TLSConfig            `yaml:"tls"`
 }
 
 func DefaultConfig() Config {
 	return Config{
-		Timeout:   10 * time.Second,
-		KeepAlive: 3 * time.Minute,
-		Host:      "http://localhost:8081",
+		UpstreamConfig: UpstreamConfig{
+			Timeout:               10 * time.Second,
+			KeepAlive:             3 * time.Minute,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   100,
+			IdleConnTimeout:       90 * time.Second,
+			ResponseHeaderTimeout: 0,
+			TLSHandshakeTimeout:   10 * time.Second,
+			HTTP2: HTTP2Config{
+				Enabled:         true,
+				H2C:             false,
+				ReadIdleTimeout: 0,
+				PingTimeout:     15 * time.Second,
+			},
+			Host: "http://localhost:8081",
+			Retry: RetryConfig{
+				Enabled:     false,
+				MaxAttempts: 3,
+				Backoff:     100 * time.Millisecond,
+			},
+			HealthCheck: HealthCheckConfig{
+				Enabled:            false,
+				Interval:           10 * time.Second,
+				Timeout:            2 * time.Second,
+				UnhealthyThreshold: 3,
+			},
+			CircuitBreaker: CircuitBreakerConfig{
+				Enabled:          false,
+				FailureThreshold: 5,
+				OpenDuration:     30 * time.Second,
+			},
+			TLS: TLSConfig{
+				MinVersion: "1.2",
+				AutoReload: TLSReloadConfig{
+					Enabled:  false,
+					Interval: 30 * time.Second,
+				},
+			},
+		},
 		Tracing: TracingConfig{
 			RedactedHeaders: nil,
 		},
+		Upstreams: map[string]UpstreamConfig{},
+		Routes:    []RouteConfig{},
+		Headers: HeadersConfig{
+			Request: HeaderPolicyConfig{
+				Allow:  []string{},
+				Deny:   []string{},
+				Rename: map[string]string{},
+				Inject: []HeaderInjectionConfig{},
+			},
+			Response: HeaderPolicyConfig{
+				Allow:  []string{},
+				Deny:   []string{},
+				Rename: map[string]string{},
+				Inject: []HeaderInjectionConfig{},
+			},
+		},
+		Mirror: MirrorConfig{
+			Enabled:          false,
+			Percentage:       0,
+			IncludeMutations: false,
+			RequestTimeout:   10 * time.Second,
+			MaxConcurrent:    100,
+		},
+		RequestTimeout: RequestTimeoutConfig{
+			Default:        0,
+			OperationTypes: map[string]time.Duration{},
+			Operations:     map[string]time.Duration{},
+			DeadlineHeader: "",
+		},
+		Metrics: MetricsConfig{
+			Operations:              []string{},
+			IncludeTrustedDocuments: false,
+		},
 	}
 }
 
+// UnmarshalYAML decodes the named upstreams and the mirror on top of the settings they inherit from the default upstream,
+// so a setting configured for them always wins, even when it's a zero value such as a disabled HTTP/2
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
+	type plain Config
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+
+	var overrides struct {
+		Upstreams map[string]yaml.Node `yaml:"upstreams"`
+		Mirror    yaml.Node            `yaml:"mirror"`
+	}
+	if err := value.Decode(&overrides); err != nil {
+		return err
+	}
+
+	for name, node := range overrides.Upstreams {
+		upstream := c.UpstreamConfig.inherited()
+		if err := node.Decode(&upstream); err != nil {
+			return err
+		}
+		c.Upstreams[name] = upstream
+	}
+
+	mirror := c.Mirror
+	mirror.UpstreamConfig = c.UpstreamConfig.inherited()
+	if !overrides.Mirror.IsZero() {
+		if err := overrides.Mirror.Decode(&mirror); err != nil {
+			return err
+		}
+	}
+	c.Mirror = mirror
+
+	return nil
+}
+
 type TracingConfig struct {
 	RedactedHeaders []string `yaml:"redacted_headers"`
 }
 
-func NewProxy(cfg Config, blockFieldSuggestions *block_field_suggestions.BlockFieldSuggestionsHandler, obfuscateUpstreamErrors *obfuscate_upstream_errors.ObfuscateUpstreamErrors, logGraphqlErrors bool, log *slog.Logger) (*httputil.ReverseProxy, error) {
+// Proxy forwards requests to the upstream selected by the configured routes
+type Proxy struct {
+	routes    []*route
+	fallback  *upstream
+	upstreams map[string]*upstream
+	mirror    *mirror
+	timeouts  *requestTimeouts
+}
+
+type upstream struct {
+	name        string
+	handler     http.Handler
+	healthCheck *healthCheck
+	credentials *tlsCredentials
+}
+
+func NewProxy(cfg Config, blockFieldSuggestions *block_field_suggestions.BlockFieldSuggestionsHandler, obfuscateUpstreamErrors *obfuscate_upstream_errors.ObfuscateUpstreamErrors, maxResponse *max_response.MaxResponseRule, fieldMasking *field_masking.FieldMasking, logGraphqlErrors bool, log *slog.Logger) (*Proxy, error) {
+	modify := modifyResponse(blockFieldSuggestions, obfuscateUpstreamErrors, maxResponse, fieldMasking, newResponseMetrics(cfg.Metrics), logGraphqlErrors, log) // nolint:bodyclose
+
+	headers, err := newHeaderPolicies(cfg.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := newRequestTimeouts(cfg.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	fallback, err := newUpstream(defaultUpstream, cfg.UpstreamConfig, cfg.Tracing, headers, cfg.RequestTimeout.DeadlineHeader, modify, log)
+	if err != nil {
+		return nil, err
+	}
+
+	upstreams := map[string]*upstream{
+		defaultUpstream: fallback,
+	}
+	for name, upstreamCfg := range cfg.Upstreams {
+		u, err := newUpstream(name, upstreamCfg, cfg.Tracing, headers, cfg.RequestTimeout.DeadlineHeader, modify, log)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %s: %w", name, err)
+		}
+		upstreams[name] = u
+	}
+
+	routes := make([]*route, 0, len(cfg.Routes))
+	for i, routeCfg := range cfg.Routes {
+		u, ok := upstreams[routeCfg.Upstream]
+		if !ok {
+			return nil, fmt.Errorf("route %d: %w: %s", i, ErrUnknownUpstream, routeCfg.Upstream)
+		}
+		r, err := newRoute(routeCfg.Match, u)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+		routes = append(routes, r)
+	}
+
+	var shadow *mirror
+	if cfg.Mirror.Enabled {
+		// responses of the mirror are left out of the response metrics, as they're never returned to clients
+		modifyMirror := modifyResponse(blockFieldSuggestions, obfuscateUpstreamErrors, maxResponse, fieldMasking, nil, logGraphqlErrors, log) // nolint:bodyclose
+		u, err := newUpstream(mirrorUpstream, cfg.Mirror.UpstreamConfig, cfg.Tracing, headers, cfg.RequestTimeout.DeadlineHeader, modifyMirror, log)
+		if err != nil {
+			return nil, fmt.Errorf("mirror: %w", err)
+		}
+		shadow = newMirror(cfg.Mirror, u)
+		shadow.upstream.start()
+	}
+
+	for _, u := range upstreams {
+		u.start()
+	}
+
+	return &Proxy{
+		routes:    routes,
+		fallback:  fallback,
+		upstreams: upstreams,
+		mirror:    shadow,
+		timeouts:  timeouts,
+	}, nil
+}
+
+// Shutdown stops any background processes of the upstreams, such as health checks and certificate reloading
+func (p *Proxy) Shutdown() {
+	for _, u := range p.upstreams {
+		u.shutdown()
+	}
+	if p.mirror != nil {
+		p.mirror.upstream.shutdown()
+	}
+}
+
+// UpstreamHealth returns the health of the upstreams that have active health checks enabled, by name
+func (p *Proxy) UpstreamHealth() map[string]UpstreamHealth {
+	health := map[string]UpstreamHealth{}
+	for name, u := range p.upstreams {
+		if u.healthCheck != nil {
+			health[name] = u.healthCheck.status()
+		}
+	}
+	return health
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := p.selectUpstream(r)
+	routedCounter.WithLabelValues(u.name).Inc()
+
+	r, cancel := p.timeouts.handle(r)
+	defer cancel()
+
+	if p.mirror != nil && p.mirror.sample(r) {
+		p.mirror.serve(w, r, u)
+		return
+	}
+	u.handler.ServeHTTP(w, r)
+}
+
+func (p *Proxy) selectUpstream(r *http.Request) *upstream {
+	info := gql.RequestInfoFromContext(r.Context())
+	for _, route := range p.routes {
+		if route.matches(r, info) {
+			return route.upstream
+		}
+	}
+	return p.fallback
+}
+
+func newUpstream(name string, cfg UpstreamConfig, tracing TracingConfig, headers *headerPolicies, deadlineHeader string, modify func(res *http.Response) error, log *slog.Logger) (*upstream, error) {
 	target, err := url.Parse(cfg.Host)
 	if err != nil {
 		return nil, err
 	}
+
+	credentials, err := newTLSCredentials(name, target.Hostname(), cfg.TLS, log)
+	if err != nil {
+		return nil, err
+	}
+
+	base := NewTransport(name, cfg, tracing, credentials.clientConfig())
+	transport := newDeadlineTransport(deadlineHeader, base)
+
+	var health *healthCheck
+	if cfg.CircuitBreaker.Enabled {
+		transport = newCircuitBreaker(name, cfg.CircuitBreaker, transport)
+	}
+	if cfg.HealthCheck.Enabled {
+		health = newHealthCheck(name, cfg.HealthCheck, target, base, log)
+		transport = health.gate(transport)
+	}
+	if cfg.Retry.Enabled {
+		transport = newRetryTransport(name, cfg.Retry, transport)
+	}
 	proxy := &httputil.ReverseProxy{
 		Rewrite: func(r *httputil.ProxyRequest) {
 			r.Out.Header["X-Forwarded-For"] = r.In.Header["X-Forwarded-For"]
+			headers.request.apply(r.Out.Header)
 			r.Out.Header.Del("Accept-Encoding") // Disabled as compression has no direct benefit for us within our cloud setup, this can be removed if proper parsing for all types of compression is implemented
 			r.SetXForwarded()
 			r.SetURL(target)
 			r.Out.Host = r.In.Host
 		},
-		Transport:      NewTransport(cfg),
-		ModifyResponse: modifyResponse(blockFieldSuggestions, obfuscateUpstreamErrors, logGraphqlErrors, log), // nolint:bodyclose
+		Transport: transport,
+		ModifyResponse: func(res *http.Response) error {
+			headers.response.apply(res.Header)
+			return modify(res)
+		},
+		ErrorHandler: errorHandler(name),
 	}
 
-	return proxy, nil
+	return &upstream{
+		name:        name,
+		handler:     proxy,
+		healthCheck: health,
+		credentials: credentials,
+	}, nil
 }
 
-func modifyResponse(blockFieldSuggestions *block_field_suggestions.BlockFieldSuggestionsHandler, obfuscateUpstreamErrors *obfuscate_upstream_errors.ObfuscateUpstreamErrors, logGraphqlErrors bool, log *slog.Logger) func(res *http.Response) error {
-	return func(res *http.Response) error {
+func (u *upstream) start() {
+	u.credentials.reloader.Start()
+	if u.healthCheck != nil {
+		u.healthCheck.start()
+	}
+}
 
-		// read raw response bytes
-		bodyBytes, _ := io.ReadAll(res.Body)
-		defer res.Body.Close()
+func (u *upstream) shutdown() {
+	u.credentials.reloader.Shutdown()
+	if u.healthCheck != nil {
+		u.healthCheck.shutdown()
+	}
+}
 
-		var response map[string]interface{}
-		err := json.Unmarshal(bodyBytes, &response)
-		if err != nil {
-			// if we cannot decode just return
-			// make sure to set body back to original bytes
-			res.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-			return nil
+// errorHandler responds with a GraphQL error when the upstream could not be reached.
+// Unavailable upstreams are reported with a 503, timeouts with a 504, other errors with a 502.
+// Request bodies that were rejected while being forwarded, such as uploads exceeding their limits, are reported with a 4xx.
+func errorHandler(name string) func(w http.ResponseWriter, r *http.Request, err error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if status, bodyErr := requestBodyError(err); bodyErr != nil {
+			res, _ := json.Marshal(map[string]interface{}{
+				"errors": gqlerror.List{gqlerror.Wrap(bodyErr)},
+			})
+			w.Header().Set("Content-Type", gql.ResponseMediaType(r))
+			w.WriteHeader(status)
+			_, _ = w.Write(res)
+			return
+		}
+
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrUpstreamUnhealthy):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, context.DeadlineExceeded):
+			status = http.StatusGatewayTimeout
+		}
+		upstreamErrorCounter.WithLabelValues(name, strconv.Itoa(status)).Inc()
+
+		res, _ := json.Marshal(map[string]interface{}{
+			"errors": gqlerror.List{gqlerror.Wrap(ErrUpstreamUnavailable)},
+		})
+		w.Header().Set("Content-Type", gql.ResponseMediaType(r))
+		w.WriteHeader(status)
+		_, _ = w.Write(res)
+	}
+}
+
+// requestBodyError returns the status and the error to report when reading the request body failed, or a nil error otherwise
+func requestBodyError(err error) (int, error) {
+	if maxBytes, ok := errors.AsType[*http.MaxBytesError](err); ok {
+		return http.StatusRequestEntityTooLarge, maxBytes
+	}
+	if upload, ok := errors.AsType[*gql.UploadError](err); ok {
+		return http.StatusBadRequest, upload
+	}
+	return 0, nil
+}
+
+// inherited returns the settings named upstreams and the mirror inherit from the default upstream
+func (c UpstreamConfig) inherited() UpstreamConfig {
+	return UpstreamConfig{
+		Timeout:               c.Timeout,
+		KeepAlive:             c.KeepAlive,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		TLSHandshakeTimeout:   c.TLSHandshakeTimeout,
+		HTTP2:                 c.HTTP2,
+		Retry: RetryConfig{
+			MaxAttempts: c.Retry.MaxAttempts,
+			Backoff:     c.Retry.Backoff,
+		},
+		HealthCheck: HealthCheckConfig{
+			Interval:           c.HealthCheck.Interval,
+			Timeout:            c.HealthCheck.Timeout,
+			UnhealthyThreshold: c.HealthCheck.UnhealthyThreshold,
+		},
+		CircuitBreaker: CircuitBreakerConfig{
+			FailureThreshold: c.CircuitBreaker.FailureThreshold,
+			OpenDuration:     c.CircuitBreaker.OpenDuration,
+		},
+		TLS: TLSConfig{
+			CertFile:   c.TLS.CertFile,
+			KeyFile:    c.TLS.KeyFile,
+			CAFile:     c.TLS.CAFile,
+			MinVersion: c.TLS.MinVersion,
+			AutoReload: TLSReloadConfig{
+				Interval: c.TLS.AutoReload.Interval,
+			},
+		},
+	}
+}
+
+func modifyResponse(blockFieldSuggestions *block_field_suggestions.BlockFieldSuggestionsHandler, obfuscateUpstreamErrors *obfuscate_upstream_errors.ObfuscateUpstreamErrors, maxResponse *max_response.MaxResponseRule, fieldMasking *field_masking.FieldMasking, metrics *responseMetrics, logGraphqlErrors bool, log *slog.Logger) func(res *http.Response) error { // nolint:cyclop
+	process := func(res *http.Response, i int, n int, response map[string]interface{}) map[string]interface{} {
+		info, operation := operationAt(res.Request, i, n)
+
+		if metrics != nil {
+			metrics.observeErrors(info, operation, res.StatusCode, response)
 		}
 
 		if logGraphqlErrors && response["errors"] != nil {
@@ -80,11 +499,56 @@ func modifyResponse(blockFieldSuggestions *block_field_suggestions.BlockFieldSug
 			response = blockFieldSuggestions.ProcessBody(response)
 		}
 
+		if fieldMasking != nil && fieldMasking.Enabled() {
+			response = fieldMasking.ProcessBody(res.Request, operation, response)
+		}
+
 		if obfuscateUpstreamErrors != nil && obfuscateUpstreamErrors.Enabled() {
 			response = obfuscateUpstreamErrors.ProcessBody(response)
 		}
+		return response
+	}
+
+	return func(res *http.Response) error {
+		defer res.Body.Close()
+
+		// read raw response bytes
+		var bodyBytes []byte
+		if maxResponse != nil && maxResponse.Enabled() {
+			bts, err := maxResponse.ReadBody(res)
+			if errors.Is(err, max_response.ErrMaxBytesExceeded) || errors.Is(err, max_response.ErrMaxDepthExceeded) {
+				replaceBody(res, err)
+				return nil
+			}
+			bodyBytes = bts
+		} else {
+			bodyBytes, _ = io.ReadAll(res.Body)
+		}
+
+		if metrics != nil {
+			metrics.observeSize(res.StatusCode, len(bodyBytes))
+		}
+
+		var processed interface{}
+		var response map[string]interface{}
+		var batch []map[string]interface{}
+		switch {
+		case json.Unmarshal(bodyBytes, &response) == nil:
+			processed = process(res, 0, 1, response)
+		case json.Unmarshal(bodyBytes, &batch) == nil:
+			// batched requests receive an array of responses, in the order of the operations
+			for i := range batch {
+				batch[i] = process(res, i, len(batch), batch[i])
+			}
+			processed = batch
+		default:
+			// if we cannot decode just return
+			// make sure to set body back to original bytes
+			res.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+			return nil
+		}
 
-		bts, err := json.Marshal(response)
+		bts, err := json.Marshal(processed)
 		if err != nil {
 			// if we cannot marshall just return
 			// make sure to set body back to original bytes
@@ -100,3 +564,33 @@ func modifyResponse(blockFieldSuggestions *block_field_suggestions.BlockFieldSug
 		return nil
 	}
 }
+
+// operationAt returns the request info and the operation the response at index i of a total of n responses belongs to.
+// The operation is nil when the responses can't be matched to the operations of the request
+func operationAt(r *http.Request, i int, n int) (*gql.RequestInfo, *gql.Operation) {
+	if r == nil {
+		return nil, nil
+	}
+	info := gql.RequestInfoFromContext(r.Context())
+	if info == nil || len(info.Operations) != n {
+		return info, nil
+	}
+	return info, &info.Operations[i]
+}
+
+// replaceBody replaces the upstream response with a GraphQL error
+func replaceBody(res *http.Response, err error) {
+	bts, _ := json.Marshal(map[string]interface{}{
+		"data":   nil,
+		"errors": gqlerror.List{gqlerror.Wrap(err)},
+	})
+
+	mediaType := gql.MediaTypeJSON
+	if res.Request != nil {
+		mediaType = gql.ResponseMediaType(res.Request)
+	}
+	res.Header.Set("Content-Type", mediaType)
+	res.ContentLength = int64(len(bts))
+	res.Header.Set("Content-Length", strconv.Itoa(len(bts)))
+	res.Body = io.NopCloser(bytes.NewBuffer(bts))
+}
@@ -1,8 +1,16 @@
 package proxy
 
 import (
+	"errors"
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
 	"github.com/ldebruijn/graphql-protect/internal/business/rules/block_field_suggestions"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/field_masking"
+	"github.com/ldebruijn/graphql-protect/internal/business/rules/max_response"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"gopkg.in/yaml.v3"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -15,6 +23,7 @@ import (
 func Test_modifyResponse(t *testing.T) {
 	type args struct {
 		blockFieldSuggestions *block_field_suggestions.BlockFieldSuggestionsHandler
+		maxResponse           *max_response.MaxResponseRule
 		response              *http.Response
 	}
 	tests := []struct {
@@ -26,9 +35,10 @@ func Test_modifyResponse(t *testing.T) {
 			name: "nothing if disabled",
 			args: args{
 				blockFieldSuggestions: func() *block_field_suggestions.BlockFieldSuggestionsHandler {
-					return block_field_suggestions.NewBlockFieldSuggestionsHandler(block_field_suggestions.Config{
+					handler, _ := block_field_suggestions.NewBlockFieldSuggestionsHandler(block_field_suggestions.Config{
 						Enabled: false,
 					})
+					return handler
 				}(),
 				response: func() *http.Response {
 					return &http.Response{
@@ -54,9 +64,10 @@ func Test_modifyResponse(t *testing.T) {
 			name: "handles non-json gracefully",
 			args: args{
 				blockFieldSuggestions: func() *block_field_suggestions.BlockFieldSuggestionsHandler {
-					return block_field_suggestions.NewBlockFieldSuggestionsHandler(block_field_suggestions.Config{
+					handler, _ := block_field_suggestions.NewBlockFieldSuggestionsHandler(block_field_suggestions.Config{
 						Enabled: true,
 					})
+					return handler
 				}(),
 				response: func() *http.Response {
 					return &http.Response{
@@ -82,9 +93,10 @@ func Test_modifyResponse(t *testing.T) {
 			name: "handles invalid-json gracefully",
 			args: args{
 				blockFieldSuggestions: func() *block_field_suggestions.BlockFieldSuggestionsHandler {
-					return block_field_suggestions.NewBlockFieldSuggestionsHandler(block_field_suggestions.Config{
+					handler, _ := block_field_suggestions.NewBlockFieldSuggestionsHandler(block_field_suggestions.Config{
 						Enabled: true,
 					})
+					return handler
 				}(),
 				response: func() *http.Response { // nolint:bodyclose
 					return &http.Response{
@@ -110,10 +122,11 @@ func Test_modifyResponse(t *testing.T) {
 			name: "handles json gracefully",
 			args: args{
 				blockFieldSuggestions: func() *block_field_suggestions.BlockFieldSuggestionsHandler {
-					return block_field_suggestions.NewBlockFieldSuggestionsHandler(block_field_suggestions.Config{
+					handler, _ := block_field_suggestions.NewBlockFieldSuggestionsHandler(block_field_suggestions.Config{
 						Enabled: true,
 						Mask:    "[masked]",
 					})
+					return handler
 				}(),
 				response: func() *http.Response {
 					return &http.Response{
@@ -135,10 +148,120 @@ func Test_modifyResponse(t *testing.T) {
 				assert.Equal(t, "{\"errors\":[{\"message\":\"[masked]\"}]}", string(body))
 			},
 		},
+		{
+			name: "replaces responses exceeding the max bytes",
+			args: args{
+				maxResponse: max_response.NewMaxResponseRule(max_response.Config{
+					Enabled:  true,
+					MaxBytes: 10,
+				}),
+				response: func() *http.Response {
+					return &http.Response{
+						Status:        "200",
+						StatusCode:    200,
+						Body:          io.NopCloser(strings.NewReader("{ \"data\": { \"foo\": \"bar\" } }")),
+						Proto:         "HTTP/1.1",
+						ProtoMajor:    1,
+						ProtoMinor:    1,
+						ContentLength: -1,
+						Header:        map[string][]string{},
+					}
+				}(), // nolint:bodyclose
+			},
+			want: func(res *http.Response) {
+				body, _ := io.ReadAll(res.Body)
+				assert.Equal(t, 200, res.StatusCode)
+				assert.Equal(t, "{\"data\":null,\"errors\":[{\"message\":\"response size limit exceeded\"}]}", string(body))
+				assert.Equal(t, int64(len(body)), res.ContentLength)
+				assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+			},
+		},
+		{
+			name: "replaces responses exceeding the max bytes using the negotiated media type",
+			args: args{
+				maxResponse: max_response.NewMaxResponseRule(max_response.Config{
+					Enabled:  true,
+					MaxBytes: 10,
+				}),
+				response: func() *http.Response {
+					req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+					req = req.WithContext(gql.WithRequestInfo(req.Context(), &gql.RequestInfo{ResponseMediaType: gql.MediaTypeGraphQLResponse}))
+					return &http.Response{
+						Status:        "200",
+						StatusCode:    200,
+						Body:          io.NopCloser(strings.NewReader("{ \"data\": { \"foo\": \"bar\" } }")),
+						Proto:         "HTTP/1.1",
+						ProtoMajor:    1,
+						ProtoMinor:    1,
+						ContentLength: -1,
+						Header:        map[string][]string{"Content-Type": {"application/json"}},
+						Request:       req,
+					}
+				}(), // nolint:bodyclose
+			},
+			want: func(res *http.Response) {
+				body, _ := io.ReadAll(res.Body)
+				assert.Equal(t, "{\"data\":null,\"errors\":[{\"message\":\"response size limit exceeded\"}]}", string(body))
+				assert.Equal(t, "application/graphql-response+json", res.Header.Get("Content-Type"))
+			},
+		},
+		{
+			name: "replaces responses exceeding the max depth",
+			args: args{
+				maxResponse: max_response.NewMaxResponseRule(max_response.Config{
+					Enabled:  true,
+					MaxDepth: 2,
+				}),
+				response: func() *http.Response {
+					return &http.Response{
+						Status:        "200",
+						StatusCode:    200,
+						Body:          io.NopCloser(strings.NewReader("{ \"data\": { \"foo\": { \"bar\": 1 } } }")),
+						Proto:         "HTTP/1.1",
+						ProtoMajor:    1,
+						ProtoMinor:    1,
+						ContentLength: -1,
+						Header:        map[string][]string{},
+					}
+				}(), // nolint:bodyclose
+			},
+			want: func(res *http.Response) {
+				body, _ := io.ReadAll(res.Body)
+				assert.Equal(t, 200, res.StatusCode)
+				assert.Equal(t, "{\"data\":null,\"errors\":[{\"message\":\"response depth limit exceeded\"}]}", string(body))
+			},
+		},
+		{
+			name: "passes responses within the limits",
+			args: args{
+				maxResponse: max_response.NewMaxResponseRule(max_response.Config{
+					Enabled:  true,
+					MaxBytes: 100,
+					MaxDepth: 3,
+				}),
+				response: func() *http.Response {
+					return &http.Response{
+						Status:        "200",
+						StatusCode:    200,
+						Body:          io.NopCloser(strings.NewReader("{ \"data\": { \"foo\": { \"bar\": 1 } } }")),
+						Proto:         "HTTP/1.1",
+						ProtoMajor:    1,
+						ProtoMinor:    1,
+						ContentLength: -1,
+						Header:        map[string][]string{},
+					}
+				}(), // nolint:bodyclose
+			},
+			want: func(res *http.Response) {
+				body, _ := io.ReadAll(res.Body)
+				assert.Equal(t, 200, res.StatusCode)
+				assert.Equal(t, "{\"data\":{\"foo\":{\"bar\":1}}}", string(body))
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(_ *testing.T) {
-			result := modifyResponse(tt.args.blockFieldSuggestions, nil, false, nil) // nolint:bodyclose
+			result := modifyResponse(tt.args.blockFieldSuggestions, nil, tt.args.maxResponse, nil, nil, false, nil) // nolint:bodyclose
 
 			_ = result(tt.args.response)
 			tt.want(tt.args.response)
@@ -146,6 +269,43 @@ func Test_modifyResponse(t *testing.T) {
 	}
 }
 
+func Test_modifyResponse_MasksBatchedResponses(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+		type Query { user: User }
+		type User { name: String email: String }
+	`})
+	operations := make([]gql.Operation, 0, 2)
+	for _, query := range []string{"{ user { name } }", "{ user { email } }"} {
+		doc, errs := gqlparser.LoadQuery(schema, query)
+		require.Empty(t, errs)
+		operation, ok := gql.NewOperation(doc, "")
+		require.True(t, ok)
+		operations = append(operations, operation)
+	}
+
+	fieldMasking, err := field_masking.NewFieldMasking(field_masking.Config{
+		Enabled:     true,
+		RolesHeader: "X-Roles",
+		Rules:       []field_masking.RuleConfig{{Field: "User.email", Roles: []string{"admin"}}},
+	}, nil)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	r = r.WithContext(gql.WithRequestInfo(r.Context(), &gql.RequestInfo{Operations: operations}))
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`[{"data":{"user":{"name":"foo"}}},{"data":{"user":{"email":"foo@example.com"}}}]`)),
+		Header:     map[string][]string{},
+		Request:    r,
+	}
+
+	err = modifyResponse(nil, nil, nil, fieldMasking, nil, false, nil)(res) // nolint:bodyclose
+	require.NoError(t, err)
+
+	body, _ := io.ReadAll(res.Body)
+	assert.JSONEq(t, `[{"data":{"user":{"name":"foo"}}},{"data":{"user":{"email":null}}}]`, string(body))
+}
+
 func TestForwardsXff(t *testing.T) {
 	rr := &RequestRecorder{}
 	testServer := httptest.NewServer(rr)
@@ -153,12 +313,14 @@ func TestForwardsXff(t *testing.T) {
 	assert.NoError(t, err)
 
 	cfg := Config{
-		Timeout:   1 * time.Second,
-		KeepAlive: 180 * time.Second,
-		Host:      "http://" + upstreamURL.Host,
-		Tracing:   TracingConfig{},
+		UpstreamConfig: UpstreamConfig{
+			Timeout:   1 * time.Second,
+			KeepAlive: 180 * time.Second,
+			Host:      "http://" + upstreamURL.Host,
+		},
+		Tracing: TracingConfig{},
 	}
-	proxy, err := NewProxy(cfg, nil, nil, false, nil)
+	proxy, err := NewProxy(cfg, nil, nil, nil, nil, false, nil)
 	assert.NoError(t, err)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -185,3 +347,131 @@ func (r *RequestRecorder) ServeHTTP(_ http.ResponseWriter, request *http.Request
 func (r *RequestRecorder) Assert(assert func(r *http.Request)) {
 	assert(r.lastRequest)
 }
+
+type failingBody struct {
+	err error
+}
+
+func (b *failingBody) Read(_ []byte) (int, error) {
+	return 0, b.err
+}
+
+func TestProxy_RequestBodyErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantMessage string
+	}{
+		{
+			name:        "rejected uploads",
+			err:         &gql.UploadError{Err: errors.New("file too large")},
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "upload rejected: file too large",
+		},
+		{
+			name:        "bodies exceeding the limit",
+			err:         &http.MaxBytesError{Limit: 10},
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantMessage: "http: request body too large",
+		},
+		{
+			name:        "other errors",
+			err:         errors.New("connection reset"),
+			wantStatus:  http.StatusBadGateway,
+			wantMessage: ErrUpstreamUnavailable.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+			}))
+			defer upstream.Close()
+
+			proxy, err := NewProxy(Config{
+				UpstreamConfig: UpstreamConfig{
+					Timeout:   time.Second,
+					KeepAlive: time.Second,
+					Host:      upstream.URL,
+				},
+			}, nil, nil, nil, nil, false, nil)
+			require.NoError(t, err)
+			defer proxy.Shutdown()
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", &failingBody{err: tt.err})
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Contains(t, w.Body.String(), tt.wantMessage)
+		})
+	}
+}
+
+func TestConfig_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantUpstream UpstreamConfig
+		wantMirror   UpstreamConfig
+	}{
+		{
+			name:  "unset settings are inherited from the default upstream",
+			input: "response_header_timeout: 3s\nupstreams:\n  new:\n    host: http://new\n    timeout: 2s\nmirror:\n  host: http://shadow",
+			wantUpstream: func() UpstreamConfig {
+				cfg := DefaultConfig().inherited()
+				cfg.Host = "http://new"
+				cfg.Timeout = 2 * time.Second
+				cfg.ResponseHeaderTimeout = 3 * time.Second
+				return cfg
+			}(),
+			wantMirror: func() UpstreamConfig {
+				cfg := DefaultConfig().inherited()
+				cfg.Host = "http://shadow"
+				cfg.ResponseHeaderTimeout = 3 * time.Second
+				return cfg
+			}(),
+		},
+		{
+			name:  "zero values override the default upstream",
+			input: "response_header_timeout: 3s\nupstreams:\n  new:\n    host: http://new\n    response_header_timeout: 0s\n    http2:\n      enabled: false\nmirror:\n  host: http://shadow\n  max_idle_conns: 0",
+			wantUpstream: func() UpstreamConfig {
+				cfg := DefaultConfig().inherited()
+				cfg.Host = "http://new"
+				cfg.HTTP2.Enabled = false
+				return cfg
+			}(),
+			wantMirror: func() UpstreamConfig {
+				cfg := DefaultConfig().inherited()
+				cfg.Host = "http://shadow"
+				cfg.ResponseHeaderTimeout = 3 * time.Second
+				cfg.MaxIdleConns = 0
+				return cfg
+			}(),
+		},
+		{
+			name:  "the default upstream is inherited regardless of the order of the settings",
+			input: "upstreams:\n  new:\n    host: http://new\ntimeout: 2s",
+			wantUpstream: func() UpstreamConfig {
+				cfg := DefaultConfig().inherited()
+				cfg.Host = "http://new"
+				cfg.Timeout = 2 * time.Second
+				return cfg
+			}(),
+			wantMirror: func() UpstreamConfig {
+				cfg := DefaultConfig().inherited()
+				cfg.Timeout = 2 * time.Second
+				return cfg
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			require.NoError(t, yaml.Unmarshal([]byte(tt.input), &cfg))
+			assert.Equal(t, tt.wantUpstream, cfg.Upstreams["new"])
+			assert.Equal(t, tt.wantMirror, cfg.Mirror.UpstreamConfig)
+		})
+	}
+}
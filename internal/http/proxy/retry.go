@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	retryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "retry_count",
+		Help:      "Amount of upstream requests that were retried",
+	},
+		[]string{"upstream", "reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(retryCounter)
+}
+
+type RetryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Maximum number of attempts, including the initial attempt
+	MaxAttempts int `yaml:"max_attempts"`
+	// Time to wait between attempts
+	Backoff time.Duration `yaml:"backoff"`
+}
+
+// retryTransport retries query operations that failed on a connection error or a 5xx status code.
+// Mutations, subscriptions and requests protect knows nothing about are never retried.
+type retryTransport struct {
+	upstream string
+	cfg      RetryConfig
+	next     http.RoundTripper
+}
+
+func newRetryTransport(upstream string, cfg RetryConfig, next http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		upstream: upstream,
+		cfg:      cfg,
+		next:     next,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.cfg.MaxAttempts < 2 || !gql.RequestInfoFromContext(req.Context()).OnlyQueries() {
+		return t.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		bts, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = bts
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptReq := req.Clone(req.Context())
+		if body != nil {
+			attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		res, err := t.next.RoundTrip(attemptReq)
+
+		reason, retry := shouldRetry(res, err)
+		if !retry || attempt >= t.cfg.MaxAttempts || req.Context().Err() != nil {
+			return res, err
+		}
+
+		if res != nil {
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+		retryCounter.WithLabelValues(t.upstream, reason).Inc()
+
+		timer := time.NewTimer(t.cfg.Backoff)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func shouldRetry(res *http.Response, err error) (string, bool) {
+	if err != nil {
+		if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrUpstreamUnhealthy) {
+			return "", false
+		}
+		return "error", true
+	}
+	if res.StatusCode >= http.StatusInternalServerError {
+		return "status", true
+	}
+	return "", false
+}
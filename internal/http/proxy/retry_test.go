@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		info         *gql.RequestInfo
+		failures     int32
+		wantStatus   int
+		wantAttempts int32
+	}{
+		{
+			name:         "retries queries until they succeed",
+			info:         &gql.RequestInfo{Operations: []gql.Operation{{Type: ast.Query}}, OperationCount: 1},
+			failures:     2,
+			wantStatus:   http.StatusOK,
+			wantAttempts: 3,
+		},
+		{
+			name:         "gives up after max attempts",
+			info:         &gql.RequestInfo{Operations: []gql.Operation{{Type: ast.Query}}, OperationCount: 1},
+			failures:     5,
+			wantStatus:   http.StatusInternalServerError,
+			wantAttempts: 3,
+		},
+		{
+			name:         "never retries mutations",
+			info:         &gql.RequestInfo{Operations: []gql.Operation{{Type: ast.Query}, {Type: ast.Mutation}}, OperationCount: 2},
+			failures:     1,
+			wantStatus:   http.StatusInternalServerError,
+			wantAttempts: 1,
+		},
+		{
+			name:         "never retries batches with operations that couldn't be resolved",
+			info:         &gql.RequestInfo{Operations: []gql.Operation{{Type: ast.Query}}, OperationCount: 2},
+			failures:     1,
+			wantStatus:   http.StatusInternalServerError,
+			wantAttempts: 1,
+		},
+		{
+			name:         "never retries unknown requests",
+			failures:     1,
+			wantStatus:   http.StatusInternalServerError,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				assert.Equal(t, `{"query":"{ foo }"}`, string(body))
+
+				if attempts.Add(1) <= tt.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			transport := newRetryTransport("test", RetryConfig{Enabled: true, MaxAttempts: 3, Backoff: time.Millisecond}, http.DefaultTransport)
+
+			req := httptest.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"query":"{ foo }"}`))
+			req.RequestURI = ""
+			if tt.info != nil {
+				req = req.WithContext(gql.WithRequestInfo(req.Context(), tt.info))
+			}
+
+			res, err := transport.RoundTrip(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			assert.Equal(t, tt.wantAttempts, attempts.Load())
+		})
+	}
+}
+
+func TestRetryTransport_DoesNotRetryOpenCircuit(t *testing.T) {
+	var attempts atomic.Int32
+	next := roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+		attempts.Add(1)
+		return nil, ErrCircuitOpen
+	})
+	transport := newRetryTransport("test", RetryConfig{Enabled: true, MaxAttempts: 3}, next)
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	req = req.WithContext(gql.WithRequestInfo(req.Context(), &gql.RequestInfo{Operations: []gql.Operation{{Type: ast.Query}}, OperationCount: 1}))
+
+	_, err := transport.RoundTrip(req) // nolint:bodyclose
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(1), attempts.Load())
+}
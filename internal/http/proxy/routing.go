@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+var (
+	ErrInvalidOperationType = errors.New("operation type must be one of query, mutation or subscription")
+	ErrInvalidRoutePath     = errors.New("route path must start with a `/` and can't contain wildcards")
+)
+
+type RouteConfig struct {
+	// Name of the upstream to send matching requests to
+	Upstream string      `yaml:"upstream"`
+	Match    MatchConfig `yaml:"match"`
+}
+
+// MatchConfig describes which requests a route applies to. All configured criteria must match.
+// Operation criteria must match every operation of a (batched) request.
+type MatchConfig struct {
+	// Exact path the request was received on, protect serves GraphQL traffic on this path in addition to `web.path`
+	Path string `yaml:"path"`
+	// Header that must be present, optionally with an exact value
+	Header HeaderMatchConfig `yaml:"header"`
+	// One of query, mutation or subscription
+	OperationType string `yaml:"operation_type"`
+	// Regular expressions matched against the operation name
+	OperationNames []string `yaml:"operation_names"`
+	// Root fields of which at least one must be selected by the operation
+	RootFields []string `yaml:"root_fields"`
+}
+
+// RoutePaths returns the distinct paths routes match on, these have to be served alongside the GraphQL path
+func (c Config) RoutePaths() []string {
+	var paths []string
+	for _, route := range c.Routes {
+		if route.Match.Path != "" && !slices.Contains(paths, route.Match.Path) {
+			paths = append(paths, route.Match.Path)
+		}
+	}
+	return paths
+}
+
+type HeaderMatchConfig struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type route struct {
+	cfg            MatchConfig
+	operationNames []*regexp.Regexp
+	upstream       *upstream
+}
+
+func newRoute(cfg MatchConfig, upstream *upstream) (*route, error) {
+	switch ast.Operation(cfg.OperationType) {
+	case "", ast.Query, ast.Mutation, ast.Subscription:
+	default:
+		return nil, fmt.Errorf("%w, got: %s", ErrInvalidOperationType, cfg.OperationType)
+	}
+
+	if cfg.Path != "" && (!strings.HasPrefix(cfg.Path, "/") || strings.ContainsAny(cfg.Path, "{} ")) {
+		return nil, fmt.Errorf("%w, got: %s", ErrInvalidRoutePath, cfg.Path)
+	}
+
+	operationNames := make([]*regexp.Regexp, 0, len(cfg.OperationNames))
+	for _, pattern := range cfg.OperationNames {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid operation name pattern %s: %w", pattern, err)
+		}
+		operationNames = append(operationNames, re)
+	}
+
+	return &route{
+		cfg:            cfg,
+		operationNames: operationNames,
+		upstream:       upstream,
+	}, nil
+}
+
+func (r *route) matches(req *http.Request, info *gql.RequestInfo) bool {
+	if r.cfg.Path != "" && req.URL.Path != r.cfg.Path {
+		return false
+	}
+
+	if r.cfg.Header.Name != "" {
+		values, ok := req.Header[http.CanonicalHeaderKey(r.cfg.Header.Name)]
+		if !ok || (r.cfg.Header.Value != "" && !slices.Contains(values, r.cfg.Header.Value)) {
+			return false
+		}
+	}
+
+	if !r.hasOperationCriteria() {
+		return true
+	}
+
+	if info == nil || len(info.Operations) == 0 {
+		return false
+	}
+	for _, operation := range info.Operations {
+		if !r.matchesOperation(operation) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasOperationCriteria returns whether the route has any operation based criteria
+func (r *route) hasOperationCriteria() bool {
+	return r.cfg.OperationType != "" || len(r.operationNames) > 0 || len(r.cfg.RootFields) > 0
+}
+
+func (r *route) matchesOperation(operation gql.Operation) bool {
+	if r.cfg.OperationType != "" && string(operation.Type) != r.cfg.OperationType {
+		return false
+	}
+
+	if len(r.operationNames) > 0 && !slices.ContainsFunc(r.operationNames, func(re *regexp.Regexp) bool {
+		return re.MatchString(operation.Name)
+	}) {
+		return false
+	}
+
+	if len(r.cfg.RootFields) > 0 && !slices.ContainsFunc(operation.RootFields, func(field string) bool {
+		return slices.Contains(r.cfg.RootFields, field)
+	}) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestProxy_Routing(t *testing.T) {
+	defaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("default"))
+	}))
+	defer defaultServer.Close()
+	newServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("new"))
+	}))
+	defer newServer.Close()
+
+	cfg := DefaultConfig()
+	cfg.Host = defaultServer.URL
+	cfg.Upstreams = map[string]UpstreamConfig{
+		"new": {Host: newServer.URL},
+	}
+
+	tests := []struct {
+		name   string
+		routes []RouteConfig
+		path   string
+		header http.Header
+		info   *gql.RequestInfo
+		want   string
+	}{
+		{
+			name: "no routes sends everything to the default upstream",
+			info: &gql.RequestInfo{Operations: []gql.Operation{{Name: "Foo", Type: ast.Mutation}}},
+			want: "default",
+		},
+		{
+			name:   "routes by operation type",
+			routes: []RouteConfig{{Upstream: "new", Match: MatchConfig{OperationType: "mutation"}}},
+			info:   &gql.RequestInfo{Operations: []gql.Operation{{Name: "Foo", Type: ast.Mutation}}},
+			want:   "new",
+		},
+		{
+			name:   "operation criteria must match every operation in a batch",
+			routes: []RouteConfig{{Upstream: "new", Match: MatchConfig{OperationType: "mutation"}}},
+			info: &gql.RequestInfo{Operations: []gql.Operation{
+				{Name: "Foo", Type: ast.Mutation},
+				{Name: "Bar", Type: ast.Query},
+			}},
+			want: "default",
+		},
+		{
+			name:   "routes by operation name pattern",
+			routes: []RouteConfig{{Upstream: "new", Match: MatchConfig{OperationNames: []string{"^Checkout"}}}},
+			info:   &gql.RequestInfo{Operations: []gql.Operation{{Name: "CheckoutCart", Type: ast.Query}}},
+			want:   "new",
+		},
+		{
+			name:   "routes by root field",
+			routes: []RouteConfig{{Upstream: "new", Match: MatchConfig{RootFields: []string{"cart"}}}},
+			info:   &gql.RequestInfo{Operations: []gql.Operation{{Type: ast.Query, RootFields: []string{"user", "cart"}}}},
+			want:   "new",
+		},
+		{
+			name:   "operation criteria don't match requests without operations",
+			routes: []RouteConfig{{Upstream: "new", Match: MatchConfig{OperationType: "query"}}},
+			want:   "default",
+		},
+		{
+			name:   "routes by header presence",
+			routes: []RouteConfig{{Upstream: "new", Match: MatchConfig{Header: HeaderMatchConfig{Name: "x-canary"}}}},
+			header: http.Header{"X-Canary": []string{"anything"}},
+			want:   "new",
+		},
+		{
+			name:   "routes by header value",
+			routes: []RouteConfig{{Upstream: "new", Match: MatchConfig{Header: HeaderMatchConfig{Name: "x-canary", Value: "true"}}}},
+			header: http.Header{"X-Canary": []string{"false"}},
+			want:   "default",
+		},
+		{
+			name:   "routes by path",
+			routes: []RouteConfig{{Upstream: "new", Match: MatchConfig{Path: "/v2/graphql"}}},
+			path:   "/v2/graphql",
+			want:   "new",
+		},
+		{
+			name: "first matching route wins",
+			routes: []RouteConfig{
+				{Upstream: "default", Match: MatchConfig{OperationNames: []string{"Foo"}}},
+				{Upstream: "new", Match: MatchConfig{OperationType: "mutation"}},
+			},
+			info: &gql.RequestInfo{Operations: []gql.Operation{{Name: "Foo", Type: ast.Mutation}}},
+			want: "default",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := cfg
+			cfg.Routes = tt.routes
+
+			proxy, err := NewProxy(cfg, nil, nil, nil, nil, false, nil)
+			assert.NoError(t, err)
+
+			path := tt.path
+			if path == "" {
+				path = "/graphql"
+			}
+			req := httptest.NewRequest(http.MethodPost, path, nil)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			if tt.info != nil {
+				req = req.WithContext(gql.WithRequestInfo(req.Context(), tt.info))
+			}
+
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			body, _ := io.ReadAll(res.Body)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
+
+func TestNewProxy_InvalidRoutes(t *testing.T) {
+	tests := []struct {
+		name  string
+		route RouteConfig
+	}{
+		{
+			name:  "unknown upstream",
+			route: RouteConfig{Upstream: "unknown"},
+		},
+		{
+			name:  "invalid operation type",
+			route: RouteConfig{Upstream: "default", Match: MatchConfig{OperationType: "fragment"}},
+		},
+		{
+			name:  "invalid operation name pattern",
+			route: RouteConfig{Upstream: "default", Match: MatchConfig{OperationNames: []string{"("}}},
+		},
+		{
+			name:  "relative path",
+			route: RouteConfig{Upstream: "default", Match: MatchConfig{Path: "v2/graphql"}},
+		},
+		{
+			name:  "path with wildcards",
+			route: RouteConfig{Upstream: "default", Match: MatchConfig{Path: "/{version}/graphql"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Routes = []RouteConfig{tt.route}
+
+			_, err := NewProxy(cfg, nil, nil, nil, nil, false, nil)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+var ErrTimeoutExceedsWriteTimeout = errors.New("request timeout exceeds web.write_timeout")
+
+type RequestTimeoutConfig struct {
+	// Timeout of requests that don't match any of the operations or operation types, 0 means no timeout
+	Default time.Duration `yaml:"default"`
+	// Timeouts by operation type, one of query, mutation or subscription
+	OperationTypes map[string]time.Duration `yaml:"operation_types"`
+	// Timeouts by operation name, these take precedence over the operation types
+	Operations map[string]time.Duration `yaml:"operations"`
+	// Header propagating the time remaining until the deadline to the upstream, in milliseconds. Empty disables propagation.
+	DeadlineHeader string `yaml:"deadline_header"`
+}
+
+// ValidateWriteTimeout checks that no timeout exceeds the write timeout of the server, which closes the connection before such a timeout could take effect.
+// A write timeout of 0 means there is none.
+func (c RequestTimeoutConfig) ValidateWriteTimeout(writeTimeout time.Duration) error {
+	if writeTimeout <= 0 {
+		return nil
+	}
+	if c.Default > writeTimeout {
+		return fmt.Errorf("%w: default %s", ErrTimeoutExceedsWriteTimeout, c.Default)
+	}
+	for operationType, timeout := range c.OperationTypes {
+		if timeout > writeTimeout {
+			return fmt.Errorf("%w: operation type %s %s", ErrTimeoutExceedsWriteTimeout, operationType, timeout)
+		}
+	}
+	for operation, timeout := range c.Operations {
+		if timeout > writeTimeout {
+			return fmt.Errorf("%w: operation %s %s", ErrTimeoutExceedsWriteTimeout, operation, timeout)
+		}
+	}
+	return nil
+}
+
+// requestTimeouts decides how long the upstream may take to respond to a request, based on its operations
+type requestTimeouts struct {
+	cfg RequestTimeoutConfig
+}
+
+func newRequestTimeouts(cfg RequestTimeoutConfig) (*requestTimeouts, error) {
+	for operationType := range cfg.OperationTypes {
+		switch ast.Operation(operationType) {
+		case ast.Query, ast.Mutation, ast.Subscription:
+		default:
+			return nil, fmt.Errorf("%w, got: %s", ErrInvalidOperationType, operationType)
+		}
+	}
+
+	return &requestTimeouts{
+		cfg: cfg,
+	}, nil
+}
+
+// timeout returns the timeout of the request. For requests with multiple operations the most lenient timeout is used.
+func (t *requestTimeouts) timeout(info *gql.RequestInfo) time.Duration {
+	if info == nil || len(info.Operations) == 0 {
+		return t.cfg.Default
+	}
+
+	var timeout time.Duration
+	for _, operation := range info.Operations {
+		operationTimeout := t.operationTimeout(operation)
+		if operationTimeout == 0 {
+			// one of the operations may take as long as it needs
+			return 0
+		}
+		timeout = max(timeout, operationTimeout)
+	}
+	return timeout
+}
+
+func (t *requestTimeouts) operationTimeout(operation gql.Operation) time.Duration {
+	if timeout, ok := t.cfg.Operations[operation.Name]; ok && operation.Name != "" {
+		return timeout
+	}
+	if timeout, ok := t.cfg.OperationTypes[string(operation.Type)]; ok {
+		return timeout
+	}
+	return t.cfg.Default
+}
+
+// handle applies the timeout of the request to its context
+func (t *requestTimeouts) handle(r *http.Request) (*http.Request, context.CancelFunc) {
+	timeout := t.timeout(gql.RequestInfoFromContext(r.Context()))
+	if timeout <= 0 {
+		return r, func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return r.WithContext(ctx), cancel
+}
+
+// deadlineTransport propagates the time remaining until the deadline of the request to the upstream
+type deadlineTransport struct {
+	header string
+	next   http.RoundTripper
+}
+
+func newDeadlineTransport(header string, next http.RoundTripper) http.RoundTripper {
+	if header == "" {
+		return next
+	}
+	return &deadlineTransport{
+		header: header,
+		next:   next,
+	}
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		// a deadline sent by the client can't be trusted
+		req.Header.Del(t.header)
+		return t.next.RoundTrip(req)
+	}
+
+	// calculated on every attempt, so retries propagate the time that is actually left
+	remaining := max(time.Until(deadline).Milliseconds(), 0)
+	req.Header.Set(t.header, strconv.FormatInt(remaining, 10))
+	return t.next.RoundTrip(req)
+}
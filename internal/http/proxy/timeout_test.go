@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/business/gql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestRequestTimeouts_Timeout(t *testing.T) {
+	cfg := RequestTimeoutConfig{
+		Default: 5 * time.Second,
+		OperationTypes: map[string]time.Duration{
+			"mutation": 10 * time.Second,
+		},
+		Operations: map[string]time.Duration{
+			"Typeahead": 500 * time.Millisecond,
+			"Report":    time.Minute,
+		},
+	}
+
+	tests := []struct {
+		name string
+		info *gql.RequestInfo
+		want time.Duration
+	}{
+		{
+			name: "default without operations",
+			info: nil,
+			want: 5 * time.Second,
+		},
+		{
+			name: "default for operations without a specific timeout",
+			info: &gql.RequestInfo{Operations: []gql.Operation{{Name: "Products", Type: ast.Query}}},
+			want: 5 * time.Second,
+		},
+		{
+			name: "by operation type",
+			info: &gql.RequestInfo{Operations: []gql.Operation{{Name: "AddToCart", Type: ast.Mutation}}},
+			want: 10 * time.Second,
+		},
+		{
+			name: "operation name takes precedence over type",
+			info: &gql.RequestInfo{Operations: []gql.Operation{{Name: "Report", Type: ast.Mutation}}},
+			want: time.Minute,
+		},
+		{
+			name: "most lenient timeout of a batch",
+			info: &gql.RequestInfo{Operations: []gql.Operation{{Name: "Typeahead", Type: ast.Query}, {Name: "Products", Type: ast.Query}}},
+			want: 5 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeouts, err := newRequestTimeouts(cfg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, timeouts.timeout(tt.info))
+		})
+	}
+}
+
+func TestNewRequestTimeouts_InvalidOperationType(t *testing.T) {
+	_, err := newRequestTimeouts(RequestTimeoutConfig{
+		OperationTypes: map[string]time.Duration{"queries": time.Second},
+	})
+	assert.ErrorIs(t, err, ErrInvalidOperationType)
+}
+
+func TestRequestTimeoutConfig_ValidateWriteTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          RequestTimeoutConfig
+		writeTimeout time.Duration
+		wantErr      error
+	}{
+		{
+			name: "timeouts within the write timeout",
+			cfg: RequestTimeoutConfig{
+				Default:        5 * time.Second,
+				OperationTypes: map[string]time.Duration{"mutation": 10 * time.Second},
+				Operations:     map[string]time.Duration{"Typeahead": 500 * time.Millisecond},
+			},
+			writeTimeout: 10 * time.Second,
+		},
+		{
+			name:         "default exceeding the write timeout",
+			cfg:          RequestTimeoutConfig{Default: 11 * time.Second},
+			writeTimeout: 10 * time.Second,
+			wantErr:      ErrTimeoutExceedsWriteTimeout,
+		},
+		{
+			name:         "operation type exceeding the write timeout",
+			cfg:          RequestTimeoutConfig{OperationTypes: map[string]time.Duration{"mutation": 11 * time.Second}},
+			writeTimeout: 10 * time.Second,
+			wantErr:      ErrTimeoutExceedsWriteTimeout,
+		},
+		{
+			name:         "operation exceeding the write timeout",
+			cfg:          RequestTimeoutConfig{Operations: map[string]time.Duration{"Report": time.Minute}},
+			writeTimeout: 10 * time.Second,
+			wantErr:      ErrTimeoutExceedsWriteTimeout,
+		},
+		{
+			name:         "no write timeout",
+			cfg:          RequestTimeoutConfig{Operations: map[string]time.Duration{"Report": time.Minute}},
+			writeTimeout: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.cfg.ValidateWriteTimeout(tt.writeTimeout), tt.wantErr)
+		})
+	}
+}
+
+func TestProxy_RequestTimeout(t *testing.T) {
+	var deadline string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline = r.Header.Get("X-Request-Timeout-Ms")
+		if r.Header.Get("X-Slow") != "" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{}}`))
+	}))
+	defer upstream.Close()
+
+	proxy, err := NewProxy(Config{
+		UpstreamConfig: UpstreamConfig{
+			Timeout:   time.Second,
+			KeepAlive: time.Second,
+			Host:      upstream.URL,
+		},
+		RequestTimeout: RequestTimeoutConfig{
+			Operations:     map[string]time.Duration{"Typeahead": 50 * time.Millisecond, "Products": 2 * time.Second},
+			DeadlineHeader: "X-Request-Timeout-Ms",
+		},
+	}, nil, nil, nil, nil, false, nil)
+	require.NoError(t, err)
+
+	newRequest := func(operationName string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ foo }"}`))
+		info := &gql.RequestInfo{Operations: []gql.Operation{{Name: operationName, Type: ast.Query}}}
+		return r.WithContext(gql.WithRequestInfo(r.Context(), info))
+	}
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, newRequest("Products"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	remaining, err := strconv.Atoi(deadline)
+	require.NoError(t, err)
+	assert.InDelta(t, 2000, remaining, 100)
+
+	r := newRequest("Other")
+	r.Header.Set("X-Request-Timeout-Ms", "100000")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, deadline, "the deadline sent by the client is not forwarded")
+
+	r = newRequest("Typeahead")
+	r.Header.Set("X-Slow", "true")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.JSONEq(t, `{"errors":[{"message":"upstream unavailable"}]}`, w.Body.String())
+}
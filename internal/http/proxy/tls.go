@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"github.com/ldebruijn/graphql-protect/internal/http/tlsreload"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	tlsReloadCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "tls_reload_count",
+		Help:      "Amount of reloads of rotated upstream TLS certificates",
+	},
+		[]string{"upstream", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(tlsReloadCounter)
+}
+
+var (
+	ErrInvalidCABundle   = errors.New("no certificates found in CA bundle")
+	ErrIncompleteKeyPair = errors.New("both cert_file and key_file are required for a client certificate")
+)
+
+type TLSConfig struct {
+	// Client certificate and key presented to the upstream, for mTLS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// CA bundle used to verify the upstream certificate, defaults to the system roots
+	CAFile string `yaml:"ca_file"`
+	// Server name used to verify the upstream certificate, defaults to the hostname of the upstream
+	ServerName string `yaml:"server_name"`
+	// Minimum TLS version, one of 1.0, 1.1, 1.2 or 1.3
+	MinVersion string `yaml:"min_version"`
+	// Reload the certificate, key and CA bundle when they change on disk
+	AutoReload TLSReloadConfig `yaml:"auto_reload"`
+}
+
+type TLSReloadConfig = tlsreload.Config
+
+// tlsCredentials holds the certificates of an upstream, and swaps them when the files on disk are rotated
+type tlsCredentials struct {
+	upstream string
+	cfg      TLSConfig
+	log      *slog.Logger
+	// serverName the upstream certificate is verified against, the configured server name or else the upstream host
+	serverName string
+
+	minVersion  uint16
+	certificate atomic.Pointer[tls.Certificate]
+	roots       atomic.Pointer[x509.CertPool]
+	reloader    *tlsreload.Reloader
+}
+
+func newTLSCredentials(upstream string, host string, cfg TLSConfig, log *slog.Logger) (*tlsCredentials, error) {
+	minVersion, err := tlsreload.MinVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, ErrIncompleteKeyPair
+	}
+
+	c := &tlsCredentials{
+		upstream:   upstream,
+		cfg:        cfg,
+		log:        log,
+		serverName: host,
+		minVersion: minVersion,
+	}
+
+	if cfg.ServerName != "" {
+		c.serverName = cfg.ServerName
+	}
+
+	c.reloader, err = tlsreload.New(cfg.AutoReload, []string{cfg.CertFile, cfg.KeyFile, cfg.CAFile}, c.load, c.reloaded)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// clientConfig returns the TLS configuration for connections to the upstream.
+// Certificates are looked up on every handshake, so rotated certificates are used for new connections.
+func (c *tlsCredentials) clientConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: c.minVersion,
+		ServerName: c.cfg.ServerName,
+	}
+
+	if c.cfg.CertFile != "" {
+		cfg.GetClientCertificate = func(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.certificate.Load(), nil
+		}
+	}
+
+	if c.cfg.CAFile != "" {
+		// the upstream certificate is verified against the current CA bundle in verifyConnection instead,
+		// as the root CAs of a tls.Config cannot be swapped once in use
+		cfg.InsecureSkipVerify = true // nolint:gosec
+		cfg.VerifyConnection = c.verifyConnection
+	}
+
+	return cfg
+}
+
+func (c *tlsCredentials) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return x509.UnknownAuthorityError{}
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         c.roots.Load(),
+		DNSName:       c.serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func (c *tlsCredentials) load() error {
+	if c.cfg.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.cfg.CertFile, c.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		c.certificate.Store(&certificate)
+	}
+
+	if c.cfg.CAFile != "" {
+		bundle, err := os.ReadFile(c.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("unable to load CA bundle: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("%w: %s", ErrInvalidCABundle, c.cfg.CAFile)
+		}
+		c.roots.Store(roots)
+	}
+
+	return nil
+}
+
+func (c *tlsCredentials) reloaded(err error) {
+	if err != nil {
+		c.log.Warn("Error reloading upstream TLS certificates, continuing with the previous certificates", "upstream", c.upstream, "err", err)
+		tlsReloadCounter.WithLabelValues(c.upstream, "failed").Inc()
+		return
+	}
+	c.log.Info("Reloaded upstream TLS certificates", "upstream", c.upstream)
+	tlsReloadCounter.WithLabelValues(c.upstream, "success").Inc()
+}
@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ldebruijn/graphql-protect/internal/http/tlsreload"
+	"github.com/ldebruijn/graphql-protect/internal/http/tlsreload/tlstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// newMTLSUpstream starts an upstream that requires a client certificate signed by the client CA
+func newMTLSUpstream(t *testing.T, serverCA *tlstest.CA, clientCA *tlstest.CA) *httptest.Server {
+	certPEM, keyPEM := serverCA.Issue(t, x509.ExtKeyUsageServerAuth)
+	return newTLSUpstream(t, certPEM, keyPEM, clientCA)
+}
+
+// newTLSUpstream starts an upstream presenting the certificate, requiring a client certificate signed by the client CA
+func newTLSUpstream(t *testing.T, certPEM []byte, keyPEM []byte, clientCA *tlstest.CA) *httptest.Server {
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCA.Cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"__typename":"Query"}}`))
+	}))
+	server.TLS = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProxy_MTLS(t *testing.T) {
+	serverCA := tlstest.NewCA(t)
+	clientCA := tlstest.NewCA(t)
+	server := newMTLSUpstream(t, serverCA, clientCA)
+
+	dir := t.TempDir()
+	certPEM, keyPEM := clientCA.Issue(t, x509.ExtKeyUsageClientAuth)
+	tlstest.WriteFile(t, filepath.Join(dir, "client.crt"), certPEM)
+	tlstest.WriteFile(t, filepath.Join(dir, "client.key"), keyPEM)
+	tlstest.WriteFile(t, filepath.Join(dir, "ca.crt"), serverCA.PEM)
+
+	tests := []struct {
+		name       string
+		tls        TLSConfig
+		wantStatus int
+	}{
+		{
+			name: "presents the client certificate and verifies the upstream against the CA bundle",
+			tls: TLSConfig{
+				CertFile: filepath.Join(dir, "client.crt"),
+				KeyFile:  filepath.Join(dir, "client.key"),
+				CAFile:   filepath.Join(dir, "ca.crt"),
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "fails without a client certificate",
+			tls: TLSConfig{
+				CAFile: filepath.Join(dir, "ca.crt"),
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "fails without the CA bundle",
+			tls: TLSConfig{
+				CertFile: filepath.Join(dir, "client.crt"),
+				KeyFile:  filepath.Join(dir, "client.key"),
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "fails when the server name doesn't match",
+			tls: TLSConfig{
+				CertFile:   filepath.Join(dir, "client.crt"),
+				KeyFile:    filepath.Join(dir, "client.key"),
+				CAFile:     filepath.Join(dir, "ca.crt"),
+				ServerName: "graphql.example.com",
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, err := NewProxy(Config{
+				UpstreamConfig: UpstreamConfig{
+					Timeout:   time.Second,
+					KeepAlive: time.Second,
+					Host:      server.URL,
+					TLS:       tt.tls,
+				},
+			}, nil, nil, nil, nil, false, slog.Default())
+			require.NoError(t, err)
+			defer proxy.Shutdown()
+
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ __typename }"}`)))
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestProxy_MTLS_VerifiesUpstreamHost(t *testing.T) {
+	serverCA := tlstest.NewCA(t)
+	clientCA := tlstest.NewCA(t)
+	// the upstream is addressed by IP, but its certificate lacks an IP SAN
+	serverCert, serverKey := serverCA.IssueFor(t, x509.ExtKeyUsageServerAuth, []string{"localhost"}, nil)
+	server := newTLSUpstream(t, serverCert, serverKey, clientCA)
+
+	dir := t.TempDir()
+	certPEM, keyPEM := clientCA.Issue(t, x509.ExtKeyUsageClientAuth)
+	tlstest.WriteFile(t, filepath.Join(dir, "client.crt"), certPEM)
+	tlstest.WriteFile(t, filepath.Join(dir, "client.key"), keyPEM)
+	tlstest.WriteFile(t, filepath.Join(dir, "ca.crt"), serverCA.PEM)
+
+	tests := []struct {
+		name       string
+		serverName string
+		wantStatus int
+	}{
+		{
+			name:       "fails when the certificate doesn't cover the upstream IP",
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "verifies against the configured server name instead of the upstream host",
+			serverName: "localhost",
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, err := NewProxy(Config{
+				UpstreamConfig: UpstreamConfig{
+					Timeout:   time.Second,
+					KeepAlive: time.Second,
+					Host:      server.URL,
+					TLS: TLSConfig{
+						CertFile:   filepath.Join(dir, "client.crt"),
+						KeyFile:    filepath.Join(dir, "client.key"),
+						CAFile:     filepath.Join(dir, "ca.crt"),
+						ServerName: tt.serverName,
+					},
+				},
+			}, nil, nil, nil, nil, false, slog.Default())
+			require.NoError(t, err)
+			defer proxy.Shutdown()
+
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ __typename }"}`)))
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestProxy_NamedUpstreamTLSDefaults(t *testing.T) {
+	serverCA := tlstest.NewCA(t)
+	clientCA := tlstest.NewCA(t)
+	server := newMTLSUpstream(t, serverCA, clientCA)
+
+	dir := t.TempDir()
+	tlstest.WriteFile(t, filepath.Join(dir, "ca.crt"), serverCA.PEM)
+
+	cfg := DefaultConfig()
+	require.NoError(t, yaml.Unmarshal([]byte(`
+host: `+server.URL+`
+upstreams:
+  named:
+    host: `+server.URL+`
+    tls:
+      ca_file: `+filepath.Join(dir, "ca.crt")+`
+      auto_reload:
+        enabled: true
+`), &cfg))
+
+	proxy, err := NewProxy(cfg, nil, nil, nil, nil, false, slog.Default())
+	require.NoError(t, err)
+	defer proxy.Shutdown()
+
+	assert.Equal(t, TLSReloadConfig{
+		Enabled:  true,
+		Interval: cfg.TLS.AutoReload.Interval,
+	}, proxy.upstreams["named"].credentials.cfg.AutoReload)
+	assert.False(t, proxy.upstreams["default"].credentials.cfg.AutoReload.Enabled)
+}
+
+func TestTLSCredentials_Reload(t *testing.T) {
+	serverCA := tlstest.NewCA(t)
+	clientCA := tlstest.NewCA(t)
+	server := newMTLSUpstream(t, serverCA, clientCA)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	// start with the wrong CA, which is rotated to the right one later on
+	tlstest.WriteFile(t, caFile, clientCA.PEM)
+
+	certPEM, keyPEM := clientCA.Issue(t, x509.ExtKeyUsageClientAuth)
+	tlstest.WriteFile(t, filepath.Join(dir, "client.crt"), certPEM)
+	tlstest.WriteFile(t, filepath.Join(dir, "client.key"), keyPEM)
+
+	credentials, err := newTLSCredentials("default", "127.0.0.1", TLSConfig{
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+		CAFile:   caFile,
+	}, slog.Default())
+	require.NoError(t, err)
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: credentials.clientConfig()},
+	}
+
+	res, err := client.Get(server.URL) // nolint:noctx
+	if err == nil {
+		_ = res.Body.Close()
+	}
+	assert.Error(t, err)
+
+	tlstest.WriteFile(t, caFile, serverCA.PEM)
+	require.NoError(t, os.Chtimes(caFile, time.Now(), time.Now().Add(time.Minute)))
+	credentials.reloader.Reload()
+	client.CloseIdleConnections()
+
+	res, err = client.Get(server.URL) // nolint:noctx
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestNewTLSCredentials_Errors(t *testing.T) {
+	dir := t.TempDir()
+	tlstest.WriteFile(t, filepath.Join(dir, "empty.crt"), []byte("not a certificate"))
+
+	tests := []struct {
+		name string
+		cfg  TLSConfig
+		want error
+	}{
+		{
+			name: "invalid minimum version",
+			cfg:  TLSConfig{MinVersion: "1.4"},
+			want: tlsreload.ErrInvalidTLSVersion,
+		},
+		{
+			name: "certificate without key",
+			cfg:  TLSConfig{CertFile: "client.crt"},
+			want: ErrIncompleteKeyPair,
+		},
+		{
+			name: "CA bundle without certificates",
+			cfg:  TLSConfig{CAFile: filepath.Join(dir, "empty.crt")},
+			want: ErrInvalidCABundle,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTLSCredentials("default", "127.0.0.1", tt.cfg, slog.Default())
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
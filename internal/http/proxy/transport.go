@@ -2,24 +2,95 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"net"
 	"net/http"
 	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-func NewTransport(cfg Config) http.RoundTripper {
-	return otelhttp.NewTransport(
-		&http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   cfg.Timeout,
-				KeepAlive: cfg.KeepAlive,
-			}).DialContext,
+var (
+	openConnectionsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "open_connections",
+		Help:      "Amount of open connections to each upstream, both idle and in use",
+	},
+		[]string{"upstream"},
+	)
+	connectionCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "graphql_protect",
+		Subsystem: "proxy",
+		Name:      "connection_count",
+		Help:      "Amount of connections obtained for upstream requests, by whether an existing connection was reused",
+	},
+		[]string{"upstream", "reused"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(openConnectionsGauge, connectionCounter)
+}
+
+type HTTP2Config struct {
+	// Use HTTP/2 for https upstreams that support it
+	Enabled bool `yaml:"enabled"`
+	// Use HTTP/2 without TLS for http upstreams, without upgrading from HTTP/1.1 (prior knowledge).
+	// The upstream must support h2c, as HTTP/1.1 is not used for http upstreams anymore.
+	H2C bool `yaml:"h2c"`
+	// Send a ping when no frames were received on a connection for this duration. 0 disables pings.
+	ReadIdleTimeout time.Duration `yaml:"read_idle_timeout"`
+	// Close the connection when a ping isn't answered within this duration
+	PingTimeout time.Duration `yaml:"ping_timeout"`
+}
+
+func NewTransport(upstream string, cfg UpstreamConfig, tracing TracingConfig, tlsConfig *tls.Config) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   cfg.Timeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           countingDialer(upstream, dialer),
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		Protocols:             protocols(cfg.HTTP2),
+		HTTP2: &http.HTTP2Config{
+			SendPingTimeout: cfg.HTTP2.ReadIdleTimeout,
+			PingTimeout:     cfg.HTTP2.PingTimeout,
 		},
+	}
+
+	return otelhttp.NewTransport(
+		&poolMetricsTransport{upstream: upstream, next: transport},
 		otelhttp.WithSpanNameFormatter(spanNameFormatter),
-		otelhttp.WithClientTrace(newClientTrace(cfg.Tracing)))
+		otelhttp.WithClientTrace(newClientTrace(tracing)))
+}
+
+func protocols(cfg HTTP2Config) *http.Protocols {
+	protocols := &http.Protocols{}
+	switch {
+	case cfg.H2C:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	case cfg.Enabled:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+	}
+	return protocols
 }
 
 func spanNameFormatter(_ string, _ *http.Request) string {
@@ -31,3 +102,43 @@ func newClientTrace(conf TracingConfig) func(ctx context.Context) *httptrace.Cli
 		return otelhttptrace.NewClientTrace(ctx, otelhttptrace.WithRedactedHeaders(conf.RedactedHeaders...))
 	}
 }
+
+// poolMetricsTransport records whether requests reuse a pooled connection
+type poolMetricsTransport struct {
+	upstream string
+	next     http.RoundTripper
+}
+
+func (t *poolMetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			connectionCounter.WithLabelValues(t.upstream, strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// countingDialer keeps track of the amount of open connections to the upstream
+func countingDialer(upstream string, dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		openConnectionsGauge.WithLabelValues(upstream).Inc()
+		return &countedConn{Conn: conn, upstream: upstream}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	upstream string
+	once     sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		openConnectionsGauge.WithLabelValues(c.upstream).Dec()
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newProtoUpstream(t *testing.T, protocols *http.Protocols) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	server.Config.Protocols = protocols
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewTransport_Protocols(t *testing.T) {
+	h2c := &http.Protocols{}
+	h2c.SetUnencryptedHTTP2(true)
+	http1 := &http.Protocols{}
+	http1.SetHTTP1(true)
+	both := &http.Protocols{}
+	both.SetHTTP1(true)
+	both.SetUnencryptedHTTP2(true)
+
+	tests := []struct {
+		name      string
+		http2     HTTP2Config
+		upstream  *http.Protocols
+		wantProto string
+	}{
+		{
+			name:      "uses HTTP/1.1 for plaintext upstreams by default",
+			http2:     HTTP2Config{Enabled: true},
+			upstream:  both,
+			wantProto: "HTTP/1.1",
+		},
+		{
+			name:      "uses HTTP/2 with prior knowledge when h2c is enabled",
+			http2:     HTTP2Config{Enabled: true, H2C: true},
+			upstream:  h2c,
+			wantProto: "HTTP/2.0",
+		},
+		{
+			name:      "uses HTTP/1.1 when HTTP/2 is disabled",
+			http2:     HTTP2Config{Enabled: false},
+			upstream:  http1,
+			wantProto: "HTTP/1.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newProtoUpstream(t, tt.upstream)
+
+			client := &http.Client{
+				Transport: NewTransport("default", UpstreamConfig{
+					Timeout:   time.Second,
+					KeepAlive: time.Second,
+					HTTP2:     tt.http2,
+				}, TracingConfig{}, nil),
+			}
+
+			res, err := client.Get(server.URL) // nolint:noctx
+			require.NoError(t, err)
+			defer res.Body.Close()
+
+			body, _ := io.ReadAll(res.Body)
+			assert.Equal(t, tt.wantProto, string(body))
+		})
+	}
+}
+
+func TestNewTransport_PoolMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{}}`))
+	}))
+	defer server.Close()
+
+	upstream := "pool-metrics"
+	newConns := testutil.ToFloat64(connectionCounter.WithLabelValues(upstream, "false"))
+	reusedConns := testutil.ToFloat64(connectionCounter.WithLabelValues(upstream, "true"))
+	client := &http.Client{
+		Transport: NewTransport(upstream, UpstreamConfig{
+			Timeout:             time.Second,
+			KeepAlive:           time.Second,
+			MaxIdleConnsPerHost: 10,
+		}, TracingConfig{}, nil),
+	}
+
+	for i := 0; i < 3; i++ {
+		res, err := client.Post(server.URL, "application/json", strings.NewReader(`{"query":"{ __typename }"}`)) // nolint:noctx
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+
+	assert.Equal(t, newConns+1, testutil.ToFloat64(connectionCounter.WithLabelValues(upstream, "false")))
+	assert.Equal(t, reusedConns+2, testutil.ToFloat64(connectionCounter.WithLabelValues(upstream, "true")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(openConnectionsGauge.WithLabelValues(upstream)))
+
+	server.CloseClientConnections()
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(openConnectionsGauge.WithLabelValues(upstream)) == 0
+	}, time.Second, 10*time.Millisecond)
+}
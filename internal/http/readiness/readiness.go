@@ -1,13 +1,137 @@
 package readiness
 
 import (
-	"fmt"
+	"encoding/json"
 	"net/http"
+	"sync/atomic"
+	"time"
 )
 
-func NewReadinessHandler() http.HandlerFunc {
+type Config struct {
+	// Report not ready while an upstream with active health checks enabled is unhealthy
+	Upstreams bool `yaml:"upstreams"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Upstreams: false,
+	}
+}
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Component describes the state of a dependency of protect
+type Component struct {
+	Status string `json:"status"`
+	// Time the component was last loaded successfully
+	LastReload *time.Time `json:"last_reload,omitempty"`
+	// Time the component was last checked
+	LastCheck *time.Time `json:"last_check,omitempty"`
+	// Error of the latest reload or check, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// Check returns the current state of a component
+type Check func() Component
+
+type response struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components,omitempty"`
+}
+
+// NewReadinessHandler responds with a 200 when all components are up, and a 503 otherwise.
+// The body describes the state of every component.
+func NewReadinessHandler(checks map[string]Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		res := response{
+			Status:     StatusUp,
+			Components: make(map[string]Component, len(checks)),
+		}
+		for name, check := range checks {
+			component := check()
+			if component.Status != StatusUp {
+				res.Status = StatusDown
+			}
+			res.Components[name] = component
+		}
+
+		status := http.StatusOK
+		if res.Status != StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+		write(w, status, res)
+	}
+}
+
+// NewLivenessHandler responds with a 200 as long as protect is able to serve requests.
+// It doesn't depend on any components, so an unavailable dependency doesn't cause restarts.
+func NewLivenessHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = fmt.Fprintf(w, "I'm ready!")
+		write(w, http.StatusOK, response{Status: StatusUp})
+	}
+}
+
+func write(w http.ResponseWriter, status int, res response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// Loaded returns the state of a component that has to be loaded at least once before protect is ready.
+// A failed reload is reported, but doesn't make the component unready as the previously loaded state remains in use.
+func Loaded(lastReload time.Time, err error) Component {
+	c := Component{
+		Status: StatusDown,
+	}
+	if !lastReload.IsZero() {
+		c.Status = StatusUp
+		c.LastReload = &lastReload
+	}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// Healthy returns the state of a component that is checked periodically
+func Healthy(healthy bool, lastCheck time.Time) Component {
+	c := Component{
+		Status: StatusDown,
+	}
+	if healthy {
+		c.Status = StatusUp
+	}
+	if !lastCheck.IsZero() {
+		c.LastCheck = &lastCheck
+	}
+	return c
+}
+
+// Draining reports protect as not ready once shutdown has started, so load balancers stop sending traffic before the listener is closed
+type Draining struct {
+	draining atomic.Bool
+}
+
+func NewDraining() *Draining {
+	return &Draining{}
+}
+
+func (d *Draining) Start() {
+	d.draining.Store(true)
+}
+
+func (d *Draining) Check() Component {
+	if d.draining.Load() {
+		return Component{
+			Status: StatusDown,
+			Error:  "draining connections before shutdown",
+		}
+	}
+	return Component{
+		Status: StatusUp,
 	}
 }
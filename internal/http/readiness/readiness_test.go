@@ -1,24 +1,93 @@
 package readiness
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/stretchr/testify/assert"
-	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestNewReadinessHandler(t *testing.T) {
+	lastReload := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
 	tests := []struct {
-		name string
-		want func(t *testing.T, res *http.Response)
+		name       string
+		checks     map[string]Check
+		wantStatus int
+		want       func(t *testing.T, res response)
 	}{
 		{
-			name: "Sends a 200 status code with an \"I'm ready!\" body",
-			want: func(t *testing.T, res *http.Response) {
-				assert.Equal(t, http.StatusOK, res.StatusCode)
-				payload, _ := io.ReadAll(res.Body)
-				assert.Equal(t, "I'm ready!", string(payload))
+			name:       "Ready without components",
+			checks:     map[string]Check{},
+			wantStatus: http.StatusOK,
+			want: func(t *testing.T, res response) {
+				assert.Equal(t, StatusUp, res.Status)
+			},
+		},
+		{
+			name: "Ready when all components are up",
+			checks: map[string]Check{
+				"schema": func() Component {
+					return Loaded(lastReload, nil)
+				},
+				"upstream/default": func() Component {
+					return Healthy(true, lastReload)
+				},
+			},
+			wantStatus: http.StatusOK,
+			want: func(t *testing.T, res response) {
+				assert.Equal(t, StatusUp, res.Status)
+				assert.Equal(t, StatusUp, res.Components["schema"].Status)
+				assert.Equal(t, lastReload, *res.Components["schema"].LastReload)
+				assert.Equal(t, lastReload, *res.Components["upstream/default"].LastCheck)
+			},
+		},
+		{
+			name: "Not ready when a component was never loaded",
+			checks: map[string]Check{
+				"schema": func() Component {
+					return Loaded(lastReload, nil)
+				},
+				"trusted_documents": func() Component {
+					return Loaded(time.Time{}, errors.New("bucket not found"))
+				},
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			want: func(t *testing.T, res response) {
+				assert.Equal(t, StatusDown, res.Status)
+				assert.Equal(t, StatusUp, res.Components["schema"].Status)
+				assert.Equal(t, StatusDown, res.Components["trusted_documents"].Status)
+				assert.Nil(t, res.Components["trusted_documents"].LastReload)
+				assert.Equal(t, "bucket not found", res.Components["trusted_documents"].Error)
+			},
+		},
+		{
+			name: "Ready when a reload failed after a successful load",
+			checks: map[string]Check{
+				"trusted_documents": func() Component {
+					return Loaded(lastReload, errors.New("bucket not found"))
+				},
+			},
+			wantStatus: http.StatusOK,
+			want: func(t *testing.T, res response) {
+				assert.Equal(t, StatusUp, res.Components["trusted_documents"].Status)
+				assert.Equal(t, "bucket not found", res.Components["trusted_documents"].Error)
+			},
+		},
+		{
+			name: "Not ready when an upstream is unhealthy",
+			checks: map[string]Check{
+				"upstream/default": func() Component {
+					return Healthy(false, lastReload)
+				},
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			want: func(t *testing.T, res response) {
+				assert.Equal(t, StatusDown, res.Status)
+				assert.Equal(t, StatusDown, res.Components["upstream/default"].Status)
 			},
 		},
 	}
@@ -26,15 +95,45 @@ func TestNewReadinessHandler(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest("GET", "/", nil)
 
-			handler := NewReadinessHandler()
+			handler := NewReadinessHandler(tt.checks)
 			resp := httptest.NewRecorder()
 
 			handler.ServeHTTP(resp, req)
 
-			res := resp.Result()
-			defer res.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.Code)
+			assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
 
+			var res response
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
 			tt.want(t, res)
 		})
 	}
 }
+
+func TestNewLivenessHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	resp := httptest.NewRecorder()
+
+	NewLivenessHandler().ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"status":"up"}`, resp.Body.String())
+}
+
+func TestDraining(t *testing.T) {
+	draining := NewDraining()
+	handler := NewReadinessHandler(map[string]Check{
+		"server": draining.Check,
+	})
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	draining.Start()
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Contains(t, resp.Body.String(), "draining connections before shutdown")
+}
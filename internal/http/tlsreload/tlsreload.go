@@ -0,0 +1,125 @@
+package tlsreload
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var ErrInvalidTLSVersion = errors.New("invalid minimum TLS version")
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval at which the files are checked for changes
+	Interval time.Duration `yaml:"interval"`
+}
+
+// MinVersion parses a minimum TLS version, one of 1.0, 1.1, 1.2 or 1.3. TLS 1.2 is used when empty.
+func MinVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTLSVersion, version)
+	}
+	return v, nil
+}
+
+// Reloader loads certificate files, and loads them again when they change on disk
+type Reloader struct {
+	cfg   Config
+	files []string
+	load  func() error
+	// reloaded is called with the result of every reload after the initial load
+	reloaded func(err error)
+	modTimes map[string]time.Time
+	done     chan bool
+}
+
+// New loads the files, empty file names are ignored
+func New(cfg Config, files []string, load func() error, reloaded func(err error)) (*Reloader, error) {
+	r := &Reloader{
+		cfg:      cfg,
+		load:     load,
+		reloaded: reloaded,
+		modTimes: map[string]time.Time{},
+		done:     make(chan bool, 1),
+	}
+	for _, file := range files {
+		if file != "" {
+			r.files = append(r.files, file)
+		}
+	}
+
+	r.changed()
+	if err := load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// changed records the modification times of the files, and returns whether any of them changed
+func (r *Reloader) changed() bool {
+	changed := false
+	for _, file := range r.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			r.modTimes[file] = info.ModTime()
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Reload loads the files if any of them changed since they were last loaded
+func (r *Reloader) Reload() {
+	if !r.changed() {
+		return
+	}
+	r.reloaded(r.load())
+}
+
+func (r *Reloader) enabled() bool {
+	return r.cfg.Enabled && r.cfg.Interval > 0
+}
+
+// Start checks the files for changes periodically, when enabled
+func (r *Reloader) Start() {
+	if !r.enabled() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(r.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.done:
+				return
+			case <-ticker.C:
+				r.Reload()
+			}
+		}
+	}()
+}
+
+func (r *Reloader) Shutdown() {
+	if !r.enabled() {
+		return
+	}
+	r.done <- true
+}
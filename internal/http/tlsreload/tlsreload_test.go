@@ -0,0 +1,56 @@
+package tlsreload
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinVersion(t *testing.T) {
+	version, err := MinVersion("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), version)
+
+	version, err = MinVersion("1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), version)
+
+	_, err = MinVersion("1.4")
+	assert.ErrorIs(t, err, ErrInvalidTLSVersion)
+}
+
+func TestReloader(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cert.pem")
+	require.NoError(t, os.WriteFile(file, []byte("cert"), 0o600))
+
+	var loads int
+	var loadErr error
+	var results []error
+	reloader, err := New(Config{}, []string{file, ""}, func() error {
+		loads++
+		return loadErr
+	}, func(err error) {
+		results = append(results, err)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, loads)
+
+	// nothing changed
+	reloader.Reload()
+	assert.Equal(t, 1, loads)
+
+	loadErr = errors.New("invalid certificate")
+	require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Minute)))
+	reloader.Reload()
+	assert.Equal(t, 2, loads)
+	assert.Equal(t, []error{loadErr}, results)
+
+	_, err = New(Config{}, []string{file}, func() error { return loadErr }, nil)
+	assert.ErrorIs(t, err, loadErr)
+}